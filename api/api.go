@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -34,6 +36,12 @@ const cacheScanTime = time.Minute
 // a DomainResult object from the checker.
 type checkPerformer func(API, string) (checker.DomainResult, error)
 
+// Type for checking the mail submission servers of an input domain.
+type submissionCheckPerformer func(API, string) checker.SubmissionResult
+
+// Type for running some of the hostname checks against an input domain.
+type subsetCheckPerformer func(API, string, []string) checker.DomainResult
+
 // API is the HTTP API that this service provides.
 // All requests respond with an response JSON, with fields:
 // {
@@ -44,12 +52,21 @@ type checkPerformer func(API, string) (checker.DomainResult, error)
 // Any POST request accepts either URL query parameters or data value parameters,
 // and prefers the latter if both are present.
 type API struct {
-	Database            db.Database
-	checkDomainOverride checkPerformer
-	List                PolicyList
-	DontScan            map[string]bool
-	Emailer             EmailSender
-	Templates           map[string]*template.Template
+	Database                db.Database
+	checkDomainOverride     checkPerformer
+	checkSubmissionOverride submissionCheckPerformer
+	checkSubsetOverride     subsetCheckPerformer
+	List                    PolicyList
+	DontScan                map[string]bool
+	Emailer                 EmailSender
+	Templates               map[string]*template.Template
+	// RootCAs and TrustStore are passed on to the checker.Checker used
+	// for scans. If RootCAs is nil, the system's roots are used.
+	RootCAs    *x509.CertPool
+	TrustStore string
+	// TLSRPTSecret is the bearer token required to POST TLS-RPT reports. If
+	// it's empty, reports can only be imported from mail.
+	TLSRPTSecret string
 }
 
 // PolicyList interface wraps a policy-list like structure.
@@ -83,6 +100,20 @@ func (api *API) checkDomain(domain string) (checker.DomainResult, error) {
 	return api.checkDomainOverride(*api, domain)
 }
 
+func (api *API) checkSubmission(domain string) checker.SubmissionResult {
+	if api.checkSubmissionOverride == nil {
+		return defaultSubmissionCheck(*api, domain)
+	}
+	return api.checkSubmissionOverride(*api, domain)
+}
+
+func (api *API) checkSubset(domain string, checks []string) checker.DomainResult {
+	if api.checkSubsetOverride == nil {
+		return defaultSubsetCheck(*api, domain, checks)
+	}
+	return api.checkSubsetOverride(*api, domain, checks)
+}
+
 func (api *API) wrapper(handler apiHandler) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		response := handler(r)
@@ -108,6 +139,8 @@ func pingHandler(w http.ResponseWriter, r *http.Request) {
 func (api *API) RegisterHandlers(mux *http.ServeMux) http.Handler {
 	mux.HandleFunc("/sns", HandleSESNotification(api.Database))
 	mux.HandleFunc("/api/scan", api.wrapper(api.scan))
+	mux.HandleFunc("/api/scan/diff", api.wrapper(api.scanDiff))
+	mux.HandleFunc("/api/tlsrpt", api.wrapper(api.tlsrpt))
 	// =====================================================================
 	// No longer exposing these endpoints due to STARTTLS Everywhere sunset.
 	// =====================================================================
@@ -119,6 +152,10 @@ func (api *API) RegisterHandlers(mux *http.ServeMux) http.Handler {
 	return middleware(mux)
 }
 
+// scanDeadline bounds the total time spent on a single domain scan. Checks
+// that are still running once it passes are reported as cancelled.
+const scanDeadline = 30 * time.Second
+
 func defaultCheck(api API, domain string) (checker.DomainResult, error) {
 	policyChan := models.Domain{Name: domain}.AsyncPolicyListCheck(api.Database, api.List)
 	c := checker.Checker{
@@ -126,17 +163,41 @@ func defaultCheck(api API, domain string) (checker.DomainResult, error) {
 			ScanStore:  api.Database,
 			ExpireTime: 5 * time.Minute,
 		},
-		Timeout: 3 * time.Second,
+		RootCAs:    api.RootCAs,
+		TrustStore: api.TrustStore,
 	}
-	result := c.CheckDomain(domain, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), scanDeadline)
+	defer cancel()
+	result := c.CheckDomainContext(ctx, domain, nil)
 	policyResult := <-policyChan
 	result.ExtraResults["policylist"] = &policyResult
 	return result, nil
 }
 
+func defaultSubmissionCheck(api API, domain string) checker.SubmissionResult {
+	c := checker.Checker{RootCAs: api.RootCAs, TrustStore: api.TrustStore}
+	ctx, cancel := context.WithTimeout(context.Background(), scanDeadline)
+	defer cancel()
+	return c.CheckSubmissionContext(ctx, domain)
+}
+
+func defaultSubsetCheck(api API, domain string, checks []string) checker.DomainResult {
+	c := checker.Checker{RootCAs: api.RootCAs, TrustStore: api.TrustStore, Checks: checks}
+	ctx, cancel := context.WithTimeout(context.Background(), scanDeadline)
+	defer cancel()
+	return c.CheckDomainContext(ctx, domain, nil)
+}
+
 // Scan is the handler for /api/scan.
 //   POST /api/scan
 //        domain: Mail domain to scan.
+//        submission: If "true", scans the domain's mail submission servers
+//                    instead, and sets a checker.SubmissionResult JSON as
+//                    the response. These scans aren't stored.
+//        checks: Comma-separated hostname checks to run, along with the
+//                checks they require, instead of all of them. Sets a
+//                checker.DomainResult JSON as the response. These scans
+//                aren't stored either.
 //        Scans domain and returns data from it.
 //   GET /api/scan?domain=<domain>
 //        Retrieves most recent scan for domain.
@@ -152,6 +213,31 @@ func (api API) scan(r *http.Request) response {
 			return response{StatusCode: http.StatusTooManyRequests}
 		}
 	}
+	if r.FormValue("submission") != "" {
+		submission, err := strconv.ParseBool(r.FormValue("submission"))
+		if err != nil {
+			return response{StatusCode: http.StatusBadRequest,
+				Message: "submission must be true or false"}
+		}
+		if submission {
+			if r.Method != http.MethodPost {
+				return response{StatusCode: http.StatusMethodNotAllowed,
+					Message: "submission scans aren't stored, so they're only available with POST"}
+			}
+			return response{StatusCode: http.StatusOK, Response: api.checkSubmission(domain)}
+		}
+	}
+	if r.FormValue("checks") != "" {
+		checks := strings.Split(r.FormValue("checks"), ",")
+		if err := checker.ValidateChecks(checks); err != nil {
+			return response{StatusCode: http.StatusBadRequest, Message: err.Error()}
+		}
+		if r.Method != http.MethodPost {
+			return response{StatusCode: http.StatusMethodNotAllowed,
+				Message: "scans of some of the checks aren't stored, so they're only available with POST"}
+		}
+		return response{StatusCode: http.StatusOK, Response: api.checkSubset(domain, checks)}
+	}
 	// POST: Force scan to be conducted
 	if r.Method == http.MethodPost {
 		// 0. If last scan was recent and on same scan version, return cached scan.
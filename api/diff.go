@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/EFForg/starttls-backend/models"
+)
+
+// ScanDiff is the handler for /api/scan/diff, which reports what changed
+// between two stored scans of a domain.
+//   GET /api/scan/diff?domain=<domain>
+//        to (optional): Compare the latest scan at or before this time, in
+//                       RFC 3339 format. Defaults to the latest scan.
+//        from (optional): Compare against the latest scan at or before this
+//                         time. Defaults to the scan before the one
+//                         selected by to. Fails with 404 unless this selects
+//                         an earlier scan than to.
+//        Sets a models.ScanDiff JSON as the response.
+func (api API) scanDiff(r *http.Request) response {
+	if r.Method != http.MethodGet {
+		return response{StatusCode: http.StatusMethodNotAllowed,
+			Message: "/api/scan/diff only accepts GET requests"}
+	}
+	domain, err := getASCIIDomain(r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	from, err := getTime("from", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	to, err := getTime("to", r)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return badRequest("from must not be after to")
+	}
+	scans, err := api.Database.GetAllScans(domain)
+	if err != nil {
+		return serverError(err.Error())
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Timestamp.Before(scans[j].Timestamp) })
+
+	toIndex := scanAt(scans, to)
+	if toIndex < 0 {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("no scans of %s to compare", domain)}
+	}
+	fromIndex := toIndex - 1
+	if !from.IsZero() {
+		fromIndex = scanAt(scans, from)
+	}
+	if fromIndex < 0 || fromIndex >= toIndex {
+		return response{StatusCode: http.StatusNotFound,
+			Message: fmt.Sprintf("no earlier scan of %s to compare against", domain)}
+	}
+	return response{StatusCode: http.StatusOK, Response: models.DiffScans(scans[fromIndex], scans[toIndex])}
+}
+
+// scanAt returns the index of the latest of scans, sorted by time, at or
+// before t, or of the latest scan if t is zero. It returns -1 if there's no
+// such scan.
+func scanAt(scans []models.Scan, t time.Time) int {
+	if t.IsZero() {
+		return len(scans) - 1
+	}
+	return sort.Search(len(scans), func(i int) bool { return scans[i].Timestamp.After(t) }) - 1
+}
+
+// Retrieves `param` as an RFC 3339 time from `http.Request` r. If `param`
+// isn't specified, returns the zero time.
+func getTime(param string, r *http.Request) (time.Time, error) {
+	value := r.FormValue(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("expected query parameter %s to be an RFC 3339 time, like 2019-01-02T15:04:05Z, was %s", param, value)
+	}
+	return t, nil
+}
@@ -109,6 +109,71 @@ func TestBasicScan(t *testing.T) {
 	}
 }
 
+func TestSubmissionScan(t *testing.T) {
+	defer teardown()
+	api.checkSubmissionOverride = func(api API, domain string) checker.SubmissionResult {
+		return checker.SubmissionResult{Result: checker.MakeResult("submission"), Domain: domain}
+	}
+	defer func() { api.checkSubmissionOverride = nil }()
+
+	data := url.Values{}
+	data.Set("domain", "eff.org")
+	data.Set("submission", "true")
+	resp, _ := http.PostForm(server.URL+"/api/scan", data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST to api/scan with submission failed with error %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"name":"submission"`) {
+		t.Errorf("Expected a submission result, got %s", body)
+	}
+	// Submission scans aren't stored.
+	if _, err := api.Database.GetLatestScan("eff.org"); err == nil {
+		t.Errorf("Submission scans shouldn't be stored")
+	}
+
+	resp, _ = http.Get(server.URL + "/api/scan?domain=eff.org&submission=true")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET api/scan with submission should fail with %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestSubsetScan(t *testing.T) {
+	defer teardown()
+	var requested []string
+	api.checkSubsetOverride = func(api API, domain string, checks []string) checker.DomainResult {
+		requested = checks
+		return checker.DomainResult{Domain: domain}
+	}
+	defer func() { api.checkSubsetOverride = nil }()
+
+	data := url.Values{}
+	data.Set("domain", "eff.org")
+	data.Set("checks", "starttls,certificate")
+	resp, _ := http.PostForm(server.URL+"/api/scan", data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST to api/scan with checks failed with error %d", resp.StatusCode)
+	}
+	if strings.Join(requested, ",") != "starttls,certificate" {
+		t.Errorf("Expected the selected checks to be run, got %v", requested)
+	}
+	// Scans of some of the checks aren't stored.
+	if _, err := api.Database.GetLatestScan("eff.org"); err == nil {
+		t.Errorf("Scans of some of the checks shouldn't be stored")
+	}
+
+	data.Set("checks", "starttls,bogus")
+	resp, _ = http.PostForm(server.URL+"/api/scan", data)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST api/scan with an unknown check should fail with %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp, _ = http.Get(server.URL + "/api/scan?domain=eff.org&checks=starttls")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET api/scan with checks should fail with %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
 func TestDontScanList(t *testing.T) {
 	defer teardown()
 
@@ -141,3 +206,77 @@ func TestScanCached(t *testing.T) {
 		t.Fatalf("Scan expected to have been cached, not reperformed\n")
 	}
 }
+
+func TestScanDiff(t *testing.T) {
+	defer teardown()
+
+	resp, _ := http.Get(server.URL + "/api/scan/diff?domain=eff.org")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET api/scan/diff without scans should fail with %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i, mode := range []string{"enforce", "enforce", "testing"} {
+		data := checker.NewSampleDomainResult("eff.org")
+		data.MTASTSResult.Mode = mode
+		scan := models.Scan{
+			Domain:    "eff.org",
+			Data:      data,
+			Timestamp: start.Add(time.Duration(i) * time.Hour),
+			Version:   models.ScanVersion,
+		}
+		if err := api.Database.PutScan(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// By default, the latest scan is compared with the one before it.
+	resp, _ = http.Get(server.URL + "/api/scan/diff?domain=eff.org")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET api/scan/diff failed with error %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	diff := models.ScanDiff{}
+	if err := json.Unmarshal(body, &response{Response: &diff}); err != nil {
+		t.Fatalf("Returned invalid JSON object:%v\n%v\n", string(body), err)
+	}
+	if !diff.From.Equal(start.Add(time.Hour)) || !diff.To.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("Expected the last two scans to be compared, got %v and %v", diff.From, diff.To)
+	}
+	if diff.Changes.MTASTS == nil || diff.Changes.MTASTS.Mode == nil || diff.Changes.MTASTS.Mode.To != "testing" {
+		t.Errorf("Expected the MTA-STS mode change to be reported, got %s", body)
+	}
+
+	// The first two scans are the same.
+	resp, _ = http.Get(server.URL + "/api/scan/diff?domain=eff.org&from=2019-01-01T00:00:00Z&to=2019-01-01T01:30:00Z")
+	body, _ = ioutil.ReadAll(resp.Body)
+	diff = models.ScanDiff{}
+	if err := json.Unmarshal(body, &response{Response: &diff}); err != nil {
+		t.Fatalf("Returned invalid JSON object:%v\n%v\n", string(body), err)
+	}
+	if diff.Changes.MTASTS != nil {
+		t.Errorf("Expected no changes between the first two scans, got %s", body)
+	}
+
+	for _, query := range []string{"from=yesterday", "from=2019-01-02T00:00:00Z&to=2019-01-01T00:00:00Z"} {
+		resp, _ = http.Get(server.URL + "/api/scan/diff?domain=eff.org&" + query)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET api/scan/diff?%s should fail with %d, got %d", query, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+	resp, _ = http.Get(server.URL + "/api/scan/diff?domain=eff.org&from=2018-12-31T00:00:00Z")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET api/scan/diff before the first scan should fail with %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	// from and to select the same scan.
+	for _, query := range []string{"from=2019-01-01T01:10:00Z&to=2019-01-01T01:30:00Z", "from=2019-01-01T03:00:00Z"} {
+		resp, _ = http.Get(server.URL + "/api/scan/diff?domain=eff.org&" + query)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET api/scan/diff?%s should fail with %d, got %d", query, http.StatusNotFound, resp.StatusCode)
+		}
+	}
+	resp, _ = http.PostForm(server.URL+"/api/scan/diff", url.Values{"domain": {"eff.org"}})
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST api/scan/diff should fail with %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
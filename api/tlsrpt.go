@@ -0,0 +1,81 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EFForg/starttls-backend/tlsrpt"
+)
+
+// TLSRPT is the handler for /api/tlsrpt, which receives SMTP TLS aggregate
+// reports (RFC 8460) and summarizes them for policy domains.
+//   POST /api/tlsrpt
+//        Header: Authorization: Bearer <API.TLSRPTSecret>. Reports can only
+//        be posted by a trusted relay that knows the secret; without one,
+//        they're imported from mail with tlsrpt-import instead.
+//        Body: a report, with Content-Type application/tlsrpt+json or
+//        application/tlsrpt+gzip.
+//   GET /api/tlsrpt?domain=<domain>
+//        token: The validation token emailed to the domain's postmaster.
+//        days (optional, default 30): How many days of reports to summarize.
+//        Sets a tlsrpt.Summary JSON as the response.
+func (api API) tlsrpt(r *http.Request) response {
+	if r.Method == http.MethodPost {
+		if api.TLSRPTSecret == "" {
+			return response{StatusCode: http.StatusForbidden,
+				Message: "reports can't be posted to this server; deliver them by email instead"}
+		}
+		if !secretsEqual(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), api.TLSRPTSecret) {
+			return response{StatusCode: http.StatusUnauthorized,
+				Message: "posting reports requires a valid Authorization header"}
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (mediaType != tlsrpt.MediaTypeJSON && mediaType != tlsrpt.MediaTypeGzip) {
+			return response{StatusCode: http.StatusUnsupportedMediaType,
+				Message: fmt.Sprintf("/api/tlsrpt only accepts %s and %s reports", tlsrpt.MediaTypeJSON, tlsrpt.MediaTypeGzip)}
+		}
+		report, err := tlsrpt.ParseMediaType(mediaType, r.Body)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		if err := api.Database.PutTLSRPTReport(report); err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: fmt.Sprintf("Received report %s.", report.ReportID)}
+	}
+	if r.Method == http.MethodGet {
+		domain, err := getASCIIDomain(r)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		// Only the domain's owner, who received its validation token, can
+		// see which senders fail to deliver to it.
+		token, err := api.Database.GetTokenByDomain(domain)
+		if err != nil || !secretsEqual(r.FormValue("token"), token) {
+			return response{StatusCode: http.StatusForbidden,
+				Message: fmt.Sprintf("reports for %s require the validation token sent to its postmaster", domain)}
+		}
+		days, err := getInt("days", r, 1, 367, 30)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		summary, err := tlsrpt.GetSummary(api.Database, domain, since)
+		if err != nil {
+			return serverError(err.Error())
+		}
+		return response{StatusCode: http.StatusOK, Response: summary}
+	}
+	return response{StatusCode: http.StatusMethodNotAllowed,
+		Message: "/api/tlsrpt only accepts POST and GET requests"}
+}
+
+// secretsEqual compares a secret from a request with the expected one in
+// constant time. An empty secret never matches.
+func secretsEqual(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
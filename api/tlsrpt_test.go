@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testTLSRPTReport = `{
+  "organization-name": "Company-X",
+  "date-range": {
+    "start-datetime": "2016-04-01T00:00:00Z",
+    "end-datetime": "2099-04-01T23:59:59Z"
+  },
+  "report-id": "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+  "policies": [{
+    "policy": {"policy-type": "sts", "policy-domain": "company-y.example"},
+    "summary": {
+      "total-successful-session-count": 5326,
+      "total-failure-session-count": 303
+    },
+    "failure-details": [{
+      "result-type": "certificate-expired",
+      "failed-session-count": 303
+    }]
+  }]
+}`
+
+const testTLSRPTSecret = "relay-secret"
+
+func postTLSRPTReport(t *testing.T, contentType, secret, body string) *http.Response {
+	req, err := http.NewRequest("POST", server.URL+"/api/tlsrpt", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestPostTLSRPTReport(t *testing.T) {
+	defer teardown()
+	api.TLSRPTSecret = testTLSRPTSecret
+	defer func() { api.TLSRPTSecret = "" }()
+	resp := postTLSRPTReport(t, "application/tlsrpt+json", testTLSRPTSecret, testTLSRPTReport)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /api/tlsrpt failed with error %d", resp.StatusCode)
+	}
+
+	token, err := api.Database.PutToken("company-y.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(server.URL + "/api/tlsrpt?domain=company-y.example&token=" + token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/tlsrpt failed with error %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	var summary struct {
+		Response struct {
+			Reports        int              `json:"reports"`
+			FailedSessions int64            `json:"failed_sessions"`
+			FailureTypes   map[string]int64 `json:"failure_types"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(body, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Response.Reports != 1 || summary.Response.FailureTypes["certificate-expired"] != 303 {
+		t.Errorf("Unexpected summary %s", string(body))
+	}
+}
+
+func TestTLSRPTRequiresAuthorization(t *testing.T) {
+	defer teardown()
+	resp := postTLSRPTReport(t, "application/tlsrpt+json", testTLSRPTSecret, testTLSRPTReport)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected POST without a configured secret to fail with 403, got %d", resp.StatusCode)
+	}
+	api.TLSRPTSecret = testTLSRPTSecret
+	defer func() { api.TLSRPTSecret = "" }()
+	for _, secret := range []string{"", "wrong"} {
+		resp = postTLSRPTReport(t, "application/tlsrpt+json", secret, testTLSRPTReport)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected POST with secret %q to fail with 401, got %d", secret, resp.StatusCode)
+		}
+	}
+
+	if _, err := api.Database.PutToken("company-y.example"); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"", "&token=wrong"} {
+		resp, err := http.Get(server.URL + "/api/tlsrpt?domain=company-y.example" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected GET /api/tlsrpt?domain=company-y.example%s to fail with 403, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestPostTLSRPTReportBadRequest(t *testing.T) {
+	api.TLSRPTSecret = testTLSRPTSecret
+	defer func() { api.TLSRPTSecret = "" }()
+	resp := postTLSRPTReport(t, "application/tlsrpt+json", testTLSRPTSecret, "{}")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected invalid report to fail with 400, got %d", resp.StatusCode)
+	}
+	resp = postTLSRPTReport(t, "application/json", testTLSRPTSecret, testTLSRPTReport)
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected unsupported media type to fail with 415, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,336 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// AddressResult wraps the results of the checks against one of the IP
+// addresses of a hostname.
+type AddressResult struct {
+	*Result
+	Address string `json:"address"`
+	// Network is either "ipv4" or "ipv6".
+	Network string `json:"network"`
+	// The certificate chain presented by this address, starting with the leaf.
+	Certificates []CertificateSummary `json:"certificates,omitempty"`
+	// The SCTs and stapled OCSP response presented with the certificate.
+	CertificateStatus *CertificateStatus `json:"certificate_status,omitempty"`
+	// Whether senders enforcing MTA-STS would accept the certificate.
+	PKIX *PKIXValidation `json:"pkix,omitempty"`
+	// The TLS versions and cipher suites accepted by this address. Only
+	// enumerated by the tls-enumeration check.
+	TLSSupport *TLSSupport `json:"tls_support,omitempty"`
+	// What the server told us about itself.
+	Capabilities *SMTPCapabilities `json:"capabilities,omitempty"`
+	// unreachable is true if this machine has no route to Address, for
+	// instance when scanning IPv6 addresses from a host without IPv6.
+	unreachable bool
+}
+
+// MarshalJSON prevents AddressResult from inheriting the version of
+// MarshalJSON implemented by Result.
+func (a AddressResult) MarshalJSON() ([]byte, error) {
+	type FakeResult Result
+	return json.Marshal(struct {
+		FakeResult
+		StatusText        string               `json:"status_text,omitempty"`
+		Address           string               `json:"address"`
+		Network           string               `json:"network"`
+		Certificates      []CertificateSummary `json:"certificates,omitempty"`
+		CertificateStatus *CertificateStatus   `json:"certificate_status,omitempty"`
+		PKIX              *PKIXValidation      `json:"pkix,omitempty"`
+		TLSSupport        *TLSSupport          `json:"tls_support,omitempty"`
+		Capabilities      *SMTPCapabilities    `json:"capabilities,omitempty"`
+	}{
+		FakeResult:        FakeResult(*a.Result),
+		StatusText:        a.StatusText(),
+		Address:           a.Address,
+		Network:           a.Network,
+		Certificates:      a.Certificates,
+		CertificateStatus: a.CertificateStatus,
+		PKIX:              a.PKIX,
+		TLSSupport:        a.TLSSupport,
+		Capabilities:      a.Capabilities,
+	})
+}
+
+func addressNetwork(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// networkUnreachable returns true if err indicates that this machine has no
+// route to the network being dialed.
+func networkUnreachable(err error) bool {
+	if replayErr, ok := err.(*replayError); ok {
+		return replayErr.unreachable
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ENETUNREACH
+		}
+	}
+	return false
+}
+
+// lookupAddresses returns the addresses to dial for hostname, in host:port
+// form. If hostname doesn't specify a port, port 25 is used.
+func (c *Checker) lookupAddresses(ctx context.Context, hostname string) ([]string, error) {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, "25"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{net.JoinHostPort(host, port)}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	ips, _, err := c.resolver().LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no A or AAAA records found for %s", host)
+	}
+	addresses := []string{}
+	for _, ip := range ips {
+		addresses = append(addresses, net.JoinHostPort(ip.String(), port))
+	}
+	return addresses, nil
+}
+
+// addressCheck is the state shared by the hostname checks of an address.
+type addressCheck struct {
+	c          *Checker
+	ctx        context.Context
+	domain     string
+	hostname   string
+	address    string
+	tlsa       []TLSARecord
+	tlsaSecure bool
+	// result collects the results of the checks, and what they learn about
+	// the address.
+	result *AddressResult
+	// client is connected by the connectivity check, and negotiates TLS in
+	// the starttls check. recorder records what the server sent it.
+	client   *smtpClient
+	recorder *recordingConn
+}
+
+// checkAddress performs the selected hostname checks against a single
+// address of hostname, which should be in host:port form. A check is only
+// run once the checks it requires have succeeded, or only warned.
+func (c *Checker) checkAddress(ctx context.Context, domain, hostname, address string, tlsa []TLSARecord, tlsaSecure bool) AddressResult {
+	host, _, _ := net.SplitHostPort(address)
+	result := AddressResult{
+		Result:  MakeResult("addresses"),
+		Address: host,
+		Network: addressNetwork(net.ParseIP(host)),
+	}
+	a := &addressCheck{
+		c:          c,
+		ctx:        ctx,
+		domain:     domain,
+		hostname:   hostname,
+		address:    address,
+		tlsa:       tlsa,
+		tlsaSecure: tlsaSecure,
+		result:     &result,
+	}
+	selected := c.selectedChecks()
+	for _, check := range checks {
+		if !selected[check.Name] || !a.requirementsMet(check) {
+			continue
+		}
+		if checkResult := check.run(a); checkResult != nil {
+			result.addCheck(checkResult)
+		}
+	}
+	if a.client != nil {
+		a.client.Close()
+	}
+	return result
+}
+
+// requirementsMet returns true if the checks that check requires have
+// succeeded, or only warned.
+func (a *addressCheck) requirementsMet(check CheckInfo) bool {
+	for _, required := range check.Requires {
+		checkResult, ok := a.result.Checks[required]
+		if !ok || checkResult.Status > Warning {
+			return false
+		}
+	}
+	return true
+}
+
+// Connect to the SMTP server, and use that connection to perform as many
+// checks as possible.
+func checkConnectivity(a *addressCheck) *Result {
+	result := MakeResult(Connectivity)
+	client, recorder, err := a.c.smtpDialRecording(a.ctx, a.address)
+	if err != nil {
+		a.result.unreachable = networkUnreachable(err)
+		return result.Code("smtp.connection_failed").Error("Could not establish connection: %v", err)
+	}
+	a.client, a.recorder = client, recorder
+	a.result.Capabilities = recorder.capabilities(nil, nil)
+	return result.Success()
+}
+
+func checkAddressSTARTTLS(a *addressCheck) *Result {
+	result, tlsExtensions := checkStartTLS(a.client)
+	state, ok := a.client.TLSConnectionState()
+	if ok && state.HandshakeComplete {
+		a.result.Capabilities = a.recorder.capabilities(tlsExtensions, &state)
+	} else {
+		a.result.Capabilities = a.recorder.capabilities(nil, nil)
+	}
+	if ok && result.Status <= Warning {
+		a.result.Certificates = summarizeChain(state.PeerCertificates)
+		a.result.CertificateStatus = summarizeCertificateStatus(state)
+		a.result.PKIX = a.c.validatePKIX(state, a.hostname)
+	}
+	return result
+}
+
+func checkAddressDANE(a *addressCheck) *Result {
+	return checkDANE(a.client, a.hostname, a.tlsa, a.tlsaSecure)
+}
+
+func checkAddressCertificate(a *addressCheck) *Result {
+	return a.c.checkCert(a.client, a.domain, a.hostname, a.result.subcheckSucceeded(DANE))
+}
+
+// Creates a new connection to check for SSLv2/3 support because we can't
+// call starttls twice.
+func checkAddressVersion(a *addressCheck) *Result {
+	return a.c.checkTLSVersion(a.ctx, a.client, a.address)
+}
+
+func checkAddressSTARTTLSInjection(a *addressCheck) *Result {
+	return a.c.checkSTARTTLSInjection(a.ctx, a.address)
+}
+
+func checkAddressTLSSupport(a *addressCheck) *Result {
+	var result *Result
+	result, a.result.TLSSupport = a.c.checkTLSSupport(a.ctx, a.address, a.hostname)
+	return result
+}
+
+// aggregateAddresses derives the checks of a hostname from the checks on
+// each of its addresses. Each check takes the most severe status across the
+// addresses it ran on. When the addresses disagree, messages name the
+// address they came from.
+//
+// Addresses that this machine can't route to are ignored, unless all of
+// them are unroutable.
+func (h *HostnameResult) aggregateAddresses() {
+	addresses := []AddressResult{}
+	for _, address := range h.Addresses {
+		if !address.unreachable {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		addresses = h.Addresses
+	}
+
+	connected := []AddressResult{}
+	for _, address := range addresses {
+		if address.subcheckSucceeded(Connectivity) {
+			connected = append(connected, address)
+		}
+	}
+	if len(connected) == 0 {
+		// Report why we couldn't connect to any of the addresses.
+		h.addCheck(mergeChecks(Connectivity, addresses))
+		return
+	}
+	connectivityResult := MakeResult(Connectivity)
+	for _, address := range addresses {
+		if !address.subcheckSucceeded(Connectivity) {
+			connectivityResult.Code("smtp.address_unreachable").Warning("Could not connect to %s, one of the addresses of this hostname.", address.Address)
+		}
+	}
+	h.addCheck(connectivityResult)
+	for _, address := range connected {
+		if h.Certificates == nil && len(address.Certificates) > 0 {
+			h.Certificates = address.Certificates
+			h.CertificateStatus = address.CertificateStatus
+		}
+		// Senders may connect to any of the addresses, so report an invalid
+		// certificate if any of them presented one.
+		if address.PKIX != nil && (h.PKIX == nil || (h.PKIX.Valid() && !address.PKIX.Valid())) {
+			h.PKIX = address.PKIX
+		}
+		if h.TLSSupport == nil {
+			h.TLSSupport = address.TLSSupport
+		}
+		if h.Capabilities == nil {
+			h.Capabilities = address.Capabilities
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, address := range connected {
+		for name := range address.Checks {
+			if name != Connectivity {
+				names[name] = true
+			}
+		}
+	}
+	for name := range names {
+		h.addCheck(mergeChecks(name, connected))
+	}
+}
+
+// mergeChecks combines the results of the check called name across
+// addresses. Messages reported by every address are kept as-is, while the
+// others are suffixed with the address that reported them.
+func mergeChecks(name string, addresses []AddressResult) *Result {
+	result := MakeResult(name)
+	ran := []AddressResult{}
+	counts := make(map[string]int)
+	for _, address := range addresses {
+		check, ok := address.Checks[name]
+		if !ok {
+			continue
+		}
+		ran = append(ran, address)
+		result.Status = SetStatus(result.Status, check.Status)
+		for _, message := range check.Messages {
+			counts[message]++
+		}
+	}
+	added := make(map[string]bool)
+	for _, address := range ran {
+		check := address.Checks[name]
+		for i, message := range check.Messages {
+			detail := check.detail(i)
+			if counts[message] == len(ran) {
+				if !added[message] {
+					added[message] = true
+					result.Messages = append(result.Messages, message)
+					result.Details = append(result.Details, detail)
+				}
+				continue
+			}
+			params := map[string]interface{}{"address": address.Address}
+			for key, value := range detail.Params {
+				params[key] = value
+			}
+			detail.Params = params
+			result.Messages = append(result.Messages, fmt.Sprintf("%s (%s)", message, address.Address))
+			result.Details = append(result.Details, detail)
+		}
+	}
+	return result
+}
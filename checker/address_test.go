@@ -0,0 +1,154 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+// ipResolver resolves every hostname to the same list of addresses.
+type ipResolver struct {
+	mockResolver
+	ips []net.IP
+}
+
+func (r ipResolver) LookupIP(_ context.Context, _ string) ([]net.IP, bool, error) {
+	return r.ips, false, nil
+}
+
+func addressResult(address string, checks ...*Result) AddressResult {
+	r := AddressResult{Result: MakeResult("addresses"), Address: address}
+	for _, check := range checks {
+		r.addCheck(check)
+	}
+	return r
+}
+
+func TestAggregateAddresses(t *testing.T) {
+	good := func(address string) AddressResult {
+		return addressResult(address,
+			MakeResult(Connectivity), MakeResult(STARTTLS), MakeResult(Certificate))
+	}
+	tests := []struct {
+		desc      string
+		addresses []AddressResult
+		expected  Result
+	}{
+		{
+			"all addresses pass",
+			[]AddressResult{good("192.0.2.1"), good("2001:db8::1")},
+			Result{Status: Success, Checks: map[string]*Result{
+				Connectivity: {Connectivity, Success, nil, nil, nil},
+				STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+				Certificate:  {Certificate, Success, nil, nil, nil},
+			}},
+		},
+		{
+			"one address doesn't support STARTTLS",
+			[]AddressResult{good("192.0.2.1"), addressResult("192.0.2.2",
+				MakeResult(Connectivity), MakeResult(STARTTLS).Failure("Server does not advertise support for STARTTLS."))},
+			Result{Status: Failure, Checks: map[string]*Result{
+				Connectivity: {Connectivity, Success, nil, nil, nil},
+				STARTTLS:     {STARTTLS, Failure, []string{"Failure: Server does not advertise support for STARTTLS. (192.0.2.2)"}, nil, nil},
+				Certificate:  {Certificate, Success, nil, nil, nil},
+			}},
+		},
+		{
+			"one address unreachable",
+			[]AddressResult{good("192.0.2.1"), addressResult("192.0.2.2",
+				MakeResult(Connectivity).Error("Could not establish connection"))},
+			Result{Status: Warning, Checks: map[string]*Result{
+				Connectivity: {Connectivity, Warning, nil, nil, nil},
+				STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+				Certificate:  {Certificate, Success, nil, nil, nil},
+			}},
+		},
+		{
+			"no addresses reachable",
+			[]AddressResult{
+				addressResult("192.0.2.1", MakeResult(Connectivity).Error("Could not establish connection")),
+				addressResult("192.0.2.2", MakeResult(Connectivity).Error("Could not establish connection")),
+			},
+			Result{Status: Error, Checks: map[string]*Result{
+				Connectivity: {Connectivity, Error, []string{"Error: Could not establish connection"}, nil, nil},
+			}},
+		},
+	}
+	for _, test := range tests {
+		result := HostnameResult{Result: MakeResult("hostnames"), Addresses: test.addresses}
+		result.aggregateAddresses()
+		if result.Status != test.expected.Status {
+			t.Errorf("%s: hostname status = %d, want %d", test.desc, result.Status, test.expected.Status)
+		}
+		if len(result.Checks) != len(test.expected.Checks) {
+			t.Errorf("%s: got checks %v, want %v", test.desc, result.Checks, test.expected.Checks)
+		}
+		for name, check := range test.expected.Checks {
+			got, ok := result.Checks[name]
+			if !ok || got.Status != check.Status {
+				t.Errorf("%s: %s = %v, want status %d", test.desc, name, got, check.Status)
+				continue
+			}
+			if check.Messages != nil && strings.Join(got.Messages, "\n") != strings.Join(check.Messages, "\n") {
+				t.Errorf("%s: %s messages = %q, want %q", test.desc, name, got.Messages, check.Messages)
+			}
+		}
+	}
+}
+
+func TestUnreachableAddressIgnored(t *testing.T) {
+	unreachable := addressResult("2001:db8::1", MakeResult(Connectivity).Error("network is unreachable"))
+	unreachable.unreachable = true
+	result := HostnameResult{
+		Result: MakeResult("hostnames"),
+		Addresses: []AddressResult{
+			addressResult("192.0.2.1", MakeResult(Connectivity), MakeResult(STARTTLS)),
+			unreachable,
+		},
+	}
+	result.aggregateAddresses()
+	if result.Status != Success {
+		t.Errorf("expected unroutable addresses to be ignored, got %v", result.Checks[Connectivity])
+	}
+}
+
+func TestCheckEachAddress(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// Nothing listens on 127.0.0.2, so one of the two addresses should fail.
+	c := Checker{
+		Timeout:  testTimeout,
+		Resolver: ipResolver{ips: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}},
+	}
+	result := c.fullCheckHostname(context.Background(), "", "localhost:"+port)
+	if len(result.Addresses) != 2 {
+		t.Fatalf("expected results for 2 addresses, got %d", len(result.Addresses))
+	}
+	if !result.Addresses[0].subcheckSucceeded(Connectivity) || result.Addresses[1].subcheckSucceeded(Connectivity) {
+		t.Errorf("expected only 127.0.0.1 to be reachable, got %v", result.Addresses)
+	}
+	connectivity := result.Checks[Connectivity]
+	if connectivity.Status != Warning || !strings.Contains(strings.Join(connectivity.Messages, ""), "127.0.0.2") {
+		t.Errorf("expected connectivity warning naming 127.0.0.2, got %v", connectivity)
+	}
+	if !result.couldConnect() || !result.couldSTARTTLS() {
+		t.Error("expected hostname to be connectable and support STARTTLS")
+	}
+
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(marshalled), `"address":"127.0.0.2"`) {
+		t.Errorf("expected marshalled result to contain address results, got %s", marshalled)
+	}
+}
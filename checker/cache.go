@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -12,8 +13,24 @@ type ScanStore interface {
 	PutHostnameScan(string, HostnameResult) error
 }
 
+// MTASTSSnapshotStore is implemented by ScanStores that can also remember the
+// MTA-STS record id and policy seen for a domain. The MTA-STS consistency
+// check only runs with a ScanStore that implements it.
+type MTASTSSnapshotStore interface {
+	// Retrieves the MTA-STS record id and policy last seen for a domain.
+	// Returns ErrNoMTASTSSnapshot if none has been stored for it.
+	GetMTASTSSnapshot(string) (MTASTSSnapshot, error)
+	// Remembers the MTA-STS record id and policy seen for a domain.
+	PutMTASTSSnapshot(string, MTASTSSnapshot) error
+}
+
+// ErrNoMTASTSSnapshot is returned by an MTASTSSnapshotStore that doesn't
+// have a snapshot for a domain yet.
+var ErrNoMTASTSSnapshot = errors.New("no MTA-STS snapshot stored for this domain")
+
 // ScanCache wraps a scan storage object. When calling GetScan, only returns a scan
-// if there was made in the last ExpireTime window
+// if there was made in the last ExpireTime window. MTA-STS snapshots don't
+// expire.
 type ScanCache struct {
 	ScanStore
 	ExpireTime time.Duration
@@ -39,8 +56,9 @@ func (c *ScanCache) PutHostnameScan(hostname string, result HostnameResult) erro
 
 // SimpleStore is simple HostnameResult storage backed by map.
 type SimpleStore struct {
-	m  map[string]HostnameResult
-	mu sync.RWMutex
+	m         map[string]HostnameResult
+	snapshots map[string]MTASTSSnapshot
+	mu        sync.RWMutex
 }
 
 // GetHostnameScan wraps a map get. Returns error if not present in map.
@@ -62,8 +80,31 @@ func (s *SimpleStore) PutHostnameScan(hostname string, result HostnameResult) er
 	return nil
 }
 
+// GetMTASTSSnapshot wraps a map get. Returns ErrNoMTASTSSnapshot if not
+// present in map.
+func (s *SimpleStore) GetMTASTSSnapshot(domain string) (MTASTSSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot, ok := s.snapshots[domain]
+	if !ok {
+		return snapshot, ErrNoMTASTSSnapshot
+	}
+	return snapshot, nil
+}
+
+// PutMTASTSSnapshot wraps a map set. Can never return error.
+func (s *SimpleStore) PutMTASTSSnapshot(domain string, snapshot MTASTSSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[domain] = snapshot
+	return nil
+}
+
 // MakeSimpleCache creates a cache with a SimpleStore backing it.
 func MakeSimpleCache(expiryTime time.Duration) *ScanCache {
-	store := SimpleStore{m: make(map[string]HostnameResult)}
+	store := SimpleStore{
+		m:         make(map[string]HostnameResult),
+		snapshots: make(map[string]MTASTSSnapshot),
+	}
 	return &ScanCache{ScanStore: &store, ExpireTime: expiryTime}
 }
@@ -0,0 +1,251 @@
+package checker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// SMTPCapabilities describes what a mailserver told us about itself while
+// we checked it.
+type SMTPCapabilities struct {
+	// Banner is the text of the server's 220 greeting.
+	Banner string `json:"banner"`
+	// Extensions lists the EHLO keywords and their parameters, like
+	// "SIZE 10240000", that the server advertised before STARTTLS.
+	Extensions []string `json:"extensions"`
+	// TLSExtensions lists the extensions advertised after STARTTLS.
+	TLSExtensions []string `json:"tls_extensions,omitempty"`
+	// The fields below describe the extensions senders can use: those
+	// advertised after STARTTLS, if it succeeded. Size is the maximum
+	// message size in bytes, or 0 if the server didn't declare one.
+	Size       int64 `json:"size,omitempty"`
+	Pipelining bool  `json:"pipelining"`
+	RequireTLS bool  `json:"requiretls"`
+	SMTPUTF8   bool  `json:"smtputf8"`
+	// TLS describes the connection negotiated by STARTTLS.
+	TLS *NegotiatedTLS `json:"tls,omitempty"`
+}
+
+// NegotiatedTLS describes the parameters of a TLS connection.
+type NegotiatedTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ALPN        string `json:"alpn,omitempty"`
+	// Curve is the group used for the key exchange, if it was ECDHE.
+	Curve string `json:"curve,omitempty"`
+}
+
+// Named groups for ECDHE key exchanges.
+var curveNames = map[uint16]string{
+	23:     "P-256",
+	24:     "P-384",
+	25:     "P-521",
+	29:     "X25519",
+	30:     "X448",
+	0x6399: "X25519Kyber768Draft00",
+	0x11EC: "X25519MLKEM768",
+}
+
+func curveName(id uint16) string {
+	if name, ok := curveNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// maxRecording is how much of a connection a recordingConn keeps: enough
+// for the greeting, the EHLO response, and the unencrypted part of the TLS
+// handshake, including certificates.
+const maxRecording = 64 * 1024
+
+// recordingConn records the start of the data read from a connection, so
+// that we can recover details that crypto/tls doesn't expose.
+type recordingConn struct {
+	net.Conn
+	data []byte
+	// Offset in data of the first TLS record, or -1 before STARTTLS.
+	tlsStart int
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if room := maxRecording - len(c.data); n > 0 && room > 0 {
+		if n < room {
+			room = n
+		}
+		c.data = append(c.data, b[:room]...)
+	}
+	return n, err
+}
+
+// startTLS marks the end of the plaintext part of the connection, once the
+// server has accepted STARTTLS. Everything it sends after that is TLS.
+func (c *recordingConn) startTLS() {
+	if c.tlsStart < 0 {
+		c.tlsStart = len(c.data)
+	}
+}
+
+// smtpDialRecording is like smtpDialContext, but also returns a recording
+// of what the server sent.
+func (c *Checker) smtpDialRecording(ctx context.Context, hostname string) (*smtpClient, *recordingConn, error) {
+	if _, _, err := net.SplitHostPort(hostname); err != nil {
+		hostname += ":25"
+	}
+	network := c.network()
+	conn, err := network.dial(ctx, hostname, c.timeout())
+	if err != nil {
+		return nil, nil, err
+	}
+	recorder := &recordingConn{Conn: conn, tlsStart: -1}
+	client, err := newSMTPClient(newContextConn(ctx, recorder), network)
+	if err != nil {
+		return client, recorder, err
+	}
+	client.startingTLS = recorder.startTLS
+	return client, recorder, client.Hello(getThisHostname())
+}
+
+// plaintext returns the SMTP replies recorded before STARTTLS.
+func (c *recordingConn) plaintext() []string {
+	data := c.data
+	if c.tlsStart >= 0 {
+		data = data[:c.tlsStart]
+	}
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	replies := []string{}
+	for {
+		_, msg, err := r.ReadResponse(0)
+		if err != nil {
+			return replies
+		}
+		replies = append(replies, msg)
+	}
+}
+
+// curve returns the name of the group the server chose for the key
+// exchange, from its ServerHello (TLS 1.3) or ServerKeyExchange (TLS 1.2
+// and earlier). It's empty if the key exchange didn't use ECDHE.
+func (c *recordingConn) curve() string {
+	// Replayed connections only carry the outcome of the handshake.
+	if replay, ok := innermostConn(c.Conn).(*replayConn); ok {
+		return replay.curve()
+	}
+	if c.tlsStart < 0 {
+		return ""
+	}
+	// Reassemble the unencrypted handshake messages.
+	var handshake []byte
+	for data := c.data[c.tlsStart:]; len(data) >= 5 && data[0] == 22; {
+		length := int(data[3])<<8 | int(data[4])
+		if len(data) < 5+length {
+			break
+		}
+		handshake = append(handshake, data[5:5+length]...)
+		data = data[5+length:]
+	}
+	for len(handshake) >= 4 {
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) < 4+length {
+			break
+		}
+		body := handshake[4 : 4+length]
+		switch handshake[0] {
+		case 2: // ServerHello
+			if group, ok := keyShareGroup(body); ok {
+				return curveName(group)
+			}
+		case 12: // ServerKeyExchange
+			// A named_curve (3) ECParameters, followed by the group.
+			if len(body) >= 3 && body[0] == 3 {
+				return curveName(uint16(body[1])<<8 | uint16(body[2]))
+			}
+			return ""
+		}
+		handshake = handshake[4+length:]
+	}
+	return ""
+}
+
+// keyShareGroup returns the group of the key_share extension in a
+// ServerHello, which is only sent with TLS 1.3.
+func keyShareGroup(hello []byte) (uint16, bool) {
+	// Version (2 bytes), random (32 bytes), session ID, cipher suite (2
+	// bytes) and compression method (1 byte), then the extensions.
+	if len(hello) < 35 {
+		return 0, false
+	}
+	i := 35 + int(hello[34]) + 3
+	if len(hello) < i+2 {
+		return 0, false
+	}
+	extensions := hello[i+2:]
+	for len(extensions) >= 4 {
+		extension := uint16(extensions[0])<<8 | uint16(extensions[1])
+		length := int(extensions[2])<<8 | int(extensions[3])
+		if len(extensions) < 4+length {
+			break
+		}
+		if extension == 51 && length >= 2 {
+			return uint16(extensions[4])<<8 | uint16(extensions[5]), true
+		}
+		extensions = extensions[4+length:]
+	}
+	return 0, false
+}
+
+// capabilities summarizes the server's greeting and extensions, and the TLS
+// connection negotiated with it, if any.
+func (c *recordingConn) capabilities(tlsExtensions []string, state *tls.ConnectionState) *SMTPCapabilities {
+	capabilities := &SMTPCapabilities{Extensions: []string{}, TLSExtensions: tlsExtensions}
+	replies := c.plaintext()
+	if len(replies) > 0 {
+		capabilities.Banner = replies[0]
+	}
+	if len(replies) > 1 {
+		capabilities.Extensions = parseEHLO(replies[1])
+	}
+	extensions := capabilities.Extensions
+	if state != nil {
+		extensions = tlsExtensions
+		capabilities.TLS = c.negotiatedTLS(*state)
+	}
+	capabilities.summarize(extensions)
+	return capabilities
+}
+
+// negotiatedTLS describes the TLS connection whose handshake c recorded.
+func (c *recordingConn) negotiatedTLS(state tls.ConnectionState) *NegotiatedTLS {
+	return &NegotiatedTLS{
+		Version:     versionNames[state.Version],
+		CipherSuite: cipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		Curve:       c.curve(),
+	}
+}
+
+// summarize sets the fields describing the extensions that senders can use.
+func (capabilities *SMTPCapabilities) summarize(extensions []string) {
+	for _, extension := range extensions {
+		fields := strings.Fields(extension)
+		switch fields[0] {
+		case "SIZE":
+			if len(fields) > 1 {
+				capabilities.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		case "PIPELINING":
+			capabilities.Pipelining = true
+		case "REQUIRETLS":
+			capabilities.RequireTLS = true
+		case "SMTPUTF8":
+			capabilities.SMTPUTF8 = true
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseEHLO(t *testing.T) {
+	got := parseEHLO("mx.example.com greets you\nsize 1000\nPIPELINING\nAUTH PLAIN LOGIN")
+	want := []string{"SIZE 1000", "PIPELINING", "AUTH PLAIN LOGIN"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEHLO = %v, want %v", got, want)
+	}
+	if !hasExtension(got, "AUTH") || hasExtension(got, "PLAIN") {
+		t.Errorf("hasExtension should only match keywords")
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	config := fakeSMTPConfig(t)
+	config.MaxVersion = tls.VersionTLS12
+	config.CurvePreferences = []tls.CurveID{tls.CurveP384}
+	server := fakeSMTPServer{config: config, tlsExtensions: []string{"PIPELINING", "REQUIRETLS", "SMTPUTF8"}}
+	ln := server.listen(t)
+	defer ln.Close()
+
+	c := Checker{Timeout: testTimeout}
+	result := c.checkAddress(context.Background(), "", "localhost", ln.Addr().String(), nil, false)
+	got := result.Capabilities
+	if got == nil {
+		t.Fatalf("expected capabilities to be recorded, got %v", result)
+	}
+	if got.Banner != "localhost ESMTP" {
+		t.Errorf("expected banner %q, got %q", "localhost ESMTP", got.Banner)
+	}
+	if !reflect.DeepEqual(got.Extensions, []string{"STARTTLS"}) {
+		t.Errorf("expected STARTTLS to be advertised before TLS, got %v", got.Extensions)
+	}
+	if !reflect.DeepEqual(got.TLSExtensions, []string{"PIPELINING", "REQUIRETLS", "SMTPUTF8", "SIZE 1000"}) {
+		t.Errorf("unexpected extensions after TLS: %v", got.TLSExtensions)
+	}
+	if got.Size != 1000 || !got.Pipelining || !got.RequireTLS || !got.SMTPUTF8 {
+		t.Errorf("expected extensions advertised after TLS to be summarized, got %+v", got)
+	}
+	want := NegotiatedTLS{Version: "TLSv1.2", CipherSuite: cipherSuiteName(0xC02F), Curve: "P-384"}
+	if got.TLS == nil || *got.TLS != want {
+		t.Errorf("expected TLS %+v, got %+v", want, got.TLS)
+	}
+}
+
+func TestCapabilitiesTLS13(t *testing.T) {
+	if !tls13Supported(t) {
+		t.Skip("crypto/tls doesn't implement TLS 1.3, so the fake server can't negotiate it")
+	}
+	config := fakeSMTPConfig(t)
+	config.CurvePreferences = []tls.CurveID{tls.X25519}
+	ln := smtpListenAndServe(t, config)
+	defer ln.Close()
+
+	c := Checker{Timeout: testTimeout}
+	result := c.checkAddress(context.Background(), "", "localhost", ln.Addr().String(), nil, false)
+	got := result.Capabilities
+	if got == nil || got.TLS == nil {
+		t.Fatalf("expected TLS details to be recorded, got %v", result)
+	}
+	if got.TLS.Version != "TLSv1.3" || got.TLS.Curve != "X25519" {
+		t.Errorf("expected TLSv1.3 with X25519, got %+v", got.TLS)
+	}
+	if !hasExtension(got.TLSExtensions, "SIZE") {
+		t.Errorf("expected SIZE to be advertised after TLS, got %v", got.TLSExtensions)
+	}
+}
+
+func TestCapabilitiesWithoutTLS(t *testing.T) {
+	ln := smtpListenAndServe(t, &tls.Config{})
+	defer ln.Close()
+
+	c := Checker{Timeout: testTimeout}
+	result := c.checkAddress(context.Background(), "", "localhost", ln.Addr().String(), nil, false)
+	got := result.Capabilities
+	if got == nil || got.TLS != nil || len(got.Extensions) == 0 || got.Banner == "" {
+		t.Errorf("expected plaintext capabilities only, got %+v", got)
+	}
+}
+
+func TestRecordingConnStartTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// A reply that happens to start with 22, then a TLS record.
+		server.Write([]byte("220 greetings\r\n"))
+		server.Write([]byte("\x16 not TLS yet\r\n"))
+		server.Write([]byte{22, 3, 3, 0, 0})
+		server.Close()
+	}()
+	recorder := &recordingConn{Conn: client, tlsStart: -1}
+	b := make([]byte, 64)
+	for _, length := range []int{15, 15} {
+		if n, err := io.ReadFull(recorder, b[:length]); err != nil {
+			t.Fatalf("read %d bytes: %v", n, err)
+		}
+	}
+	if recorder.tlsStart != -1 {
+		t.Errorf("expected TLS to start only once marked, got offset %d", recorder.tlsStart)
+	}
+	recorder.startTLS()
+	io.ReadFull(recorder, b[:5])
+	if recorder.tlsStart != 30 {
+		t.Errorf("expected TLS to start at offset 30, got %d", recorder.tlsStart)
+	}
+	if replies := recorder.plaintext(); len(replies) != 1 || replies[0] != "greetings" {
+		t.Errorf("expected only the plaintext replies, got %q", replies)
+	}
+}
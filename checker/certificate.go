@@ -0,0 +1,136 @@
+package checker
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// CertificateSummary describes one of the certificates presented by a
+// mailserver.
+type CertificateSummary struct {
+	Subject      string    `json:"subject"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	// KeyType is the public key algorithm, such as "RSA" or "ECDSA".
+	KeyType string `json:"key_type"`
+	// KeySize is the size of the public key (or of its curve) in bits.
+	KeySize            int    `json:"key_size"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	// SPKIFingerprint is the hex-encoded SHA-256 digest of the certificate's
+	// SubjectPublicKeyInfo, as used in DANE "3 1 1" records.
+	SPKIFingerprint string `json:"spki_sha256"`
+}
+
+// PKIXValidation is the outcome of validating a certificate the way senders
+// enforcing MTA-STS do: it must be valid for the hostname, and chain to a
+// trusted root. Unlike the certificate check, it ignores DANE, and the
+// strength of the certificate's key and signature.
+type PKIXValidation struct {
+	// Why the certificate isn't valid for the hostname, if it isn't.
+	HostnameError string `json:"hostname_error,omitempty"`
+	// Why the chain isn't trusted, or has expired, if it isn't valid.
+	ChainError string `json:"chain_error,omitempty"`
+}
+
+// Valid returns true if the certificate passed both checks. A nil
+// PKIXValidation, for a certificate we didn't see, isn't valid.
+func (p *PKIXValidation) Valid() bool {
+	return p != nil && p.HostnameError == "" && p.ChainError == ""
+}
+
+func publicKeySize(cert *x509.Certificate) int {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		return key.P.BitLen()
+	}
+	return 0
+}
+
+func summarizeCertificate(cert *x509.Certificate) CertificateSummary {
+	fingerprint := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return CertificateSummary{
+		Subject:            cert.Subject.String(),
+		DNSNames:           cert.DNSNames,
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		KeyType:            cert.PublicKeyAlgorithm.String(),
+		KeySize:            publicKeySize(cert),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SPKIFingerprint:    hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// summarizeChain summarizes each of the certificates presented by a
+// mailserver, starting with the leaf.
+func summarizeChain(chain []*x509.Certificate) []CertificateSummary {
+	summaries := []CertificateSummary{}
+	for _, cert := range chain {
+		summaries = append(summaries, summarizeCertificate(cert))
+	}
+	return summaries
+}
+
+// Signature algorithms that are considered broken.
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// Minimum size in bits of RSA and DSA keys.
+const minKeySize = 2048
+
+// validateCertStrength fails certificates in chain with weak keys or
+// signatures. The signatures of self-signed certificates other than the leaf
+// are ignored, since those are trust anchors.
+func validateCertStrength(chain []*x509.Certificate, result *Result) *Result {
+	for i, cert := range chain {
+		name := fmt.Sprintf("certificate for %q", cert.Subject.CommonName)
+		if i == 0 {
+			name = "leaf certificate"
+		} else if cert.Subject.CommonName == "" {
+			name = fmt.Sprintf("certificate %d of the chain", i+1)
+		}
+		switch cert.PublicKeyAlgorithm {
+		case x509.RSA, x509.DSA:
+			if size := publicKeySize(cert); size < minKeySize {
+				result.Code("cert.weak_key").Failure("The %s has a %d-bit %v key; keys should be at least %d bits.",
+					name, size, cert.PublicKeyAlgorithm, minKeySize)
+			}
+		}
+		selfSigned := bytes.Equal(cert.RawIssuer, cert.RawSubject)
+		if weakSignatureAlgorithms[cert.SignatureAlgorithm] && (i == 0 || !selfSigned) {
+			result.Code("cert.weak_signature").Failure("The %s is signed with %v, which is no longer secure.",
+				name, cert.SignatureAlgorithm)
+		}
+	}
+	return result
+}
+
+// validateCertExpiry warns if cert expires within window of now.
+func validateCertExpiry(cert *x509.Certificate, window time.Duration, now time.Time, result *Result) *Result {
+	remaining := cert.NotAfter.Sub(now)
+	if remaining > 0 && remaining < window {
+		result.Code("cert.expiring_soon").Warning("Certificate expires in %d days, on %s. Renew it soon to avoid delivery failures.",
+			int(remaining.Hours()/24), cert.NotAfter.UTC().Format("2006-01-02"))
+	}
+	return result
+}
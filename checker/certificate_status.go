@@ -0,0 +1,153 @@
+package checker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// CertificateStatus describes the Certificate Transparency and revocation
+// information that a mailserver presented along with its certificate.
+type CertificateStatus struct {
+	// Number of SCTs embedded in the leaf certificate.
+	EmbeddedSCTs int `json:"embedded_scts"`
+	// Number of SCTs sent in the TLS handshake.
+	TLSSCTs int `json:"tls_scts"`
+	// Number of distinct logs that issued the SCTs.
+	SCTLogs int `json:"sct_logs"`
+	// OCSPStapled is true if the server stapled an OCSP response.
+	OCSPStapled bool `json:"ocsp_stapled"`
+	// OCSPStatus is "good", "revoked" or "unknown", as stated by the stapled
+	// response, or "invalid" if it couldn't be parsed.
+	OCSPStatus string `json:"ocsp_status,omitempty"`
+	// The validity period of the stapled response. OCSPNextUpdate is nil if
+	// the response doesn't say when newer information will be available.
+	OCSPThisUpdate *time.Time `json:"ocsp_this_update,omitempty"`
+	OCSPNextUpdate *time.Time `json:"ocsp_next_update,omitempty"`
+	// ocspError is why the stapled response couldn't be parsed.
+	ocspError error
+}
+
+// The certificate extension containing embedded SCTs.
+// https://tools.ietf.org/html/rfc6962#section-3.3
+var sctListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// sctLogID returns the log ID of a serialized SCT: a 1-byte version
+// followed by the 32-byte ID of the log.
+// https://tools.ietf.org/html/rfc6962#section-3.2
+func sctLogID(sct []byte) (string, bool) {
+	if len(sct) < 33 {
+		return "", false
+	}
+	return hex.EncodeToString(sct[1:33]), true
+}
+
+// embeddedSCTs returns the SCTs in cert's SCT list extension.
+func embeddedSCTs(cert *x509.Certificate) [][]byte {
+	scts := [][]byte{}
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(sctListOID) {
+			continue
+		}
+		// The extension is an OCTET STRING holding a TLS-encoded list of
+		// length-prefixed SCTs.
+		var list []byte
+		if _, err := asn1.Unmarshal(extension.Value, &list); err != nil || len(list) < 2 {
+			return scts
+		}
+		list = list[2:]
+		for len(list) >= 2 {
+			length := int(list[0])<<8 | int(list[1])
+			if len(list) < 2+length {
+				break
+			}
+			scts = append(scts, list[2:2+length])
+			list = list[2+length:]
+		}
+	}
+	return scts
+}
+
+// summarizeCertificateStatus describes the SCTs and stapled OCSP response
+// presented over a TLS connection.
+func summarizeCertificateStatus(state tls.ConnectionState) *CertificateStatus {
+	status := &CertificateStatus{}
+	if len(state.PeerCertificates) == 0 {
+		return status
+	}
+	leaf := state.PeerCertificates[0]
+	logs := make(map[string]bool)
+	embedded := embeddedSCTs(leaf)
+	for _, sct := range append(embedded, state.SignedCertificateTimestamps...) {
+		if id, ok := sctLogID(sct); ok {
+			logs[id] = true
+		}
+	}
+	status.EmbeddedSCTs = len(embedded)
+	status.TLSSCTs = len(state.SignedCertificateTimestamps)
+	status.SCTLogs = len(logs)
+
+	if len(state.OCSPResponse) == 0 {
+		return status
+	}
+	status.OCSPStapled = true
+	// The response's signature can only be verified if the server sent the
+	// leaf's issuer.
+	var issuer *x509.Certificate
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	}
+	resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err != nil {
+		status.OCSPStatus = "invalid"
+		status.ocspError = err
+		return status
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		status.OCSPStatus = "good"
+	case ocsp.Revoked:
+		status.OCSPStatus = "revoked"
+	default:
+		status.OCSPStatus = "unknown"
+	}
+	status.OCSPThisUpdate = &resp.ThisUpdate
+	if !resp.NextUpdate.IsZero() {
+		status.OCSPNextUpdate = &resp.NextUpdate
+	}
+	return status
+}
+
+// validateCertificateStatus warns about stapled OCSP responses that aren't
+// fresh or don't say the certificate is good, and about missing SCTs if
+// requireSCTs is set. Private CAs don't log to Certificate Transparency, so
+// SCTs should only be required of publicly trusted certificates. Stapling is
+// optional, so servers that don't staple aren't warned about.
+func validateCertificateStatus(status *CertificateStatus, now time.Time, requireSCTs bool, result *Result) *Result {
+	if requireSCTs && status.SCTLogs == 0 {
+		result.Code("cert.no_scts").Warning("The leaf certificate has no Signed Certificate Timestamps (SCTs), embedded or sent during the handshake, so it may not have been logged to Certificate Transparency.")
+	}
+	if !status.OCSPStapled {
+		return result
+	}
+	switch status.OCSPStatus {
+	case "invalid":
+		return result.Code("cert.ocsp_invalid").Warning("Server stapled an OCSP response that couldn't be verified: %v.", status.ocspError)
+	case "revoked":
+		return result.Code("cert.ocsp_revoked").Warning("Server stapled an OCSP response saying that its certificate has been revoked.")
+	case "unknown":
+		return result.Code("cert.ocsp_unknown").Warning("Server stapled an OCSP response saying that the issuer doesn't know its certificate.")
+	}
+	if status.OCSPNextUpdate != nil && now.After(*status.OCSPNextUpdate) {
+		result.Code("cert.ocsp_stale").Warning("Server stapled a stale OCSP response, which expired on %s.",
+			status.OCSPNextUpdate.UTC().Format("2006-01-02"))
+	} else if status.OCSPThisUpdate != nil && now.Before(*status.OCSPThisUpdate) {
+		result.Code("cert.ocsp_not_yet_valid").Warning("Server stapled an OCSP response that isn't valid until %s.",
+			status.OCSPThisUpdate.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	return result
+}
@@ -0,0 +1,154 @@
+package checker
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// fakeSCT returns a serialized SCT issued by the log whose ID is all logID.
+func fakeSCT(logID byte) []byte {
+	sct := []byte{0}
+	for i := 0; i < 32; i++ {
+		sct = append(sct, logID)
+	}
+	// Timestamp, empty extensions, and a dummy signature.
+	return append(sct, make([]byte, 8+2+4)...)
+}
+
+// sctListExtension embeds scts in a certificate extension.
+func sctListExtension(t *testing.T, scts ...[]byte) pkix.Extension {
+	var list []byte
+	for _, sct := range scts {
+		list = append(list, byte(len(sct)>>8), byte(len(sct)))
+		list = append(list, sct...)
+	}
+	value, err := asn1.Marshal(append([]byte{byte(len(list) >> 8), byte(len(list))}, list...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: sctListOID, Value: value}
+}
+
+// testChain returns a leaf certificate with the given extensions, and the
+// test CA that issued it.
+func testChain(t *testing.T, extensions ...pkix.Extension) (*x509.Certificate, *x509.Certificate) {
+	block, _ := pem.Decode([]byte(key))
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := testConnectionState(t, certString).PeerCertificates[0]
+	template := x509.Certificate{
+		SerialNumber:    big.NewInt(42),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		DNSNames:        []string{"localhost"},
+		ExtraExtensions: extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, issuer, &privKey.PublicKey, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf, issuer
+}
+
+// testOCSPResponse returns an OCSP response for leaf signed by issuer.
+func testOCSPResponse(t *testing.T, leaf, issuer *x509.Certificate, status int, thisUpdate, nextUpdate time.Time) []byte {
+	block, _ := pem.Decode([]byte(key))
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+		Status:       status,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   nextUpdate,
+		RevokedAt:    thisUpdate,
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSummarizeSCTs(t *testing.T) {
+	leaf, issuer := testChain(t, sctListExtension(t, fakeSCT(1), fakeSCT(2)))
+	state := tls.ConnectionState{
+		PeerCertificates:            []*x509.Certificate{leaf, issuer},
+		SignedCertificateTimestamps: [][]byte{fakeSCT(2), fakeSCT(3)},
+	}
+	status := summarizeCertificateStatus(state)
+	if status.EmbeddedSCTs != 2 || status.TLSSCTs != 2 || status.SCTLogs != 3 {
+		t.Errorf("expected 2 embedded and 2 TLS SCTs from 3 logs, got %+v", status)
+	}
+	if status.OCSPStapled {
+		t.Errorf("expected no OCSP staple, got %+v", status)
+	}
+	if result := validateCertificateStatus(status, time.Now(), true, MakeResult(Certificate)); result.Status != Success {
+		t.Errorf("expected SCTs without a staple to succeed, got %v", result)
+	}
+
+	leaf, issuer = testChain(t)
+	status = summarizeCertificateStatus(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, issuer}})
+	result := validateCertificateStatus(status, time.Now(), true, MakeResult(Certificate))
+	if status.SCTLogs != 0 || result.Status != Warning {
+		t.Errorf("expected a warning about missing SCTs, got %+v and %v", status, result)
+	}
+	// Certificates from private CAs aren't logged.
+	if result := validateCertificateStatus(status, time.Now(), false, MakeResult(Certificate)); result.Status != Success {
+		t.Errorf("expected missing SCTs to succeed unless they're required, got %v", result)
+	}
+	if b, err := json.Marshal(status); err != nil || strings.Contains(string(b), "ocsp_this_update") {
+		t.Errorf("expected the OCSP validity period to be omitted without a staple, got %s, %v", b, err)
+	}
+}
+
+func TestOCSPStapling(t *testing.T) {
+	leaf, issuer := testChain(t, sctListExtension(t, fakeSCT(1)))
+	now := time.Now()
+	hour := time.Hour
+	tests := []struct {
+		resp    []byte
+		status  string
+		want    Status
+		message string
+	}{
+		{testOCSPResponse(t, leaf, issuer, ocsp.Good, now.Add(-hour), now.Add(hour)), "good", Success, ""},
+		{testOCSPResponse(t, leaf, issuer, ocsp.Good, now.Add(-hour), time.Time{}), "good", Success, ""},
+		{testOCSPResponse(t, leaf, issuer, ocsp.Good, now.Add(-2*hour), now.Add(-hour)), "good", Warning, "stale"},
+		{testOCSPResponse(t, leaf, issuer, ocsp.Good, now.Add(hour), now.Add(2*hour)), "good", Warning, "isn't valid until"},
+		{testOCSPResponse(t, leaf, issuer, ocsp.Revoked, now.Add(-hour), now.Add(hour)), "revoked", Warning, "revoked"},
+		{testOCSPResponse(t, leaf, issuer, ocsp.Unknown, now.Add(-hour), now.Add(hour)), "unknown", Warning, "doesn't know"},
+		{[]byte("garbage"), "invalid", Warning, "couldn't be verified"},
+	}
+	for _, test := range tests {
+		state := tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf, issuer},
+			OCSPResponse:     test.resp,
+		}
+		status := summarizeCertificateStatus(state)
+		if !status.OCSPStapled || status.OCSPStatus != test.status {
+			t.Errorf("expected stapled OCSP status %q, got %+v", test.status, status)
+		}
+		result := validateCertificateStatus(status, now, true, MakeResult(Certificate))
+		if result.Status != test.want || !strings.Contains(strings.Join(result.Messages, " "), test.message) {
+			t.Errorf("expected status %d with message %q, got %v", test.want, test.message, result)
+		}
+	}
+}
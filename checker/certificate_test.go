@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSummarizeCertificate(t *testing.T) {
+	cert := testConnectionState(t, certString).PeerCertificates[0]
+	summary := summarizeCertificate(cert)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	if summary.KeyType != "RSA" || summary.KeySize != 2048 {
+		t.Errorf("expected 2048-bit RSA key, got %d-bit %s", summary.KeySize, summary.KeyType)
+	}
+	if len(summary.DNSNames) != 1 || summary.DNSNames[0] != "localhost" {
+		t.Errorf("expected SAN localhost, got %v", summary.DNSNames)
+	}
+	if summary.SPKIFingerprint != hex.EncodeToString(spki[:]) {
+		t.Errorf("unexpected SPKI fingerprint %s", summary.SPKIFingerprint)
+	}
+	if summary.SignatureAlgorithm != "SHA256-RSA" || !summary.NotAfter.Equal(cert.NotAfter) {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func signedCert(t *testing.T, bits int, algorithm x509.SignatureAlgorithm) *x509.Certificate {
+	privKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "mx.example.com"},
+		Issuer:             pkix.Name{CommonName: "Example CA"},
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: algorithm,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privKey.PublicKey, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestValidateCertStrength(t *testing.T) {
+	strong := testConnectionState(t, certString).PeerCertificates[0]
+	tests := []struct {
+		desc   string
+		chain  []*x509.Certificate
+		status Status
+	}{
+		{"2048-bit RSA and SHA-256", []*x509.Certificate{strong}, Success},
+		{"1024-bit RSA", []*x509.Certificate{signedCert(t, 1024, x509.SHA256WithRSA)}, Failure},
+		{"weak intermediate", []*x509.Certificate{strong, signedCert(t, 1024, x509.SHA256WithRSA)}, Failure},
+	}
+	for _, test := range tests {
+		result := validateCertStrength(test.chain, MakeResult(Certificate))
+		if result.Status != test.status {
+			t.Errorf("%s: got status %d, want %d: %v", test.desc, result.Status, test.status, result.Messages)
+		}
+	}
+}
+
+func TestValidateCertStrengthSHA1(t *testing.T) {
+	strong := testConnectionState(t, certString).PeerCertificates[0]
+	sha1 := *strong
+	sha1.SignatureAlgorithm = x509.SHA1WithRSA
+	if result := validateCertStrength([]*x509.Certificate{&sha1}, MakeResult(Certificate)); result.Status != Failure {
+		t.Errorf("expected SHA-1 signed leaf to fail, got %v", result)
+	}
+	// Self-signed roots are trusted by key, so their signature doesn't matter.
+	if result := validateCertStrength([]*x509.Certificate{strong, &sha1}, MakeResult(Certificate)); result.Status != Success {
+		t.Errorf("expected SHA-1 signed root to be ignored, got %v", result)
+	}
+}
+
+func TestValidateCertExpiry(t *testing.T) {
+	cert := &x509.Certificate{NotAfter: time.Date(2019, 6, 15, 0, 0, 0, 0, time.UTC)}
+	window := 14 * 24 * time.Hour
+	tests := []struct {
+		now    time.Time
+		status Status
+	}{
+		{time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC), Success},
+		{time.Date(2019, 6, 5, 0, 0, 0, 0, time.UTC), Warning},
+		// Expired certificates fail chain verification instead.
+		{time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC), Success},
+	}
+	for _, test := range tests {
+		result := validateCertExpiry(cert, window, test.now, MakeResult(Certificate))
+		if result.Status != test.status {
+			t.Errorf("validateCertExpiry at %v: got status %d, want %d: %v", test.now, result.Status, test.status, result.Messages)
+		}
+	}
+}
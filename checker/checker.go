@@ -1,7 +1,8 @@
 package checker
 
 import (
-	"net"
+	"crypto/x509"
+	"os"
 	"time"
 )
 
@@ -12,13 +13,71 @@ type Checker struct {
 	// If nil, a default timeout of 10 seconds is used.
 	Timeout time.Duration
 
+	// CertExpiryWindow specifies how long before a mailserver's certificate
+	// expires the certificate check starts warning about it.
+	// If 0, a default of 14 days is used.
+	CertExpiryWindow time.Duration
+
 	// Cache specifies the hostname scan cache store and expire time.
 	// If `nil`, then scans are not cached.
 	Cache *ScanCache
 
-	// lookupMXOverride specifies an alternate function to retrieve hostnames for a given
-	// domain. It is used to mock DNS lookups during testing.
-	lookupMXOverride func(string) ([]*net.MX, error)
+	// Resolver is used for all DNS lookups made during checks.
+	// If nil, a DNSResolver is used if $DNS_RESOLVER is set, or else a
+	// SystemResolver, which can't report whether answers were signed.
+	Resolver Resolver
+
+	// RootCAs is the set of root certificates that the certificates of
+	// mailservers and MTA-STS policy hosts must chain to, such as a
+	// corporate CA for internal MXs. See LoadRootCAs.
+	// If nil, the system's root certificates are used.
+	RootCAs *x509.CertPool
+
+	// TrustStore names RootCAs in results, e.g. the path of the bundle it
+	// was loaded from.
+	// If empty, "custom" is used, or "system" if RootCAs is nil.
+	TrustStore string
+
+	// Concurrency is the maximum number of hostnames of a domain that are
+	// checked at the same time.
+	// If 0, a default of 4 is used. Hostnames are always checked one at a
+	// time while recording or replaying.
+	Concurrency int
+
+	// ImplicitMX makes CheckDomain check the domain itself, like senders do,
+	// if it has A or AAAA records but no MX records.
+	// https://tools.ietf.org/html/rfc5321#section-5.1
+	ImplicitMX bool
+
+	// StatusPolicy determines which MXs the status of a domain is derived
+	// from. By default, it's derived from all MXs.
+	StatusPolicy StatusPolicy
+
+	// SimulateSender makes CheckDomain report, for each MX, whether a sender
+	// enforcing the domain's MTA-STS policy would deliver mail to it.
+	// See DomainResult.DeliveryVerdicts.
+	SimulateSender bool
+
+	// PolicyHostPort is the port that MTA-STS policies are fetched from.
+	// If empty, 443 is used. It's meant for tests, like those that use the
+	// fake policy host in package checkertest.
+	PolicyHostPort string
+
+	// Record, if set, records every DNS answer, SMTP transcript, TLS
+	// handshake and MTA-STS policy fetch that the checks observe.
+	Record *Recording
+
+	// Replay, if set, makes the checks observe what was recorded instead of
+	// querying the network, as if they were run at the time of the
+	// recording. Resolver is ignored.
+	Replay *Recording
+
+	// Checks selects the hostname checks that are run against each
+	// mailserver, by name, such as []string{STARTTLS, Certificate}. The
+	// checks they require are run too. Names that aren't hostname checks
+	// are ignored; see ValidateChecks.
+	// If empty, all of the hostname checks that aren't optional are run.
+	Checks []string
 
 	// CheckHostname defines the function that should be used to check each hostname.
 	// If nil, FullCheckHostname (all hostname checks) will be used.
@@ -34,3 +93,49 @@ func (c *Checker) timeout() time.Duration {
 	}
 	return 10 * time.Second
 }
+
+func (c *Checker) certExpiryWindow() time.Duration {
+	if c.CertExpiryWindow != 0 {
+		return c.CertExpiryWindow
+	}
+	return 14 * 24 * time.Hour
+}
+
+func (c *Checker) concurrency() int {
+	if c.Record != nil || c.Replay != nil {
+		// Connections are replayed in the order they were made to each
+		// address, which is only the same as when they were recorded if
+		// hostnames that share an address aren't checked at the same time.
+		return 1
+	}
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return 4
+}
+
+func (c *Checker) resolver() Resolver {
+	if c.Replay != nil {
+		return replayResolver{c.Replay}
+	}
+	var resolver Resolver = &SystemResolver{}
+	if c.Resolver != nil {
+		resolver = c.Resolver
+	} else if os.Getenv("DNS_RESOLVER") != "" {
+		resolver = &DNSResolver{}
+	}
+	if c.Record != nil {
+		return recordingResolver{resolver, c.Record}
+	}
+	return resolver
+}
+
+func (c *Checker) trustStore() string {
+	if c.TrustStore != "" {
+		return c.TrustStore
+	}
+	if c.RootCAs != nil {
+		return "custom"
+	}
+	return "system"
+}
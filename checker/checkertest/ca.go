@@ -0,0 +1,126 @@
+// Package checkertest provides fake mailservers, MTA-STS policy hosts and
+// DNS records, so that checks can be exercised without network access.
+//
+// A typical test issues certificates from a CA, starts an MTA and a
+// PolicyHost, publishes them in a Resolver, and checks the domain with a
+// checker.Checker that uses the Resolver, the CA's roots and the policy
+// host's port:
+//
+//	ca, _ := checkertest.NewCA()
+//	mta := &checkertest.MTA{Hostname: "mx.example.com", CA: ca}
+//	mta.Start()
+//	defer mta.Close()
+//	host := &checkertest.PolicyHost{Domain: "example.com", Policy: policy, CA: ca}
+//	host.Start()
+//	defer host.Close()
+//	resolver := &checkertest.Resolver{}
+//	resolver.AddMX("example.com", 10, mta)
+//	resolver.AddPolicyHost(host, "1")
+//	c := checker.Checker{Resolver: resolver, RootCAs: ca.Roots(), PolicyHostPort: host.Port()}
+//	result := c.CheckDomain("example.com", nil)
+//
+// MTAs can't accept SSLv3, since crypto/tls no longer implements it.
+package checkertest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+// CA is a certificate authority that issues certificates to fake servers.
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// NewCA creates a CA with a new key and self-signed root certificate.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "checkertest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key}, nil
+}
+
+// Roots returns a pool containing the CA's root certificate, for use as a
+// checker.Checker's RootCAs.
+func (ca *CA) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Issue returns a certificate for names that's valid for a year.
+// Like publicly trusted certificates, it's presented along with a Signed
+// Certificate Timestamp, which is attributed to a log named after the CA.
+func (ca *CA) Issue(names ...string) (tls.Certificate, error) {
+	return ca.IssueValidity(time.Now().Add(-time.Hour), time.Now().Add(365*24*time.Hour), names...)
+}
+
+// IssueValidity returns a certificate for names that's valid between
+// notBefore and notAfter, e.g. to test expired certificates.
+func (ca *CA) IssueValidity(notBefore, notAfter time.Time, names ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     names,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if len(names) > 0 {
+		template.Subject = pkix.Name{CommonName: names[0]}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate:                 [][]byte{der, ca.cert.Raw},
+		PrivateKey:                  key,
+		SignedCertificateTimestamps: [][]byte{ca.sct()},
+	}, nil
+}
+
+// sct returns a Signed Certificate Timestamp whose signature isn't valid,
+// which is enough for checks that only count the logs SCTs came from.
+// https://tools.ietf.org/html/rfc6962#section-3.2
+func (ca *CA) sct() []byte {
+	logID := sha256.Sum256(ca.cert.Raw)
+	// Version, log ID, timestamp, empty extensions, and a dummy signature.
+	sct := append([]byte{0}, logID[:]...)
+	return append(sct, make([]byte, 8+2+4)...)
+}
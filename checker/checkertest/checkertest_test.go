@@ -0,0 +1,239 @@
+package checkertest_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFForg/starttls-backend/checker"
+	"github.com/EFForg/starttls-backend/checker/checkertest"
+)
+
+const testTimeout = 500 * time.Millisecond
+
+func newCA(t *testing.T) *checkertest.CA {
+	ca, err := checkertest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func startMTA(t *testing.T, mta *checkertest.MTA) {
+	if err := mta.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkStatus(t *testing.T, name string, result *checker.Result, check string, want checker.Status) {
+	got, ok := result.Checks[check]
+	if !ok {
+		t.Errorf("%s: expected %s check, got %v", name, check, result.Checks)
+		return
+	}
+	if got.Status != want {
+		t.Errorf("%s: expected %s to have status %d, got %d: %v", name, check, want, got.Status, got.Messages)
+	}
+}
+
+func TestMTA(t *testing.T) {
+	ca := newCA(t)
+	tls10, err := ca.Issue("mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := ca.IssueValidity(time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour), "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		mta   *checkertest.MTA
+		check string
+		want  checker.Status
+	}{
+		{"valid", &checkertest.MTA{}, checker.Certificate, checker.Success},
+		{"no STARTTLS", &checkertest.MTA{NoSTARTTLS: true}, checker.STARTTLS, checker.Failure},
+		{"STARTTLS refused", &checkertest.MTA{Replies: map[string]string{"STARTTLS": "454 4.7.0 TLS not available"}}, checker.STARTTLS, checker.Failure},
+		{"dropped handshake", &checkertest.MTA{DropDuringHandshake: true}, checker.STARTTLS, checker.Failure},
+		{"injection", &checkertest.MTA{AnswerBufferedCommands: true}, checker.STARTTLSInjection, checker.Failure},
+		{"untrusted CA", &checkertest.MTA{CA: newCA(t)}, checker.Certificate, checker.Failure},
+		{"expired", &checkertest.MTA{TLSConfig: &tls.Config{Certificates: []tls.Certificate{expired}}}, checker.Certificate, checker.Failure},
+		{"TLS 1.0", &checkertest.MTA{TLSConfig: &tls.Config{Certificates: []tls.Certificate{tls10}, MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10}}, checker.Version, checker.Warning},
+		// Senders wait out greeting delays, so the checks do too.
+		{"slow banner", &checkertest.MTA{BannerDelay: 2 * testTimeout}, checker.Connectivity, checker.Success},
+		{"slow STARTTLS", &checkertest.MTA{Latency: map[string]time.Duration{"STARTTLS": testTimeout / 2}}, checker.STARTTLS, checker.Success},
+	}
+	for _, test := range tests {
+		mta := test.mta
+		mta.Hostname = "mx.example.com"
+		if mta.CA == nil {
+			mta.CA = ca
+		}
+		startMTA(t, mta)
+		resolver := &checkertest.Resolver{}
+		resolver.AddMX("example.com", 10, mta)
+		c := checker.Checker{Resolver: resolver, RootCAs: ca.Roots()}
+		result := c.FullCheckHostname("example.com", mta.MXHostname(), testTimeout)
+		mta.Close()
+		checkStatus(t, test.name, result.Result, test.check, test.want)
+	}
+}
+
+func TestMTACapabilities(t *testing.T) {
+	mta := checkertest.MTA{
+		Banner:        "mx.example.com ESMTP ready",
+		Extensions:    []string{"SIZE 1000", "8BITMIME"},
+		TLSExtensions: []string{"SIZE 2000", "REQUIRETLS"},
+	}
+	startMTA(t, &mta)
+	defer mta.Close()
+	c := checker.Checker{Resolver: &checkertest.Resolver{}, RootCAs: mta.CA.Roots()}
+	result := c.FullCheckHostname("", mta.Address(), testTimeout)
+	capabilities := result.Capabilities
+	if capabilities == nil {
+		t.Fatalf("expected capabilities, got %v", result)
+	}
+	if capabilities.Banner != mta.Banner {
+		t.Errorf("expected banner %q, got %q", mta.Banner, capabilities.Banner)
+	}
+	if strings.Join(capabilities.Extensions, ",") != "SIZE 1000,8BITMIME,STARTTLS" {
+		t.Errorf("unexpected extensions %v", capabilities.Extensions)
+	}
+	if capabilities.Size != 2000 || !capabilities.RequireTLS {
+		t.Errorf("expected the extensions advertised over TLS to be summarized, got %+v", capabilities)
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	ca := newCA(t)
+	mta := checkertest.MTA{Hostname: "mx.example.com", CA: ca}
+	startMTA(t, &mta)
+	defer mta.Close()
+	host := checkertest.PolicyHost{
+		Domain: "example.com",
+		Policy: "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\n",
+		CA:     ca,
+	}
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	resolver := &checkertest.Resolver{}
+	resolver.AddMX("example.com", 10, &mta)
+	resolver.AddPolicyHost(&host, "20190101")
+	c := checker.Checker{
+		Timeout:        testTimeout,
+		Resolver:       resolver,
+		RootCAs:        ca.Roots(),
+		PolicyHostPort: host.Port(),
+	}
+	result := c.CheckDomain("example.com", nil)
+	hostnameResult, ok := result.HostnameResults[mta.MXHostname()]
+	if !ok {
+		t.Fatalf("expected a result for %s, got %v", mta.MXHostname(), result.HostnameResults)
+	}
+	if hostnameResult.Status != checker.Success {
+		t.Errorf("expected the MX to pass, got %v", hostnameResult.Result)
+	}
+	if result.MTASTSResult == nil || result.MTASTSResult.Status != checker.Success || result.MTASTSResult.Mode != "enforce" {
+		t.Errorf("expected a valid MTA-STS policy, got %v", result.MTASTSResult)
+	}
+}
+
+func TestPolicyHost(t *testing.T) {
+	ca := newCA(t)
+	tests := []struct {
+		name  string
+		host  checkertest.PolicyHost
+		check string
+		want  checker.Status
+	}{
+		{"redirect", checkertest.PolicyHost{StatusCode: 301}, checker.MTASTSPolicyRedirect, checker.Failure},
+		{"not found", checkertest.PolicyHost{StatusCode: 404}, checker.MTASTSPolicyHTTPStatus, checker.Failure},
+		{"content type", checkertest.PolicyHost{ContentType: "text/html"}, checker.MTASTSPolicyContentType, checker.Warning},
+		{"untrusted CA", checkertest.PolicyHost{CA: newCA(t)}, checker.MTASTSPolicyHostTLS, checker.Failure},
+	}
+	mta := checkertest.MTA{Hostname: "mx.example.com", CA: ca}
+	startMTA(t, &mta)
+	defer mta.Close()
+	for _, test := range tests {
+		host := test.host
+		host.Domain = "example.com"
+		host.Policy = "version: STSv1\nmode: testing\nmx: mx.example.com\nmax_age: 86400\n"
+		if host.CA == nil {
+			host.CA = ca
+		}
+		if err := host.Start(); err != nil {
+			t.Fatal(err)
+		}
+		resolver := &checkertest.Resolver{}
+		resolver.AddMX("example.com", 10, &mta)
+		resolver.AddPolicyHost(&host, "1")
+		c := checker.Checker{
+			Timeout:        testTimeout,
+			Resolver:       resolver,
+			RootCAs:        ca.Roots(),
+			PolicyHostPort: host.Port(),
+		}
+		result := c.CheckDomain("example.com", nil)
+		host.Close()
+		if result.MTASTSResult == nil {
+			t.Fatalf("%s: expected an MTA-STS result", test.name)
+		}
+		checkStatus(t, test.name, result.MTASTSResult.Result, test.check, test.want)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ca := newCA(t)
+	mta := checkertest.MTA{Hostname: "mx.example.com", CA: ca, AnswerBufferedCommands: true}
+	startMTA(t, &mta)
+	host := checkertest.PolicyHost{
+		Domain: "example.com",
+		Policy: "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: 86400\n",
+		CA:     ca,
+	}
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	resolver := &checkertest.Resolver{}
+	resolver.AddMX("example.com", 10, &mta)
+	resolver.AddPolicyHost(&host, "20190101")
+	recording := checker.NewRecording()
+	c := checker.Checker{
+		Timeout:        testTimeout,
+		Resolver:       resolver,
+		RootCAs:        ca.Roots(),
+		PolicyHostPort: host.Port(),
+		Record:         recording,
+	}
+	recorded := c.CheckDomain("example.com", nil)
+	mta.Close()
+	host.Close()
+
+	var saved bytes.Buffer
+	if err := recording.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := checker.LoadRecording(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Record, c.Replay = nil, loaded
+	replayed := c.CheckDomain("example.com", nil)
+	if replayed.Status != recorded.Status || replayed.Status == checker.DomainSuccess {
+		t.Errorf("expected the replayed status %d to match the recorded failure %d", replayed.Status, recorded.Status)
+	}
+	if !reflect.DeepEqual(replayed.MTASTSResult.Result, recorded.MTASTSResult.Result) {
+		t.Errorf("expected replayed MTA-STS result %v to match %v", replayed.MTASTSResult.Result, recorded.MTASTSResult.Result)
+	}
+	hostname := mta.MXHostname()
+	if !reflect.DeepEqual(replayed.HostnameResults[hostname].Result, recorded.HostnameResults[hostname].Result) {
+		t.Errorf("expected replayed hostname result %v to match %v", replayed.HostnameResults[hostname].Result, recorded.HostnameResults[hostname].Result)
+	}
+}
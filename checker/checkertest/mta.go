@@ -0,0 +1,231 @@
+package checkertest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MTA is a fake SMTP server that supports STARTTLS, and can be configured
+// to misbehave in the ways the checks look for. Set its fields, then call
+// Start.
+type MTA struct {
+	// Hostname is the name the MTA's certificate is issued for, and that it
+	// announces in its greeting and EHLO responses.
+	// If empty, "localhost" is used.
+	Hostname string
+	// Banner is the text of the MTA's 220 greeting.
+	// If empty, "<Hostname> ESMTP checkertest" is used.
+	Banner string
+	// Extensions are the EHLO keywords, and their parameters, advertised
+	// before STARTTLS. STARTTLS itself is added unless NoSTARTTLS is set.
+	// If nil, DefaultExtensions are advertised.
+	Extensions []string
+	// TLSExtensions are advertised after STARTTLS. If nil, Extensions is
+	// used. Set it to an empty slice to advertise no extensions at all.
+	TLSExtensions []string
+
+	// TLSConfig is used to negotiate STARTTLS. If nil, the MTA presents a
+	// certificate for Hostname issued by CA.
+	TLSConfig *tls.Config
+	// CA issues the MTA's certificate if TLSConfig is nil. If nil, a new CA
+	// is created by Start.
+	CA *CA
+
+	// NoSTARTTLS stops the MTA from advertising or accepting STARTTLS.
+	NoSTARTTLS bool
+	// DropDuringHandshake makes the MTA close the connection once the
+	// client starts the TLS handshake.
+	DropDuringHandshake bool
+	// AnswerBufferedCommands makes the MTA answer, over TLS, commands that
+	// were sent along with STARTTLS, like servers vulnerable to
+	// CVE-2011-0411.
+	AnswerBufferedCommands bool
+	// Replies overrides the MTA's reply to commands, keyed by verb, e.g.
+	// {"STARTTLS": "454 4.7.0 TLS not available"}.
+	Replies map[string]string
+
+	// BannerDelay is how long the MTA waits before sending its greeting.
+	BannerDelay time.Duration
+	// Latency is how long the MTA waits before replying to commands, keyed
+	// by verb, e.g. {"EHLO": time.Second}.
+	Latency map[string]time.Duration
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// DefaultExtensions are the extensions MTAs advertise by default.
+var DefaultExtensions = []string{"SIZE 10240000", "8BITMIME", "PIPELINING"}
+
+func (m *MTA) hostname() string {
+	if m.Hostname != "" {
+		return m.Hostname
+	}
+	return "localhost"
+}
+
+// Start starts serving SMTP on a port of the loopback address.
+func (m *MTA) Start() error {
+	if m.TLSConfig == nil {
+		if m.CA == nil {
+			ca, err := NewCA()
+			if err != nil {
+				return err
+			}
+			m.CA = ca
+		}
+		cert, err := m.CA.Issue(m.hostname())
+		if err != nil {
+			return err
+		}
+		m.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	m.listener = ln
+	m.conns = make(map[net.Conn]bool)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			m.conns[conn] = true
+			m.mu.Unlock()
+			m.wg.Add(1)
+			go func() {
+				defer m.wg.Done()
+				m.serve(conn)
+				m.mu.Lock()
+				delete(m.conns, conn)
+				m.mu.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// Close stops the MTA, closing any open connections.
+func (m *MTA) Close() error {
+	err := m.listener.Close()
+	m.mu.Lock()
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+	return err
+}
+
+// Address returns the IP address and port that the MTA is listening on.
+func (m *MTA) Address() string {
+	return m.listener.Addr().String()
+}
+
+// Port returns the port that the MTA is listening on.
+func (m *MTA) Port() string {
+	_, port, _ := net.SplitHostPort(m.Address())
+	return port
+}
+
+// MXHostname returns the MTA's hostname and port, which can be checked
+// like an MX hostname, e.g. with checker.Checker.FullCheckHostname.
+func (m *MTA) MXHostname() string {
+	return net.JoinHostPort(m.hostname(), m.Port())
+}
+
+func (m *MTA) ehloReply(secure bool) string {
+	extensions := m.Extensions
+	if extensions == nil {
+		extensions = DefaultExtensions
+	}
+	if secure && m.TLSExtensions != nil {
+		extensions = m.TLSExtensions
+	}
+	if !secure && !m.NoSTARTTLS {
+		extensions = append(extensions[:len(extensions):len(extensions)], "STARTTLS")
+	}
+	lines := append([]string{m.hostname()}, extensions...)
+	reply := ""
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		reply += "250" + separator + line + "\r\n"
+	}
+	return reply
+}
+
+func (m *MTA) serve(conn net.Conn) {
+	defer conn.Close()
+	time.Sleep(m.BannerDelay)
+	banner := m.Banner
+	if banner == "" {
+		banner = m.hostname() + " ESMTP checkertest"
+	}
+	var rw net.Conn = conn
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(rw, "220 %s\r\n", banner)
+	secure := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		time.Sleep(m.Latency[verb])
+		if reply, ok := m.Replies[verb]; ok {
+			fmt.Fprintf(rw, "%s\r\n", reply)
+			continue
+		}
+		switch verb {
+		case "EHLO":
+			fmt.Fprint(rw, m.ehloReply(secure))
+		case "HELO":
+			fmt.Fprintf(rw, "250 %s\r\n", m.hostname())
+		case "STARTTLS":
+			if m.NoSTARTTLS || secure {
+				fmt.Fprint(rw, "502 5.5.1 Command not recognized\r\n")
+				continue
+			}
+			fmt.Fprint(rw, "220 2.0.0 Ready to start TLS\r\n")
+			if m.DropDuringHandshake {
+				// Wait for the ClientHello, so that the client sees the
+				// connection close during the handshake.
+				r.Peek(1)
+				return
+			}
+			// Clients only start the handshake once they've read our reply,
+			// so it can't have been buffered along with the command.
+			tlsConn := tls.Server(conn, m.TLSConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			// Commands left over in the plaintext buffer are answered over
+			// TLS by vulnerable servers, and discarded by the rest.
+			if m.AnswerBufferedCommands && r.Buffered() > 0 {
+				r.ReadString('\n')
+				fmt.Fprint(tlsConn, "250 2.0.0 OK\r\n")
+			}
+			rw, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "QUIT":
+			fmt.Fprint(rw, "221 2.0.0 Bye\r\n")
+			return
+		default:
+			fmt.Fprint(rw, "250 2.0.0 OK\r\n")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package checkertest
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// PolicyHost is a fake MTA-STS policy host, which serves a policy file
+// over HTTPS for mta-sts.<Domain>. Set its fields, then call Start.
+type PolicyHost struct {
+	// Domain is the mail domain whose policy is served.
+	Domain string
+	// Policy is the text of the policy file.
+	Policy string
+	// ContentType is the Content-Type of the policy file.
+	// If empty, "text/plain" is used.
+	ContentType string
+	// StatusCode is the HTTP status of responses. If it's a redirect, the
+	// policy is redirected to another host.
+	// If 0, http.StatusOK is used.
+	StatusCode int
+	// Delay is how long the host waits before responding.
+	Delay time.Duration
+
+	// TLSConfig is used to serve HTTPS. If nil, the host presents a
+	// certificate for mta-sts.<Domain> issued by CA.
+	TLSConfig *tls.Config
+	// CA issues the host's certificate if TLSConfig is nil. If nil, a new CA
+	// is created by Start.
+	CA *CA
+
+	server *httptest.Server
+}
+
+// Hostname returns the name of the policy host, mta-sts.<Domain>.
+func (p *PolicyHost) Hostname() string {
+	return "mta-sts." + p.Domain
+}
+
+// Start starts serving HTTPS on a port of the loopback address.
+func (p *PolicyHost) Start() error {
+	if p.TLSConfig == nil {
+		if p.CA == nil {
+			ca, err := NewCA()
+			if err != nil {
+				return err
+			}
+			p.CA = ca
+		}
+		cert, err := p.CA.Issue(p.Hostname())
+		if err != nil {
+			return err
+		}
+		p.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	p.server = httptest.NewUnstartedServer(http.HandlerFunc(p.serveHTTP))
+	p.server.TLS = p.TLSConfig
+	p.server.StartTLS()
+	return nil
+}
+
+// Close stops the policy host.
+func (p *PolicyHost) Close() {
+	p.server.Close()
+}
+
+// Port returns the port that the policy host is listening on, for use as a
+// checker.Checker's PolicyHostPort.
+func (p *PolicyHost) Port() string {
+	_, port, _ := net.SplitHostPort(p.server.Listener.Addr().String())
+	return port
+}
+
+func (p *PolicyHost) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(p.Delay)
+	if r.URL.Path != "/.well-known/mta-sts.txt" {
+		http.NotFound(w, r)
+		return
+	}
+	status := p.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= 300 && status < 400 {
+		http.Redirect(w, r, "https://www."+p.Domain+r.URL.Path, status)
+		return
+	}
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write([]byte(p.Policy))
+}
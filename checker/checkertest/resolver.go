@@ -0,0 +1,108 @@
+package checkertest
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/EFForg/starttls-backend/checker"
+)
+
+// Resolver is a checker.Resolver that answers from its maps, which are keyed
+// by name without a trailing dot. Names that aren't in the maps don't exist.
+type Resolver struct {
+	MX   map[string][]*net.MX
+	TXT  map[string][]string
+	IP   map[string][]net.IP
+	TLSA map[string][]checker.TLSARecord
+	SRV  map[string][]*net.SRV
+	// Secure marks every answer as authenticated with DNSSEC.
+	Secure bool
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name}
+}
+
+// AddMX publishes an MX record for domain that points to mta, which must
+// have been started, along with the address of mta's hostname. The MX
+// hostname is mta.MXHostname(), so that the checks connect to its port.
+func (r *Resolver) AddMX(domain string, pref uint16, mta *MTA) {
+	if r.MX == nil {
+		r.MX = make(map[string][]*net.MX)
+	}
+	r.MX[domain] = append(r.MX[domain], &net.MX{Host: mta.MXHostname(), Pref: pref})
+	r.AddIP(mta.hostname(), net.ParseIP("127.0.0.1"))
+}
+
+// AddPolicyHost publishes the _mta-sts TXT record of host's domain, with
+// id, along with the address of the policy host. The Checker's
+// PolicyHostPort must be set to host.Port().
+func (r *Resolver) AddPolicyHost(host *PolicyHost, id string) {
+	r.AddTXT("_mta-sts."+host.Domain, "v=STSv1; id="+id)
+	r.AddIP(host.Hostname(), net.ParseIP("127.0.0.1"))
+}
+
+// AddTXT publishes a TXT record.
+func (r *Resolver) AddTXT(name string, txt string) {
+	if r.TXT == nil {
+		r.TXT = make(map[string][]string)
+	}
+	r.TXT[name] = append(r.TXT[name], txt)
+}
+
+// AddIP publishes an A or AAAA record.
+func (r *Resolver) AddIP(name string, ip net.IP) {
+	if r.IP == nil {
+		r.IP = make(map[string][]net.IP)
+	}
+	r.IP[name] = append(r.IP[name], ip)
+}
+
+// LookupMX implements checker.Resolver.
+func (r *Resolver) LookupMX(_ context.Context, name string) ([]*net.MX, bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	if mxs, ok := r.MX[name]; ok {
+		return mxs, r.Secure, nil
+	}
+	return nil, r.Secure, notFound(name)
+}
+
+// LookupTXT implements checker.Resolver.
+func (r *Resolver) LookupTXT(_ context.Context, name string) ([]string, bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	if txts, ok := r.TXT[name]; ok {
+		return txts, r.Secure, nil
+	}
+	return nil, r.Secure, notFound(name)
+}
+
+// LookupIP implements checker.Resolver.
+func (r *Resolver) LookupIP(_ context.Context, name string) ([]net.IP, bool, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, false, nil
+	}
+	name = strings.TrimSuffix(name, ".")
+	if ips, ok := r.IP[name]; ok {
+		return ips, r.Secure, nil
+	}
+	return nil, r.Secure, notFound(name)
+}
+
+// LookupTLSA implements checker.Resolver.
+func (r *Resolver) LookupTLSA(_ context.Context, name string) ([]checker.TLSARecord, bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	if records, ok := r.TLSA[name]; ok {
+		return records, r.Secure, nil
+	}
+	return nil, r.Secure, notFound(name)
+}
+
+// LookupSRV implements checker.Resolver.
+func (r *Resolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, bool, error) {
+	name = strings.TrimSuffix(name, ".")
+	if srvs, ok := r.SRV[name]; ok {
+		return srvs, r.Secure, nil
+	}
+	return nil, r.Secure, notFound(name)
+}
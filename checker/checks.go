@@ -0,0 +1,138 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CheckInfo describes a check that the checker can run.
+type CheckInfo struct {
+	// Name identifies the check in results, e.g. "starttls".
+	Name string `json:"name"`
+	// Description is the full-text name of the check.
+	Description string `json:"description"`
+	// Hostname is true for the checks that are run against each address of
+	// a mailserver, which can be selected with Checker.Checks.
+	Hostname bool `json:"hostname,omitempty"`
+	// Requires lists the hostname checks that must run, and succeed or
+	// only warn, before this one can. Selecting a check selects the checks
+	// it requires too.
+	Requires []string `json:"requires,omitempty"`
+	// Optional hostname checks only run when they're selected.
+	Optional bool `json:"optional,omitempty"`
+
+	// run performs a hostname check against an address. It returns nil if
+	// the check doesn't apply to the address.
+	run func(*addressCheck) *Result
+}
+
+// checks lists every check. Hostname checks run in the order they're
+// listed, so a check must come after those it requires or depends on.
+var checks []CheckInfo
+
+// checkIndex maps the name of each check to its entry in checks.
+var checkIndex map[string]*CheckInfo
+
+func init() {
+	checks = []CheckInfo{
+		{Name: Connectivity, Description: "Server connectivity", Hostname: true,
+			run: checkConnectivity},
+		{Name: STARTTLS, Description: "Support for inbound STARTTLS", Hostname: true,
+			Requires: []string{Connectivity}, run: checkAddressSTARTTLS},
+		// DANE runs before the certificate check, which doesn't require
+		// certificates authenticated by DANE to chain to a trusted root.
+		{Name: DANE, Description: "Certificate matches DANE TLSA records", Hostname: true,
+			Requires: []string{STARTTLS}, run: checkAddressDANE},
+		{Name: Certificate, Description: "Valid certificate", Hostname: true,
+			Requires: []string{STARTTLS}, run: checkAddressCertificate},
+		{Name: Version, Description: "Secure version of TLS", Hostname: true,
+			Requires: []string{STARTTLS}, run: checkAddressVersion},
+		{Name: STARTTLSInjection, Description: "Not vulnerable to STARTTLS command injection", Hostname: true,
+			Requires: []string{STARTTLS}, run: checkAddressSTARTTLSInjection},
+		{Name: TLSEnumeration, Description: "Accepted TLS versions and cipher suites", Hostname: true,
+			Requires: []string{STARTTLS}, Optional: true, run: checkAddressTLSSupport},
+		{Name: ImplicitTLS, Description: "Support for implicit TLS"},
+		{Name: MTASTS, Description: "Inbound MTA-STS support"},
+		{Name: MTASTSText, Description: "Correct MTA-STS DNS record"},
+		{Name: MTASTSPolicyFile, Description: "Correct MTA-STS policy file"},
+		{Name: MTASTSPolicyHostDNS, Description: "MTA-STS policy host resolves in DNS"},
+		{Name: MTASTSPolicyHostTLS, Description: "Valid certificate on MTA-STS policy host"},
+		{Name: MTASTSPolicyRedirect, Description: "MTA-STS policy file isn't redirected"},
+		{Name: MTASTSPolicyHTTPStatus, Description: "MTA-STS policy file served with HTTP 200 OK"},
+		{Name: MTASTSPolicyContentType, Description: "MTA-STS policy file served as text/plain"},
+		{Name: MTASTSPolicyResponseTime, Description: "MTA-STS policy host responds promptly"},
+		{Name: MTASTSConsistency, Description: "MTA-STS record id changes with the policy"},
+		{Name: PolicyList, Description: "Status on EFF's STARTTLS Everywhere policy list"},
+		{Name: TLSRPT, Description: "Correct SMTP TLS Reporting (TLS-RPT) DNS record"},
+		{Name: BackupMX, Description: "Backup MXs are as secure as primary MXs"},
+		{Name: REQUIRETLS, Description: "Support for REQUIRETLS (informational)"},
+		{Name: SubmissionSRV, Description: "Submission servers published in SRV records"},
+	}
+	checkIndex = make(map[string]*CheckInfo)
+	for i := range checks {
+		checkIndex[checks[i].Name] = &checks[i]
+	}
+}
+
+// Checks returns every check the checker can run. Hostname checks are
+// listed in the order they run.
+func Checks() []CheckInfo {
+	return append([]CheckInfo{}, checks...)
+}
+
+// HostnameCheckNames returns the names of the hostname checks, which can be
+// selected with Checker.Checks.
+func HostnameCheckNames() []string {
+	names := []string{}
+	for _, check := range checks {
+		if check.Hostname {
+			names = append(names, check.Name)
+		}
+	}
+	return names
+}
+
+// ValidateChecks returns an error if any of names isn't a hostname check.
+func ValidateChecks(names []string) error {
+	unknown := []string{}
+	for _, name := range names {
+		if check, ok := checkIndex[name]; !ok || !check.Hostname {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown hostname checks %s; choose from %s",
+			strings.Join(unknown, ", "), strings.Join(HostnameCheckNames(), ", "))
+	}
+	return nil
+}
+
+// selectedChecks returns the names of the hostname checks that c runs: those
+// in c.Checks, or all that aren't optional, and the checks they require.
+func (c *Checker) selectedChecks() map[string]bool {
+	selected := make(map[string]bool)
+	var add func(name string)
+	add = func(name string) {
+		check, ok := checkIndex[name]
+		if !ok || !check.Hostname || selected[name] {
+			return
+		}
+		selected[name] = true
+		for _, required := range check.Requires {
+			add(required)
+		}
+	}
+	if len(c.Checks) == 0 {
+		for _, check := range checks {
+			if check.Hostname && !check.Optional {
+				add(check.Name)
+			}
+		}
+	}
+	for _, name := range c.Checks {
+		add(name)
+	}
+	return selected
+}
@@ -0,0 +1,91 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestChecksAreOrdered(t *testing.T) {
+	seen := map[string]bool{}
+	for _, check := range checks {
+		if check.Description == "" {
+			t.Errorf("expected %s to have a description", check.Name)
+		}
+		if check.Hostname && check.run == nil {
+			t.Errorf("expected hostname check %s to be runnable", check.Name)
+		}
+		for _, required := range check.Requires {
+			if !seen[required] || !checkIndex[required].Hostname {
+				t.Errorf("expected %s to require hostname checks listed before it, got %s", check.Name, required)
+			}
+		}
+		seen[check.Name] = true
+	}
+}
+
+func TestSelectedChecks(t *testing.T) {
+	tests := []struct {
+		checks []string
+		want   []string
+	}{
+		{nil, []string{Certificate, Connectivity, DANE, STARTTLS, STARTTLSInjection, Version}},
+		{[]string{Certificate}, []string{Certificate, Connectivity, STARTTLS}},
+		{[]string{Connectivity, "mta-sts", "bogus"}, []string{Connectivity}},
+		{[]string{TLSEnumeration}, []string{Connectivity, STARTTLS, TLSEnumeration}},
+	}
+	for _, test := range tests {
+		c := Checker{Checks: test.checks}
+		got := []string{}
+		for name := range c.selectedChecks() {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("checks %v: expected %v to run, got %v", test.checks, test.want, got)
+		}
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	if err := ValidateChecks([]string{STARTTLS, Certificate, DANE}); err != nil {
+		t.Errorf("expected hostname checks to be valid, got %v", err)
+	}
+	err := ValidateChecks([]string{STARTTLS, MTASTS, "bogus"})
+	if err == nil || !strings.Contains(err.Error(), "bogus, mta-sts") {
+		t.Errorf("expected unknown checks to be reported, got %v", err)
+	}
+}
+
+func TestCheckSubset(t *testing.T) {
+	ln := smtpListenAndServe(t, fakeSMTPConfig(t))
+	defer ln.Close()
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}, Checks: []string{Certificate}}
+	result := c.fullCheckHostname(context.Background(), "", ln.Addr().String())
+	got := []string{}
+	for name := range result.Checks {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{Certificate, Connectivity, STARTTLS}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected checks %v, got %v", want, got)
+	}
+}
+
+func TestSkipsChecksWhoseRequirementsFail(t *testing.T) {
+	// The server can't complete a handshake without a certificate.
+	ln := smtpListenAndServe(t, &tls.Config{})
+	defer ln.Close()
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}}
+	result := c.fullCheckHostname(context.Background(), "", ln.Addr().String())
+	if result.Checks[STARTTLS].Status != Failure {
+		t.Fatalf("expected STARTTLS to fail, got %v", result.Checks)
+	}
+	if _, ok := result.Checks[Certificate]; ok {
+		t.Errorf("expected the certificate check to be skipped, got %v", result.Checks)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/EFForg/starttls-backend/checker"
@@ -17,7 +18,7 @@ import (
 
 var out io.Writer = os.Stdout
 
-func setFlags() (domain, filePath, url *string, column *int, aggregate *bool) {
+func setFlags() (domain, filePath, url, rootCAs, record, replay, checks *string, column *int, aggregate, deep, simulate, submission *bool) {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -27,6 +28,13 @@ func setFlags() (domain, filePath, url *string, column *int, aggregate *bool) {
 	url = flag.String("url", "", "URL of a CSV of domains to check")
 	column = flag.Int("column", 0, "Zero indexed column of domains")
 	aggregate = flag.Bool("aggregate", false, "Write aggregated MTA-STS statistics to database, specified by ENV")
+	deep = flag.Bool("deep", false, "Enumerate the TLS versions and cipher suites accepted by each mailserver (slow)")
+	simulate = flag.Bool("simulate-sender", false, "Report whether a sender enforcing the MTA-STS policy would deliver to each MX")
+	submission = flag.Bool("submission", false, "Check the domain's mail submission servers (ports 587 and 465), found in its SRV records or guessed without them, instead of its MXs")
+	rootCAs = flag.String("root-cas", os.Getenv("ROOT_CAS"), "File path to a PEM bundle of root certificates to verify certificates against, instead of the system's (defaults to $ROOT_CAS)")
+	record = flag.String("record", "", "File path to record every DNS answer, SMTP transcript and TLS handshake observed during the check to")
+	replay = flag.String("replay", "", "File path of a recording to replay the check against, instead of the network")
+	checks = flag.String("checks", "", "Comma-separated hostname checks to run, along with the checks they require, instead of all of them: "+strings.Join(checker.HostnameCheckNames(), ", "))
 
 	flag.Parse()
 	if *domain == "" && *filePath == "" && *url == "" {
@@ -38,6 +46,21 @@ func setFlags() (domain, filePath, url *string, column *int, aggregate *bool) {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if *submission && *domain == "" {
+		log.Println("submission is only supported for single domain checks")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if (*record != "" || *replay != "") && *domain == "" {
+		log.Println("record and replay are only supported for single domain checks")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *record != "" && *replay != "" {
+		log.Println("record and replay can't be used together")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 	return
 }
 
@@ -45,18 +68,69 @@ func setFlags() (domain, filePath, url *string, column *int, aggregate *bool) {
 // =================================================
 // Validating (START)TLS configurations for all MX domains.
 func main() {
-	domain, filePath, url, column, aggregate := setFlags()
+	domain, filePath, url, rootCAs, record, replay, checks, column, aggregate, deep, simulate, submission := setFlags()
 
 	c := checker.Checker{
-		Cache: checker.MakeSimpleCache(10 * time.Minute),
+		Cache:          checker.MakeSimpleCache(10 * time.Minute),
+		SimulateSender: *simulate,
+	}
+	if *rootCAs != "" {
+		roots, err := checker.LoadRootCAs(*rootCAs)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		c.RootCAs = roots
+		c.TrustStore = *rootCAs
+	}
+	if *checks != "" {
+		c.Checks = strings.Split(*checks, ",")
+		if err := checker.ValidateChecks(c.Checks); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *record != "" {
+		c.Record = checker.NewRecording()
+	}
+	if *replay != "" {
+		recording, err := loadRecording(*replay)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		c.Replay = recording
+	}
+	if *deep {
+		if len(c.Checks) == 0 {
+			// Enumerate along with the checks that run by default.
+			for _, check := range checker.Checks() {
+				if check.Hostname && !check.Optional {
+					c.Checks = append(c.Checks, check.Name)
+				}
+			}
+		}
+		c.Checks = append(c.Checks, checker.TLSEnumeration)
 	}
 	var resultHandler checker.ResultHandler
 	resultHandler = &domainWriter{}
 
+	if *submission {
+		b, err := json.Marshal(c.CheckSubmission(*domain))
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(out, string(b))
+		saveRecording(c.Record, *record)
+		os.Exit(0)
+	}
+
 	if *domain != "" {
 		// Handle single domain and return
 		result := c.CheckDomain(*domain, nil)
 		resultHandler.HandleDomain(result)
+		saveRecording(c.Record, *record)
 		os.Exit(0)
 	}
 
@@ -95,6 +169,32 @@ func main() {
 	json.NewEncoder(out).Encode(resultHandler)
 }
 
+func loadRecording(path string) (*checker.Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return checker.LoadRecording(f)
+}
+
+// saveRecording writes recording to path, if recording is set.
+func saveRecording(recording *checker.Recording, path string) {
+	if recording == nil {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	if err := recording.Save(f); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
 type domainWriter struct{}
 
 func (w domainWriter) HandleDomain(r checker.DomainResult) {
@@ -0,0 +1,230 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TLSA certificate usages, selectors and matching types.
+// https://tools.ietf.org/html/rfc6698#section-2.1
+const (
+	tlsaUsagePKIXTA = 0
+	tlsaUsagePKIXEE = 1
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+
+	tlsaSelectorCert = 0
+	tlsaSelectorSPKI = 1
+
+	tlsaMatchingFull   = 0
+	tlsaMatchingSHA256 = 1
+	tlsaMatchingSHA512 = 2
+)
+
+// TLSARecord is a single TLSA resource record.
+type TLSARecord struct {
+	Usage        uint8  `json:"usage"`
+	Selector     uint8  `json:"selector"`
+	MatchingType uint8  `json:"matching_type"`
+	Certificate  string `json:"certificate"` // Hex-encoded certificate association data
+}
+
+// String returns the record in presentation format, without the owner name.
+func (t TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", t.Usage, t.Selector, t.MatchingType, t.Certificate)
+}
+
+// usable returns true if SMTP clients should use this record to authenticate
+// the server. DANE for SMTP only supports DANE-TA(2) and DANE-EE(3).
+// https://tools.ietf.org/html/rfc7672#section-3.1.3
+func (t TLSARecord) usable() bool {
+	if t.Usage != tlsaUsageDANETA && t.Usage != tlsaUsageDANEEE {
+		return false
+	}
+	if t.Selector != tlsaSelectorCert && t.Selector != tlsaSelectorSPKI {
+		return false
+	}
+	return t.MatchingType <= tlsaMatchingSHA512
+}
+
+// matches returns true if cert matches the certificate association data in
+// this record, ignoring the certificate usage.
+func (t TLSARecord) matches(cert *x509.Certificate) bool {
+	var data []byte
+	switch t.Selector {
+	case tlsaSelectorCert:
+		data = cert.Raw
+	case tlsaSelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch t.MatchingType {
+	case tlsaMatchingSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case tlsaMatchingSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	case tlsaMatchingFull:
+	default:
+		return false
+	}
+	want, err := hex.DecodeString(t.Certificate)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, want)
+}
+
+// tlsaName returns the owner name of the TLSA records for an SMTP server.
+// If hostname doesn't specify a port, port 25 is assumed.
+func tlsaName(hostname string) string {
+	port := "25"
+	if host, p, err := net.SplitHostPort(hostname); err == nil {
+		hostname, port = host, p
+	}
+	return fmt.Sprintf("_%s._tcp.%s", port, dns.Fqdn(hostname))
+}
+
+// verifyDANE returns nil iff the certificate chain in state is authenticated
+// by at least one of the usable TLSA records.
+//
+// DANE-EE(3) records match the leaf certificate, and names and validity
+// dates are ignored. DANE-TA(2) records match a trust anchor presented in
+// the chain, which the leaf must chain to and be valid for hostname.
+// https://tools.ietf.org/html/rfc7672#section-3.1
+func verifyDANE(state tls.ConnectionState, hostname string, records []TLSARecord) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	leaf := state.PeerCertificates[0]
+	for _, record := range records {
+		if record.Usage == tlsaUsageDANEEE && record.matches(leaf) {
+			return nil
+		}
+	}
+	var lastErr error
+	for _, record := range records {
+		if record.Usage != tlsaUsageDANETA {
+			continue
+		}
+		for _, anchor := range state.PeerCertificates {
+			if !record.matches(anchor) {
+				continue
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(anchor)
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				DNSName:       strings.TrimSuffix(withoutPort(hostname), "."),
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			if err == nil {
+				return nil
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("certificate matched a DANE-TA record but couldn't be validated: %v", lastErr)
+	}
+	return fmt.Errorf("no TLSA record matches the presented certificate chain")
+}
+
+// lookupTLSA retrieves the TLSA records published for hostname, and whether
+// they were authenticated with DNSSEC.
+func (c *Checker) lookupTLSA(ctx context.Context, hostname string) ([]TLSARecord, bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	records, secure, err := c.resolver().LookupTLSA(ctx, tlsaName(hostname))
+	if err != nil {
+		return nil, false
+	}
+	return records, secure
+}
+
+// checkDANE validates the certificate chain presented after STARTTLS
+// against the TLSA records published for hostname.
+// Returns nil if the hostname doesn't publish any TLSA records.
+func checkDANE(client *smtpClient, hostname string, records []TLSARecord, secure bool) *Result {
+	if len(records) == 0 {
+		// DANE doesn't apply to this hostname.
+		return nil
+	}
+	result := MakeResult(DANE)
+	if !secure {
+		// https://tools.ietf.org/html/rfc7672#section-2.2
+		return result.Code("dane.unsigned").Warning("The TLSA records published for %s aren't DNSSEC-signed, so senders will ignore them.", tlsaName(hostname))
+	}
+	state, ok := client.TLSConnectionState()
+	if !ok {
+		return result.Code("tls.not_initiated").Error("TLS not initiated properly.")
+	}
+	return validateDANE(state, hostname, records, result)
+}
+
+func validateDANE(state tls.ConnectionState, hostname string, records []TLSARecord, result *Result) *Result {
+	usable := []TLSARecord{}
+	for _, record := range records {
+		if record.usable() {
+			usable = append(usable, record)
+		}
+	}
+	if len(usable) == 0 {
+		// https://tools.ietf.org/html/rfc7672#section-2.2
+		return result.Code("dane.unusable_records").Warning("None of the %d TLSA records published for %s are usable for SMTP; only DANE-TA(2) and DANE-EE(3) records are supported. Senders will fall back to unauthenticated TLS.",
+			len(records), tlsaName(hostname))
+	}
+	if err := verifyDANE(state, hostname, usable); err != nil {
+		return result.Code("dane.mismatch").Failure("Certificate doesn't match the TLSA records published for %s: %v. DANE-enabled senders will not deliver mail to this server.",
+			tlsaName(hostname), err)
+	}
+	return result.Success()
+}
+
+// checkDomainDANE summarizes the DANE results of each hostname for a domain.
+// Returns nil if none of the hostnames publish TLSA records.
+func checkDomainDANE(hostnameResults map[string]HostnameResult) *Result {
+	result := MakeResult(DANE)
+	published := false
+	hostnames := make([]string, 0, len(hostnameResults))
+	for hostname := range hostnameResults {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		hostnameResult := hostnameResults[hostname]
+		if !hostnameResult.couldSTARTTLS() {
+			continue
+		}
+		daneResult, ok := hostnameResult.Checks[DANE]
+		if !ok {
+			result.Code("dane.not_published").Warning("%s doesn't publish TLSA records; DANE-enabled senders won't authenticate it.", hostname)
+			continue
+		}
+		published = true
+		if daneResult.Status != Success {
+			result.Code("dane.unusable").Failure("%s publishes TLSA records that couldn't be used to authenticate it.", hostname)
+		}
+	}
+	if !published {
+		return nil
+	}
+	return result.Success()
+}
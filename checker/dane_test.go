@@ -0,0 +1,99 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+)
+
+func testConnectionState(t *testing.T, certPEM string) tls.ConnectionState {
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
+
+func TestTLSAName(t *testing.T) {
+	tests := map[string]string{
+		"mx.example.com":      "_25._tcp.mx.example.com.",
+		"mx.example.com.":     "_25._tcp.mx.example.com.",
+		"mx.example.com:2525": "_2525._tcp.mx.example.com.",
+	}
+	for hostname, want := range tests {
+		if got := tlsaName(hostname); got != want {
+			t.Errorf("tlsaName(%s) = %s, want %s", hostname, got, want)
+		}
+	}
+}
+
+func TestValidateDANE(t *testing.T) {
+	state := testConnectionState(t, certString)
+	cert := state.PeerCertificates[0]
+	spki256 := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	cert512 := sha512.Sum512(cert.Raw)
+	spkiHash := hex.EncodeToString(spki256[:])
+	certHash := hex.EncodeToString(cert512[:])
+	certFull := hex.EncodeToString(cert.Raw)
+
+	tests := []struct {
+		desc     string
+		hostname string
+		records  []TLSARecord
+		status   Status
+	}{
+		{"DANE-EE SPKI SHA-256", "mx.example.com", []TLSARecord{{3, 1, 1, spkiHash}}, Success},
+		{"DANE-EE cert SHA-512", "mx.example.com", []TLSARecord{{3, 0, 2, certHash}}, Success},
+		{"DANE-EE full cert", "mx.example.com", []TLSARecord{{3, 0, 0, certFull}}, Success},
+		{"DANE-EE one of many", "mx.example.com", []TLSARecord{{3, 1, 1, "00"}, {3, 1, 1, spkiHash}}, Success},
+		{"DANE-EE mismatch", "mx.example.com", []TLSARecord{{3, 1, 1, "00"}}, Failure},
+		{"DANE-EE wrong selector", "mx.example.com", []TLSARecord{{3, 0, 1, spkiHash}}, Failure},
+		{"DANE-TA self-signed", "localhost", []TLSARecord{{2, 1, 1, spkiHash}}, Success},
+		{"DANE-TA hostname mismatch", "mx.example.com", []TLSARecord{{2, 1, 1, spkiHash}}, Failure},
+		{"PKIX-EE only", "mx.example.com", []TLSARecord{{1, 1, 1, spkiHash}}, Warning},
+		{"unknown matching type", "mx.example.com", []TLSARecord{{3, 1, 7, spkiHash}}, Warning},
+	}
+	for _, test := range tests {
+		result := validateDANE(state, test.hostname, test.records, MakeResult(DANE))
+		if result.Status != test.status {
+			t.Errorf("%s: validateDANE status = %d, want %d: %v", test.desc, result.Status, test.status, result.Messages)
+		}
+	}
+}
+
+func TestCheckDomainDANE(t *testing.T) {
+	withDANE := func(status Status) HostnameResult {
+		return HostnameResult{Result: &Result{Checks: map[string]*Result{
+			Connectivity: {Connectivity, Success, nil, nil, nil},
+			STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+			DANE:         {DANE, status, nil, nil, nil},
+		}}}
+	}
+	withoutDANE := HostnameResult{Result: &Result{Checks: map[string]*Result{
+		Connectivity: {Connectivity, Success, nil, nil, nil},
+		STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+	}}}
+
+	if result := checkDomainDANE(map[string]HostnameResult{"mx1": withoutDANE}); result != nil {
+		t.Errorf("expected no DANE result without TLSA records, got %v", result)
+	}
+	tests := []struct {
+		results map[string]HostnameResult
+		status  Status
+	}{
+		{map[string]HostnameResult{"mx1": withDANE(Success), "mx2": withDANE(Success)}, Success},
+		{map[string]HostnameResult{"mx1": withDANE(Success), "mx2": withoutDANE}, Warning},
+		{map[string]HostnameResult{"mx1": withDANE(Success), "mx2": withDANE(Failure)}, Failure},
+	}
+	for _, test := range tests {
+		result := checkDomainDANE(test.results)
+		if result == nil || result.Status != test.status {
+			t.Errorf("checkDomainDANE(%v) = %v, want status %d", test.results, result, test.status)
+		}
+	}
+}
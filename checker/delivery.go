@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeliveryVerdict is what a sender enforcing a domain's MTA-STS policy would
+// do when delivering to one of the domain's MXs.
+type DeliveryVerdict string
+
+// Values for DeliveryVerdict
+const (
+	// The sender would deliver mail to the MX.
+	VerdictDeliver DeliveryVerdict = "deliver"
+	// The sender couldn't connect to the MX, so it would try the next MX, or
+	// queue the mail and retry later.
+	VerdictDefer DeliveryVerdict = "defer"
+	// The sender would refuse to deliver mail to the MX. If no MX accepts
+	// the mail, it eventually bounces.
+	VerdictFail DeliveryVerdict = "fail"
+)
+
+// MXDeliveryVerdict is the DeliveryVerdict for a single MX hostname.
+type MXDeliveryVerdict struct {
+	Hostname string          `json:"hostname"`
+	Verdict  DeliveryVerdict `json:"verdict"`
+	Reason   string          `json:"reason,omitempty"`
+}
+
+// simulateDelivery walks hostnames in MX priority order, and decides what a
+// sender that applies the MTA-STS policy in "enforce" mode would do with
+// each of them, whatever the policy's current mode is. This shows what
+// switching a policy from "testing" to "enforce" would do.
+//
+// Like senders, it only relies on PKIX: MXs must present a certificate that's
+// valid for their name and trusted, whether or not it was authenticated
+// with DANE, and policies are ignored unless the policy host's certificate
+// is valid too. Senders also ignore policies without a valid _mta-sts TXT
+// record, and ones that don't parse.
+// https://tools.ietf.org/html/rfc8461#section-3.3
+// https://tools.ietf.org/html/rfc8461#section-5
+func simulateDelivery(policy *MTASTSResult, hostnames []string, hostnameResults map[string]HostnameResult) []MXDeliveryVerdict {
+	if policy == nil || len(policy.MXs) == 0 || !policy.policyHostPKIX.Valid() || !policy.policyValid {
+		// There's no policy for senders to enforce.
+		return nil
+	}
+	if record, ok := policy.Checks[MTASTSText]; !ok || record.Status > Warning {
+		// Senders never fetch the policy.
+		return nil
+	}
+	verdicts := []MXDeliveryVerdict{}
+	seen := make(map[string]bool)
+	for _, hostname := range hostnames {
+		if seen[hostname] {
+			continue
+		}
+		seen[hostname] = true
+		verdicts = append(verdicts, deliveryVerdict(policy.MXs, hostname, hostnameResults[hostname]))
+	}
+	return verdicts
+}
+
+func deliveryVerdict(patterns []string, hostname string, result HostnameResult) MXDeliveryVerdict {
+	verdict := MXDeliveryVerdict{Hostname: hostname, Verdict: VerdictFail}
+	if !PolicyMatches(hostname, patterns) {
+		verdict.Reason = fmt.Sprintf("%s doesn't match any of the mx patterns in your MTA-STS policy.", hostname)
+		return verdict
+	}
+	if result.Result == nil || !result.couldConnect() {
+		verdict.Verdict = VerdictDefer
+		verdict.Reason = fmt.Sprintf("Couldn't connect to %s, so senders would try another MX or retry later.", hostname)
+		return verdict
+	}
+	if !result.couldSTARTTLS() {
+		verdict.Reason = fmt.Sprintf("%s doesn't support STARTTLS.", hostname)
+		return verdict
+	}
+	if pkix := result.PKIX; !pkix.Valid() {
+		verdict.Reason = fmt.Sprintf("%s doesn't present a valid certificate for its name.", hostname)
+		if pkix != nil {
+			for _, err := range []string{pkix.HostnameError, pkix.ChainError} {
+				if err != "" {
+					verdict.Reason += " " + strings.ToUpper(err[:1]) + err[1:] + "."
+				}
+			}
+		}
+		return verdict
+	}
+	verdict.Verdict = VerdictDeliver
+	return verdict
+}
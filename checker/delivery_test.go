@@ -0,0 +1,130 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulateDelivery(t *testing.T) {
+	good := mockCheckHostname("", "mx.example.com", 0)
+	badCert := HostnameResult{Result: &Result{
+		Status: Failure,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, Success, nil, nil, nil},
+			STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+			Certificate:  {Certificate, Failure, []string{"Failure: Certificate has expired."}, nil, nil},
+		},
+	}, PKIX: &PKIXValidation{ChainError: "x509: certificate has expired or is not yet valid"}}
+	// Senders enforcing MTA-STS don't use DANE.
+	daneOnly := HostnameResult{Result: &Result{
+		Status: Success,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, Success, nil, nil, nil},
+			STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+			Certificate:  {Certificate, Success, nil, nil, nil},
+			DANE:         {DANE, Success, nil, nil, nil},
+		},
+	}, PKIX: &PKIXValidation{ChainError: "x509: certificate signed by unknown authority"}}
+	// Nor do they check the strength of the certificate's key.
+	weakKey := HostnameResult{Result: &Result{
+		Status: Failure,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, Success, nil, nil, nil},
+			STARTTLS:     {STARTTLS, Success, nil, nil, nil},
+			Certificate:  {Certificate, Failure, []string{"Failure: Certificate uses a 1024-bit RSA key."}, nil, nil},
+		},
+	}, PKIX: &PKIXValidation{}}
+	noSTARTTLS := HostnameResult{Result: &Result{
+		Status: Failure,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, Success, nil, nil, nil},
+			STARTTLS:     {STARTTLS, Failure, nil, nil, nil},
+		},
+	}}
+	noConnection := HostnameResult{Result: &Result{
+		Status: Error,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, Error, nil, nil, nil},
+		},
+	}}
+	policy := &MTASTSResult{Result: MakeResult(MTASTS), Mode: "testing", MXs: []string{"*.example.com"},
+		policyHostPKIX: &PKIXValidation{}, policyValid: true}
+	policy.addCheck(MakeResult(MTASTSText))
+	hostnames := []string{"mx1.example.com", "mx2.example.com", "mx3.example.com", "mx4.example.com",
+		"mx5.example.com", "mx6.example.com", "mx1.example.net", "mx1.example.com"}
+	results := map[string]HostnameResult{
+		"mx1.example.com": good,
+		"mx2.example.com": badCert,
+		"mx3.example.com": noSTARTTLS,
+		"mx4.example.com": noConnection,
+		"mx5.example.com": daneOnly,
+		"mx6.example.com": weakKey,
+		"mx1.example.net": good,
+	}
+	want := []DeliveryVerdict{VerdictDeliver, VerdictFail, VerdictFail, VerdictDefer, VerdictFail, VerdictDeliver, VerdictFail}
+	verdicts := simulateDelivery(policy, hostnames, results)
+	if len(verdicts) != len(want) {
+		t.Fatalf("Expected %d verdicts, got %v", len(want), verdicts)
+	}
+	for i, verdict := range verdicts {
+		if verdict.Hostname != hostnames[i] || verdict.Verdict != want[i] {
+			t.Errorf("Expected %s to get verdict %s, got %v", hostnames[i], want[i], verdict)
+		}
+		if verdict.Verdict != VerdictDeliver && verdict.Reason == "" {
+			t.Errorf("Expected a reason for verdict %v", verdict)
+		}
+	}
+
+	if verdicts := simulateDelivery(MakeMTASTSResult(), hostnames, results); verdicts != nil {
+		t.Errorf("Expected no verdicts without an MTA-STS policy, got %v", verdicts)
+	}
+	policy.policyHostPKIX = &PKIXValidation{HostnameError: "x509: certificate is valid for example.net, not mta-sts.example.com"}
+	if verdicts := simulateDelivery(policy, hostnames, results); verdicts != nil {
+		t.Errorf("Expected no verdicts when senders wouldn't trust the policy host, got %v", verdicts)
+	}
+	policy.policyHostPKIX = &PKIXValidation{}
+
+	policy.policyValid = false
+	if verdicts := simulateDelivery(policy, hostnames, results); verdicts != nil {
+		t.Errorf("Expected no verdicts when the policy is invalid, got %v", verdicts)
+	}
+	policy.policyValid = true
+
+	policy.addCheck(MakeResult(MTASTSText).Code("mta_sts.record_missing").Failure("Couldn't find an MTA-STS TXT record: %v.", "no such host"))
+	if verdicts := simulateDelivery(policy, hostnames, results); verdicts != nil {
+		t.Errorf("Expected no verdicts without an MTA-STS TXT record, got %v", verdicts)
+	}
+}
+
+func TestCheckDomainSimulateSender(t *testing.T) {
+	c := Checker{
+		Timeout:        time.Second,
+		Resolver:       mockResolver{},
+		CheckHostname:  mockCheckHostname,
+		SimulateSender: true,
+		checkMTASTSOverride: func(domain string, _ map[string]HostnameResult) *MTASTSResult {
+			r := MakeMTASTSResult()
+			r.Mode = "testing"
+			r.MXs = []string{"mail1.domain.tld"}
+			r.policyHostPKIX = &PKIXValidation{}
+			r.policyValid = true
+			r.addCheck(MakeResult(MTASTSText))
+			return r
+		},
+	}
+	result := c.CheckDomain("domain.tld", nil)
+	if len(result.DeliveryVerdicts) != 2 {
+		t.Fatalf("Expected a verdict for each MX, got %v", result.DeliveryVerdicts)
+	}
+	if v := result.DeliveryVerdicts[0]; v.Hostname != "mail2.domain.tld" || v.Verdict != VerdictFail {
+		t.Errorf("Expected unlisted mail2.domain.tld to fail, got %v", v)
+	}
+	if v := result.DeliveryVerdicts[1]; v.Hostname != "mail1.domain.tld" || v.Verdict != VerdictDeliver {
+		t.Errorf("Expected mail1.domain.tld to deliver, got %v", v)
+	}
+
+	c.SimulateSender = false
+	if result := c.CheckDomain("domain.tld", nil); result.DeliveryVerdicts != nil {
+		t.Errorf("Expected no verdicts unless SimulateSender is set, got %v", result.DeliveryVerdicts)
+	}
+}
@@ -0,0 +1,257 @@
+package checker
+
+import (
+	"sort"
+)
+
+// DomainResultDiff is what changed between two results for the same domain,
+// e.g. to find the regression that caused an alert.
+type DomainResultDiff struct {
+	Domain string `json:"domain"`
+	// Status of the domain, if it changed.
+	Status *DomainStatusChange `json:"status,omitempty"`
+	// MX hostnames that only appear in the new or the old result.
+	AddedHostnames   []string `json:"added_hostnames,omitempty"`
+	RemovedHostnames []string `json:"removed_hostnames,omitempty"`
+	// Changes to the hostnames that appear in both results.
+	Hostnames []HostnameDiff `json:"hostnames,omitempty"`
+	// Changes to the MTA-STS checks and policy.
+	MTASTS *MTASTSDiff `json:"mta_sts,omitempty"`
+	// Changes to ExtraResults, like the policy list and TLS-RPT checks.
+	ExtraChecks []CheckChange `json:"extra_checks,omitempty"`
+}
+
+// DomainStatusChange is a change to the status of a domain.
+type DomainStatusChange struct {
+	From DomainStatus `json:"from"`
+	To   DomainStatus `json:"to"`
+}
+
+// StatusChange is a change to the status of a check.
+type StatusChange struct {
+	From Status `json:"from"`
+	To   Status `json:"to"`
+}
+
+// ValueChange is a change to a value, like the mode of an MTA-STS policy.
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// CheckChange is a change to a check's result.
+type CheckChange struct {
+	Name string `json:"name"`
+	StatusChange
+	// Added and Removed are set for checks that only ran for the new or the
+	// old result. Added checks have a From of Success, and removed checks a
+	// To of Success.
+	Added   bool `json:"added,omitempty"`
+	Removed bool `json:"removed,omitempty"`
+	// NewlyFailing is set if the check fails or errors, but didn't before.
+	NewlyFailing bool `json:"newly_failing,omitempty"`
+	// Messages reported for the new result, but not the old one, and their
+	// details.
+	Messages []string        `json:"messages,omitempty"`
+	Details  []MessageDetail `json:"details,omitempty"`
+}
+
+// CertificateChange is a change to the leaf certificate of a hostname.
+type CertificateChange struct {
+	From CertificateSummary `json:"from"`
+	To   CertificateSummary `json:"to"`
+}
+
+// HostnameDiff is what changed for a hostname.
+type HostnameDiff struct {
+	Hostname string        `json:"hostname"`
+	Status   *StatusChange `json:"status,omitempty"`
+	Checks   []CheckChange `json:"checks,omitempty"`
+	// CertificateRotated is set if the hostname presented a different
+	// leaf certificate.
+	CertificateRotated *CertificateChange `json:"certificate_rotated,omitempty"`
+}
+
+// MTASTSDiff is what changed for MTA-STS.
+type MTASTSDiff struct {
+	Status   *StatusChange `json:"status,omitempty"`
+	Checks   []CheckChange `json:"checks,omitempty"`
+	Mode     *ValueChange  `json:"mode,omitempty"`
+	RecordID *ValueChange  `json:"record_id,omitempty"`
+	// mx patterns that only appear in the new or the old policy.
+	AddedMXs   []string `json:"added_mxs,omitempty"`
+	RemovedMXs []string `json:"removed_mxs,omitempty"`
+}
+
+// DiffDomainResults returns what changed from old to new, two results for
+// the same domain. Everything is ordered by name, so the same results
+// always produce the same diff.
+func DiffDomainResults(old, new DomainResult) DomainResultDiff {
+	diff := DomainResultDiff{Domain: new.Domain}
+	if old.Status != new.Status {
+		diff.Status = &DomainStatusChange{From: old.Status, To: new.Status}
+	}
+	diff.AddedHostnames, diff.RemovedHostnames = diffStrings(mxHostnames(old), mxHostnames(new))
+	hostnames := []string{}
+	for hostname := range new.HostnameResults {
+		if _, ok := old.HostnameResults[hostname]; ok {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		if h, changed := diffHostnameResults(hostname, old.HostnameResults[hostname], new.HostnameResults[hostname]); changed {
+			diff.Hostnames = append(diff.Hostnames, h)
+		}
+	}
+	if m, changed := diffMTASTSResults(old.MTASTSResult, new.MTASTSResult); changed {
+		diff.MTASTS = &m
+	}
+	diff.ExtraChecks = diffChecks(old.ExtraResults, new.ExtraResults)
+	return diff
+}
+
+// mxHostnames returns the MX hostnames of a result. Results stored before
+// MX records were recorded only have the hostnames that were checked.
+func mxHostnames(d DomainResult) []string {
+	hostnames := []string{}
+	if len(d.MXRecords) > 0 {
+		for _, mx := range d.MXRecords {
+			hostnames = append(hostnames, mx.Hostname)
+		}
+		return hostnames
+	}
+	for hostname := range d.HostnameResults {
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames
+}
+
+func diffHostnameResults(hostname string, old, new HostnameResult) (HostnameDiff, bool) {
+	diff := HostnameDiff{Hostname: hostname}
+	diff.Status = diffStatus(old.Result, new.Result)
+	diff.Checks = diffChecks(resultChecks(old.Result), resultChecks(new.Result))
+	if len(old.Certificates) > 0 && len(new.Certificates) > 0 {
+		from, to := old.Certificates[0], new.Certificates[0]
+		if from.SPKIFingerprint != to.SPKIFingerprint || from.SerialNumber != to.SerialNumber {
+			diff.CertificateRotated = &CertificateChange{From: from, To: to}
+		}
+	}
+	changed := diff.Status != nil || len(diff.Checks) > 0 || diff.CertificateRotated != nil
+	return diff, changed
+}
+
+func diffMTASTSResults(old, new *MTASTSResult) (MTASTSDiff, bool) {
+	if old == nil {
+		old = &MTASTSResult{}
+	}
+	if new == nil {
+		new = &MTASTSResult{}
+	}
+	diff := MTASTSDiff{}
+	diff.Status = diffStatus(old.Result, new.Result)
+	diff.Checks = diffChecks(resultChecks(old.Result), resultChecks(new.Result))
+	if old.Mode != new.Mode {
+		diff.Mode = &ValueChange{From: old.Mode, To: new.Mode}
+	}
+	if old.RecordID != new.RecordID {
+		diff.RecordID = &ValueChange{From: old.RecordID, To: new.RecordID}
+	}
+	diff.AddedMXs, diff.RemovedMXs = diffStrings(old.MXs, new.MXs)
+	changed := diff.Status != nil || len(diff.Checks) > 0 || diff.Mode != nil ||
+		diff.RecordID != nil || len(diff.AddedMXs) > 0 || len(diff.RemovedMXs) > 0
+	return diff, changed
+}
+
+func resultChecks(r *Result) map[string]*Result {
+	if r == nil {
+		return nil
+	}
+	return r.Checks
+}
+
+// diffStatus returns the change in status from old to new, or nil if there
+// wasn't any. A missing result doesn't have a status.
+func diffStatus(old, new *Result) *StatusChange {
+	if old == nil || new == nil || old.Status == new.Status {
+		return nil
+	}
+	return &StatusChange{From: old.Status, To: new.Status}
+}
+
+// diffChecks returns the checks whose status or messages changed from old
+// to new, ordered by name.
+func diffChecks(old, new map[string]*Result) []CheckChange {
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []CheckChange
+	for _, name := range names {
+		from, to := old[name], new[name]
+		change := CheckChange{Name: name}
+		switch {
+		case from == nil && to == nil:
+			continue
+		case from == nil:
+			change.Added = true
+			change.StatusChange = StatusChange{From: Success, To: to.Status}
+		case to == nil:
+			change.Removed = true
+			change.StatusChange = StatusChange{From: from.Status, To: Success}
+		default:
+			change.StatusChange = StatusChange{From: from.Status, To: to.Status}
+		}
+		change.NewlyFailing = change.To >= Failure && (change.Added || change.From < Failure)
+		if to != nil {
+			seen := make(map[string]bool)
+			if from != nil {
+				for _, message := range from.Messages {
+					seen[message] = true
+				}
+			}
+			for i, message := range to.Messages {
+				if !seen[message] {
+					change.Messages = append(change.Messages, message)
+					change.Details = append(change.Details, to.detail(i))
+				}
+			}
+		}
+		if change.Added || change.Removed || change.From != change.To || len(change.Messages) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// diffStrings returns the strings that are only in new, and only in old,
+// sorted.
+func diffStrings(old, new []string) (added, removed []string) {
+	inOld := make(map[string]bool)
+	for _, s := range old {
+		inOld[s] = true
+	}
+	inNew := make(map[string]bool)
+	for _, s := range new {
+		inNew[s] = true
+		if !inOld[s] {
+			added = append(added, s)
+			inOld[s] = true
+		}
+	}
+	for _, s := range old {
+		if !inNew[s] {
+			removed = append(removed, s)
+			inNew[s] = true
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffDomainResultsUnchanged(t *testing.T) {
+	diff := DiffDomainResults(NewSampleDomainResult("example.com"), NewSampleDomainResult("example.com"))
+	if !reflect.DeepEqual(diff, DomainResultDiff{Domain: "example.com"}) {
+		t.Errorf("expected identical results to have an empty diff, got %+v", diff)
+	}
+}
+
+func TestDiffDomainResults(t *testing.T) {
+	old := NewSampleDomainResult("example.com")
+	old.HostnameResults["mx.example.com"] = HostnameResult{
+		Result:       old.HostnameResults["mx.example.com"].Result,
+		Hostname:     "mx.example.com",
+		Certificates: []CertificateSummary{{SerialNumber: "1", SPKIFingerprint: "aa"}},
+	}
+	old.HostnameResults["mx2.example.com"] = HostnameResult{Result: MakeResult("hostnames"), Hostname: "mx2.example.com"}
+	old.MTASTSResult.RecordID = "1"
+
+	new := NewSampleDomainResult("example.com")
+	new.Status = DomainFailure
+	hostname := new.HostnameResults["mx.example.com"]
+	hostname.Result = MakeResult("hostnames")
+	hostname.addCheck(MakeResult(Connectivity))
+	hostname.addCheck(MakeResult(STARTTLS))
+	hostname.addCheck(MakeResult(Certificate).Code("cert.hostname_mismatch").Failure("Name in cert doesn't match hostname: %v", "bad name"))
+	hostname.addCheck(MakeResult(Version).Warning("Server should support TLSv1.2, but doesn't."))
+	hostname.addCheck(MakeResult(DANE))
+	hostname.Certificates = []CertificateSummary{{SerialNumber: "2", SPKIFingerprint: "aa"}}
+	new.HostnameResults["mx.example.com"] = hostname
+	new.HostnameResults["mx3.example.com"] = HostnameResult{Result: MakeResult("hostnames"), Hostname: "mx3.example.com"}
+	new.MTASTSResult.Mode = "testing"
+	new.MTASTSResult.MXs = []string{".example.com", "mx3.example.com"}
+	new.MTASTSResult.RecordID = "2"
+	new.MTASTSResult.Checks[MTASTSPolicyFile].Code("mta_sts.mode_testing").Warning("You're still in \"testing\" mode.")
+	new.MTASTSResult.Status = Warning
+	delete(new.ExtraResults, PolicyList)
+
+	diff := DiffDomainResults(old, new)
+	want := DomainResultDiff{
+		Domain:           "example.com",
+		Status:           &DomainStatusChange{From: DomainSuccess, To: DomainFailure},
+		AddedHostnames:   []string{"mx3.example.com"},
+		RemovedHostnames: []string{"mx2.example.com"},
+		Hostnames: []HostnameDiff{{
+			Hostname: "mx.example.com",
+			Status:   &StatusChange{From: Success, To: Failure},
+			Checks: []CheckChange{
+				{Name: Certificate, StatusChange: StatusChange{From: Success, To: Failure}, NewlyFailing: true,
+					Messages: []string{"Failure: Name in cert doesn't match hostname: bad name"},
+					Details: []MessageDetail{{Code: "cert.hostname_mismatch", Level: "failure",
+						Params: map[string]interface{}{"error": "bad name"}}}},
+				{Name: DANE, StatusChange: StatusChange{From: Success, To: Success}, Added: true},
+				{Name: Version, StatusChange: StatusChange{From: Success, To: Warning},
+					Messages: []string{"Warning: Server should support TLSv1.2, but doesn't."},
+					Details:  []MessageDetail{{Code: "version.warning", Level: "warning"}}},
+			},
+			CertificateRotated: &CertificateChange{
+				From: CertificateSummary{SerialNumber: "1", SPKIFingerprint: "aa"},
+				To:   CertificateSummary{SerialNumber: "2", SPKIFingerprint: "aa"},
+			},
+		}},
+		MTASTS: &MTASTSDiff{
+			Status: &StatusChange{From: Success, To: Warning},
+			Checks: []CheckChange{
+				{Name: MTASTSPolicyFile, StatusChange: StatusChange{From: Success, To: Warning},
+					Messages: []string{"Warning: You're still in \"testing\" mode."},
+					Details:  []MessageDetail{{Code: "mta_sts.mode_testing", Level: "warning"}}},
+			},
+			Mode:     &ValueChange{From: "enforce", To: "testing"},
+			RecordID: &ValueChange{From: "1", To: "2"},
+			AddedMXs: []string{"mx3.example.com"},
+		},
+		ExtraChecks: []CheckChange{
+			{Name: PolicyList, StatusChange: StatusChange{From: Success, To: Success}, Removed: true},
+		},
+	}
+	if !reflect.DeepEqual(diff.Hostnames, want.Hostnames) {
+		t.Errorf("expected hostname changes %+v, got %+v", want.Hostnames, diff.Hostnames)
+	}
+	if !reflect.DeepEqual(diff.MTASTS, want.MTASTS) {
+		t.Errorf("expected MTA-STS changes %+v, got %+v", want.MTASTS, diff.MTASTS)
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("expected diff %+v, got %+v", want, diff)
+	}
+}
+
+func TestDiffChecksNewlyFailing(t *testing.T) {
+	tests := []struct {
+		from, to *Result
+		want     bool
+	}{
+		{MakeResult(STARTTLS), MakeResult(STARTTLS).Failure("failed"), true},
+		{MakeResult(STARTTLS).Warning("warned"), MakeResult(STARTTLS).Error("errored"), true},
+		{MakeResult(STARTTLS).Failure("failed"), MakeResult(STARTTLS).Error("errored"), false},
+		{nil, MakeResult(STARTTLS).Failure("failed"), true},
+		{MakeResult(STARTTLS).Failure("failed"), nil, false},
+	}
+	for _, test := range tests {
+		old, new := map[string]*Result{}, map[string]*Result{}
+		if test.from != nil {
+			old[STARTTLS] = test.from
+		}
+		if test.to != nil {
+			new[STARTTLS] = test.to
+		}
+		changes := diffChecks(old, new)
+		if len(changes) != 1 || changes[0].NewlyFailing != test.want {
+			t.Errorf("%v to %v: expected newly failing to be %t, got %+v", test.from, test.to, test.want, changes)
+		}
+	}
+}
@@ -3,9 +3,8 @@ package checker
 import (
 	"context"
 	"fmt"
-	"net"
 	"strings"
-	"time"
+	"sync"
 
 	"golang.org/x/net/idna"
 )
@@ -17,6 +16,15 @@ func (d DomainResult) reportError(err error) DomainResult {
 	return d
 }
 
+// Reports that the domain checks were cancelled before they completed.
+// The results of the checks that did complete are kept.
+func (d DomainResult) reportCancelled(err error) DomainResult {
+	d.Status = DomainError
+	d.Cancelled = true
+	d.Message = fmt.Sprintf("Scan was cancelled before all checks completed: %v", err)
+	return d
+}
+
 // DomainStatus indicates the overall status of a single domain.
 type DomainStatus int32
 
@@ -31,6 +39,7 @@ const (
 	DomainNoSTARTTLSFailure  DomainStatus = 4
 	DomainCouldNotConnect    DomainStatus = 5
 	DomainBadHostnameFailure DomainStatus = 6
+	DomainNullMX             DomainStatus = 7
 )
 
 // DomainResult wraps all the results for a particular mail domain.
@@ -48,10 +57,20 @@ type DomainResult struct {
 	PreferredHostnames []string `json:"preferred_hostnames"`
 	// Expected MX hostnames supplied by the caller of CheckDomain.
 	MxHostnames []string `json:"mx_hostnames,omitempty"`
+	// The domain's MX records, in priority order.
+	MXRecords []MXRecord `json:"mx_records,omitempty"`
+	// Whether the MX records were authenticated with DNSSEC.
+	MXDNSSEC bool `json:"mx_dnssec"`
 	// Result of MTA-STS checks
 	MTASTSResult *MTASTSResult `json:"mta_sts"`
 	// Extra global results
 	ExtraResults map[string]*Result `json:"extra_results,omitempty"`
+	// What a sender enforcing the MTA-STS policy would do with each MX, in
+	// priority order. Only set if Checker.SimulateSender is set.
+	DeliveryVerdicts []MXDeliveryVerdict `json:"delivery_verdicts,omitempty"`
+	// Whether the checks were cancelled before they completed, in which case
+	// the results are partial.
+	Cancelled bool `json:"cancelled,omitempty"`
 }
 
 // Class satisfies raven's Interface interface.
@@ -65,34 +84,69 @@ func (d DomainResult) setStatus(status DomainStatus) DomainResult {
 	return d
 }
 
-func lookupMXWithTimeout(domain string, timeout time.Duration) ([]*net.MX, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
-	defer cancel()
-	var r net.Resolver
-	return r.LookupMX(ctx, domain)
-}
-
-// lookupHostnames retrieves the MX hostnames associated with a domain.
-func (c *Checker) lookupHostnames(domain string) ([]string, error) {
+// lookupMXs retrieves the MX records of a domain in priority order, and
+// whether they were authenticated with DNSSEC. A domain without MX records
+// isn't an error, even though resolvers report it as one.
+func (c *Checker) lookupMXs(ctx context.Context, domain string) ([]MXRecord, bool, error) {
 	domainASCII, err := idna.ToASCII(domain)
 	if err != nil {
-		return nil, fmt.Errorf("domain name %s couldn't be converted to ASCII", domain)
+		return nil, false, fmt.Errorf("domain name %s couldn't be converted to ASCII", domain)
 	}
-	// Allow the Checker to mock DNS lookup.
-	var mxs []*net.MX
-	if c.lookupMXOverride != nil {
-		mxs, err = c.lookupMXOverride(domain)
-	} else {
-		mxs, err = lookupMXWithTimeout(domainASCII, c.timeout())
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	mxs, secure, err := c.resolver().LookupMX(ctx, domainASCII)
+	if isNotFound(err) {
+		return nil, secure, nil
 	}
-	if err != nil || len(mxs) == 0 {
-		return nil, fmt.Errorf("No MX records found")
+	if err != nil {
+		return nil, false, fmt.Errorf("Couldn't look up MX records for %s: %v", domain, err)
 	}
-	hostnames := make([]string, 0)
+	records := make([]MXRecord, 0)
 	for _, mx := range mxs {
-		hostnames = append(hostnames, strings.ToLower(mx.Host))
+		records = append(records, MXRecord{Hostname: strings.ToLower(mx.Host), Pref: mx.Pref})
+	}
+	sortMXRecords(records)
+	return records, secure, nil
+}
+
+// checkHostnames checks each of hostnames, running up to c.Concurrency
+// checks at the same time.
+func (c *Checker) checkHostnames(ctx context.Context, domain string, hostnames []string) map[string]HostnameResult {
+	results := make(map[string]HostnameResult)
+	unique := make([]string, 0)
+	queued := make(map[string]bool)
+	for _, hostname := range hostnames {
+		if !queued[hostname] {
+			queued[hostname] = true
+			unique = append(unique, hostname)
+		}
+	}
+	workers := c.concurrency()
+	if workers > len(unique) {
+		workers = len(unique)
+	}
+
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hostname := range jobs {
+				hostnameResult := c.checkHostname(ctx, domain, hostname)
+				mu.Lock()
+				results[hostname] = hostnameResult
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, hostname := range unique {
+		jobs <- hostname
 	}
-	return hostnames, nil
+	close(jobs)
+	wg.Wait()
+	return results
 }
 
 // CheckDomain performs all associated checks for a particular domain.
@@ -100,13 +154,21 @@ func (c *Checker) lookupHostnames(domain string) ([]string, error) {
 // resulting hostnames.
 //
 // The status of DomainResult is inherited from the check status of the MX
-// records with highest priority. This check succeeds only if the hostname
-// checks on the highest priority mailservers succeed.
+// hostnames we could connect to. Checker.StatusPolicy determines whether
+// that's all of them, or only those with the highest priority. Problems with
+// lower priority (backup) MXs are also reported in the backup-mx check.
 //
 //   `domain` is the mail domain to perform the lookup on.
 //   `expectedHostnames` is the list of expected hostnames.
 //     If `expectedHostnames` is nil, we don't validate the DNS lookup.
 func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainResult {
+	return c.CheckDomainContext(context.Background(), domain, expectedHostnames)
+}
+
+// CheckDomainContext is like CheckDomain, but the hostname checks and the
+// MTA-STS check run concurrently, and are abandoned once ctx is done.
+// In that case, the partial result is marked as Cancelled.
+func (c *Checker) CheckDomainContext(ctx context.Context, domain string, expectedHostnames []string) DomainResult {
 	result := DomainResult{
 		Domain:          domain,
 		MxHostnames:     expectedHostnames,
@@ -116,27 +178,101 @@ func (c *Checker) CheckDomain(domain string, expectedHostnames []string) DomainR
 	// 1. Look up hostnames
 	// 2. Perform and aggregate checks from those hostnames.
 	// 3. Set a summary message.
-	hostnames, err := c.lookupHostnames(domain)
+	records, secure, err := c.lookupMXs(ctx, domain)
+	if err == nil && len(records) == 0 && c.ImplicitMX {
+		records, err = c.implicitMX(ctx, domain)
+		if err == nil {
+			result.Message = fmt.Sprintf("%s has no MX records, so senders deliver to its A/AAAA records instead (implicit MX).", domain)
+		}
+	} else if err == nil && len(records) == 0 {
+		err = fmt.Errorf("No MX records found for %s", domain)
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return result.reportCancelled(ctx.Err())
+		}
+		result.Message = err.Error()
 		return result.setStatus(DomainCouldNotConnect)
 	}
+	result.MXDNSSEC = secure
+	records, null := withoutNullMX(records)
+	if null && len(records) == 0 {
+		result.Message = fmt.Sprintf("%s publishes a null MX record, which means it doesn't accept mail.", domain)
+		return result.setStatus(DomainNullMX)
+	} else if null {
+		result.Message = fmt.Sprintf("%s publishes a null MX record alongside other MX records, which RFC 7505 forbids. We ignored the null MX.", domain)
+	}
+	result.MXRecords = records
+	hostnames := make([]string, 0)
+	for _, record := range records {
+		hostnames = append(hostnames, record.Hostname)
+	}
+
+	// The MTA-STS policy and TLS-RPT record are fetched while the hostnames
+	// are being checked.
+	mtastsResults := make(chan *MTASTSResult, 1)
+	if c.checkMTASTSOverride == nil {
+		go func() {
+			mtastsResults <- c.fetchMTASTS(ctx, domain)
+		}()
+	}
+	tlsrptResults := make(chan *Result, 1)
+	go func() {
+		tlsrptResults <- c.checkTLSRPT(ctx, domain)
+	}()
+	result.HostnameResults = c.checkHostnames(ctx, domain, hostnames)
 	checkedHostnames := make([]string, 0)
+	seen := make(map[string]bool)
 	for _, hostname := range hostnames {
-		hostnameResult := c.checkHostname(domain, hostname)
-		result.HostnameResults[hostname] = hostnameResult
-		if hostnameResult.couldConnect() {
+		if !seen[hostname] && result.HostnameResults[hostname].couldConnect() {
 			checkedHostnames = append(checkedHostnames, hostname)
 		}
+		seen[hostname] = true
 	}
 	result.PreferredHostnames = checkedHostnames
-	result.MTASTSResult = c.checkMTASTS(domain, result.HostnameResults)
+	if c.checkMTASTSOverride != nil {
+		// Allow the Checker to mock MTA-STS checks.
+		result.MTASTSResult = c.checkMTASTSOverride(domain, result.HostnameResults)
+	} else {
+		result.MTASTSResult = <-mtastsResults
+		result.MTASTSResult.validateMXs(result.HostnameResults)
+	}
+	tlsrptResult := <-tlsrptResults
+	if result.MTASTSResult.Mode == "testing" && tlsrptResult.Status != Success {
+		tlsrptResult.Code("tls_rpt.missing_in_testing_mode").Warning("Your MTA-STS policy is in \"testing\" mode, which is meant to be used with TLS-RPT: without it, you won't learn which senders would fail to deliver mail once you switch to \"enforce\".")
+	}
+	result.ExtraResults[TLSRPT] = tlsrptResult
+	if daneResult := checkDomainDANE(result.HostnameResults); daneResult != nil {
+		result.ExtraResults[DANE] = daneResult
+	}
+	if requireTLSResult := checkDomainREQUIRETLS(domain, result.HostnameResults); requireTLSResult != nil {
+		result.ExtraResults[REQUIRETLS] = requireTLSResult
+	}
+	if c.SimulateSender {
+		result.DeliveryVerdicts = simulateDelivery(result.MTASTSResult, hostnames, result.HostnameResults)
+	}
+	primary, backup := primaryHostnames(checkedHostnames, records)
+	if len(backup) > 0 {
+		result.ExtraResults[BackupMX] = checkBackupMXs(backup, result.HostnameResults)
+	}
+	if ctx.Err() != nil {
+		return result.reportCancelled(ctx.Err())
+	}
 
 	// Derive Domain code from Hostname results.
 	if len(checkedHostnames) == 0 {
 		// We couldn't connect to any of those hostnames.
 		return result.setStatus(DomainCouldNotConnect)
 	}
-	for _, hostname := range checkedHostnames {
+	statusHostnames := checkedHostnames
+	if c.StatusPolicy == StatusPrimaryMXs || c.StatusPolicy == StatusWeighted {
+		statusHostnames = primary
+	}
+	if c.StatusPolicy == StatusWeighted && result.ExtraResults[BackupMX] != nil &&
+		result.ExtraResults[BackupMX].Status != Success {
+		result = result.setStatus(DomainWarning)
+	}
+	for _, hostname := range statusHostnames {
 		hostnameResult := result.HostnameResults[hostname]
 		// Any of the connected hostnames don't support STARTTLS.
 		if !hostnameResult.couldSTARTTLS() {
@@ -1,8 +1,11 @@
 package checker
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,6 +19,21 @@ var mxLookup = map[string][]string{
 	"noconnection":  []string{"noconnection", "noconnection"},
 	"noconnection2": []string{"noconnection", "nostarttlsconnect"},
 	"nostarttls":    []string{"nostarttls", "noconnection"},
+	"many":          []string{"mx1", "mx2", "mx3", "mx4", "mx5", "mx6", "mx7", "mx8"},
+	"mixed":         []string{"primary1", "primary2", "nostarttls"},
+	"unordered":     []string{"nostarttls", "primary1"},
+	"warnbackup":    []string{"primary1", "warning"},
+	"primarydown":   []string{"noconnection", "backup"},
+	"nullmx":        []string{"."},
+	"nullmixed":     []string{".", "hostname1"},
+}
+
+// fake MX preferences, for domains whose MXs don't all have preference 0
+var mxPreferences = map[string][]uint16{
+	"mixed":       []uint16{10, 10, 20},
+	"unordered":   []uint16{20, 10},
+	"warnbackup":  []uint16{10, 20},
+	"primarydown": []uint16{10, 20},
 }
 
 // Fake hostname checks :)
@@ -23,21 +41,29 @@ var hostnameResults = map[string]Result{
 	"noconnection": Result{
 		Status: 3,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 3, nil, nil},
+			Connectivity: {Connectivity, 3, nil, nil, nil},
 		},
 	},
 	"nostarttls": Result{
 		Status: 2,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 0, nil, nil},
-			STARTTLS:     {STARTTLS, 2, nil, nil},
+			Connectivity: {Connectivity, 0, nil, nil, nil},
+			STARTTLS:     {STARTTLS, 2, nil, nil, nil},
+		},
+	},
+	"warning": Result{
+		Status: 1,
+		Checks: map[string]*Result{
+			Connectivity: {Connectivity, 0, nil, nil, nil},
+			STARTTLS:     {STARTTLS, 0, nil, nil, nil},
+			Certificate:  {Certificate, 1, nil, nil, nil},
 		},
 	},
 	"nostarttlsconnect": Result{
 		Status: 3,
 		Checks: map[string]*Result{
-			Connectivity: {Connectivity, 0, nil, nil},
-			STARTTLS:     {STARTTLS, 3, nil, nil},
+			Connectivity: {Connectivity, 0, nil, nil, nil},
+			STARTTLS:     {STARTTLS, 3, nil, nil, nil},
 		},
 	},
 }
@@ -48,15 +74,41 @@ func mockCheckMTASTS(domain string, hostnameResults map[string]HostnameResult) *
 	return r
 }
 
-func mockLookupMX(domain string) ([]*net.MX, error) {
+// mockResolver "resolves" MX lookups using mxLookup.
+type mockResolver struct{}
+
+func (mockResolver) LookupMX(_ context.Context, domain string) ([]*net.MX, bool, error) {
 	if domain == "error" {
-		return nil, fmt.Errorf("No MX records found")
+		return nil, false, fmt.Errorf("No MX records found")
 	}
 	result := []*net.MX{}
-	for _, host := range mxLookup[domain] {
-		result = append(result, &net.MX{Host: host})
+	for i, host := range mxLookup[domain] {
+		mx := &net.MX{Host: host}
+		if prefs, ok := mxPreferences[domain]; ok {
+			mx.Pref = prefs[i]
+		}
+		result = append(result, mx)
+	}
+	return result, false, nil
+}
+
+func (mockResolver) LookupTXT(_ context.Context, name string) ([]string, bool, error) {
+	return nil, false, fmt.Errorf("no TXT records for %s", name)
+}
+
+func (mockResolver) LookupIP(_ context.Context, name string) ([]net.IP, bool, error) {
+	if name == "localhost" || name == "implicit" {
+		return []net.IP{net.ParseIP("127.0.0.1")}, false, nil
 	}
-	return result, nil
+	return nil, false, fmt.Errorf("no addresses for %s", name)
+}
+
+func (mockResolver) LookupTLSA(_ context.Context, name string) ([]TLSARecord, bool, error) {
+	return nil, false, fmt.Errorf("no TLSA records for %s", name)
+}
+
+func (mockResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, bool, error) {
+	return nil, false, fmt.Errorf("no SRV records for %s", name)
 }
 
 func mockCheckHostname(domain string, hostname string, _ time.Duration) HostnameResult {
@@ -75,12 +127,13 @@ func mockCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 		Result: &Result{
 			Status: 0,
 			Checks: map[string]*Result{
-				Connectivity: {Connectivity, 0, nil, nil},
-				STARTTLS:     {STARTTLS, 0, nil, nil},
-				Certificate:  {Certificate, 0, nil, nil},
-				Version:      {Version, 0, nil, nil},
+				Connectivity: {Connectivity, 0, nil, nil, nil},
+				STARTTLS:     {STARTTLS, 0, nil, nil, nil},
+				Certificate:  {Certificate, 0, nil, nil, nil},
+				Version:      {Version, 0, nil, nil, nil},
 			},
 		},
+		PKIX:      &PKIXValidation{},
 		Timestamp: time.Now(),
 	}
 }
@@ -112,7 +165,7 @@ func performTestsWithCacheTimeout(t *testing.T, tests []domainTestCase, cacheExp
 	c := Checker{
 		Timeout:             time.Second,
 		Cache:               MakeSimpleCache(cacheExpiry),
-		lookupMXOverride:    mockLookupMX,
+		Resolver:            mockResolver{},
 		CheckHostname:       mockCheckHostname,
 		checkMTASTSOverride: mockCheckMTASTS,
 	}
@@ -188,6 +241,224 @@ func TestHostnameScanExpires(t *testing.T) {
 	performTestsWithCacheTimeout(t, tests, 0)
 }
 
+func TestCheckDomainConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	c := Checker{
+		Resolver:    mockResolver{},
+		Concurrency: 3,
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return mockCheckHostname(domain, hostname, timeout)
+		},
+		checkMTASTSOverride: mockCheckMTASTS,
+	}
+	result := c.CheckDomain("many", nil)
+	if result.Status != DomainSuccess || len(result.HostnameResults) != 8 {
+		t.Errorf("expected 8 successful hostname results, got %v", result)
+	}
+	if maxRunning != 3 {
+		t.Errorf("expected 3 hostnames to be checked at once, got %d", maxRunning)
+	}
+	if len(result.PreferredHostnames) != 8 || result.PreferredHostnames[0] != "mx1" {
+		t.Errorf("expected preferred hostnames in MX order, got %v", result.PreferredHostnames)
+	}
+}
+
+func TestCheckDomainContextCancelled(t *testing.T) {
+	c := Checker{
+		Resolver: mockResolver{},
+		Cache:    MakeSimpleCache(time.Hour),
+		CheckHostname: func(domain string, hostname string, timeout time.Duration) HostnameResult {
+			if hostname == "mx1" {
+				return mockCheckHostname(domain, hostname, timeout)
+			}
+			// The other hostnames never respond.
+			time.Sleep(time.Hour)
+			return HostnameResult{}
+		},
+		checkMTASTSOverride: mockCheckMTASTS,
+		Concurrency:         1,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := c.CheckDomainContext(ctx, "many", nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected check to stop when the context expired, took %v", elapsed)
+	}
+	if !result.Cancelled || result.Status != DomainError || result.Message == "" {
+		t.Errorf("expected result to be marked as cancelled, got %v", result)
+	}
+	if len(result.HostnameResults) != 8 {
+		t.Fatalf("expected a result for each hostname, got %v", result.HostnameResults)
+	}
+	if !result.HostnameResults["mx1"].couldSTARTTLS() {
+		t.Error("expected completed hostname check to be kept")
+	}
+	if result.HostnameResults["mx8"].couldConnect() {
+		t.Error("expected cancelled hostname check to report an error")
+	}
+	if _, err := c.Cache.GetHostnameScan("mx2"); err == nil {
+		t.Error("expected cancelled hostname check not to be cached")
+	}
+}
+
+func TestTLSRPTMissingInTestingMode(t *testing.T) {
+	c := Checker{
+		Resolver:            mockResolver{},
+		CheckHostname:       mockCheckHostname,
+		checkMTASTSOverride: mockCheckMTASTS,
+	}
+	result := c.CheckDomain("domain", nil)
+	tlsrpt, ok := result.ExtraResults[TLSRPT]
+	if !ok || tlsrpt.Status != Warning || len(tlsrpt.Messages) != 2 {
+		t.Errorf("expected TLS-RPT warnings about the missing record and testing mode, got %v", tlsrpt)
+	}
+	if result.Status != DomainSuccess {
+		t.Errorf("expected missing TLS-RPT record not to affect domain status, got %d", result.Status)
+	}
+}
+
 func TestNewSampleDomainResult(t *testing.T) {
 	NewSampleDomainResult("example.com")
 }
+
+func TestMXPreferences(t *testing.T) {
+	tests := []struct {
+		domain string
+		// Expected status for StatusAllMXs, StatusPrimaryMXs and StatusWeighted.
+		expect [3]DomainStatus
+		// Expected status of the backup-mx check, or -1 if it shouldn't run.
+		backup Status
+	}{
+		{"mixed", [3]DomainStatus{DomainNoSTARTTLSFailure, DomainSuccess, DomainWarning}, Failure},
+		{"unordered", [3]DomainStatus{DomainNoSTARTTLSFailure, DomainSuccess, DomainWarning}, Failure},
+		{"warnbackup", [3]DomainStatus{DomainWarning, DomainSuccess, DomainWarning}, Warning},
+		// When the primary is down, the backup is the effective primary.
+		{"primarydown", [3]DomainStatus{DomainSuccess, DomainSuccess, DomainSuccess}, -1},
+		{"domain", [3]DomainStatus{DomainSuccess, DomainSuccess, DomainSuccess}, -1},
+	}
+	for _, test := range tests {
+		for i, policy := range []StatusPolicy{StatusAllMXs, StatusPrimaryMXs, StatusWeighted} {
+			c := Checker{
+				Timeout:             time.Second,
+				Resolver:            mockResolver{},
+				CheckHostname:       mockCheckHostname,
+				checkMTASTSOverride: mockCheckMTASTS,
+				StatusPolicy:        policy,
+			}
+			result := c.CheckDomain(test.domain, nil)
+			if result.Status != test.expect[i] {
+				t.Errorf("%s with status policy %d: expected status %d, got %d",
+					test.domain, policy, test.expect[i], result.Status)
+			}
+			backup, ok := result.ExtraResults[BackupMX]
+			if test.backup == -1 && ok {
+				t.Errorf("%s: expected no backup-mx check, got %v", test.domain, backup)
+			} else if test.backup != -1 && (!ok || backup.Status != test.backup) {
+				t.Errorf("%s: expected backup-mx check with status %d, got %v", test.domain, test.backup, backup)
+			}
+		}
+	}
+}
+
+func TestMXRecordsSorted(t *testing.T) {
+	c := Checker{
+		Timeout:             time.Second,
+		Resolver:            mockResolver{},
+		CheckHostname:       mockCheckHostname,
+		checkMTASTSOverride: mockCheckMTASTS,
+	}
+	result := c.CheckDomain("unordered", nil)
+	want := []MXRecord{{"primary1", 10}, {"nostarttls", 20}}
+	if len(result.MXRecords) != len(want) {
+		t.Fatalf("Expected MX records %v, got %v", want, result.MXRecords)
+	}
+	for i := range want {
+		if result.MXRecords[i] != want[i] {
+			t.Errorf("Expected MX records %v, got %v", want, result.MXRecords)
+		}
+	}
+	if result.PreferredHostnames[0] != "primary1" {
+		t.Errorf("Expected preferred hostnames in priority order, got %v", result.PreferredHostnames)
+	}
+}
+
+func TestNullMX(t *testing.T) {
+	tests := []domainTestCase{
+		{domain: "nullmx", expect: DomainNullMX},
+		{domain: "nullmixed", expectedHostnames: []string{"hostname1"}, expect: DomainSuccess},
+	}
+	performTests(t, tests)
+
+	c := Checker{
+		Timeout:             time.Second,
+		Resolver:            mockResolver{},
+		CheckHostname:       mockCheckHostname,
+		checkMTASTSOverride: mockCheckMTASTS,
+	}
+	result := c.CheckDomain("nullmx", nil)
+	if len(result.HostnameResults) != 0 || result.Message == "" {
+		t.Errorf("Expected null MX not to be checked and to be explained, got %v", result)
+	}
+}
+
+func TestImplicitMX(t *testing.T) {
+	c := Checker{
+		Timeout:             time.Second,
+		Resolver:            mockResolver{},
+		CheckHostname:       mockCheckHostname,
+		checkMTASTSOverride: mockCheckMTASTS,
+	}
+	// "implicit" has no MX records, but does have an A record.
+	result := c.CheckDomain("implicit", nil)
+	if result.Status != DomainCouldNotConnect || !strings.Contains(result.Message, "No MX records") {
+		t.Errorf("Expected domain without MXs to fail with a message, got %d %q", result.Status, result.Message)
+	}
+
+	c.ImplicitMX = true
+	result = c.CheckDomain("implicit", nil)
+	if result.Status != DomainSuccess || !strings.Contains(result.Message, "implicit MX") {
+		t.Errorf("Expected implicit MX to be checked, got %d %q", result.Status, result.Message)
+	}
+	if _, ok := result.HostnameResults["implicit"]; !ok {
+		t.Errorf("Expected the domain itself to be checked, got %v", result.HostnameResults)
+	}
+	result = c.CheckDomain("empty", nil)
+	if result.Status != DomainCouldNotConnect || !strings.Contains(result.Message, "A or AAAA") {
+		t.Errorf("Expected domain without MX or A records to fail, got %d %q", result.Status, result.Message)
+	}
+
+	// Real resolvers report a domain without MX records as not found, rather
+	// than returning no records.
+	c.Resolver = notFoundMXResolver{}
+	result = c.CheckDomain("implicit", nil)
+	if result.Status != DomainSuccess || !strings.Contains(result.Message, "implicit MX") {
+		t.Errorf("Expected implicit MX to be checked when MXs aren't found, got %d %q", result.Status, result.Message)
+	}
+	c.ImplicitMX = false
+	result = c.CheckDomain("implicit", nil)
+	if result.Status != DomainCouldNotConnect || !strings.Contains(result.Message, "No MX records") {
+		t.Errorf("Expected domain whose MXs aren't found to fail with a message, got %d %q", result.Status, result.Message)
+	}
+}
+
+// notFoundMXResolver reports that no domain has MX records, like
+// net.Resolver does.
+type notFoundMXResolver struct {
+	mockResolver
+}
+
+func (notFoundMXResolver) LookupMX(_ context.Context, domain string) ([]*net.MX, bool, error) {
+	return nil, false, notFoundError(domain, "")
+}
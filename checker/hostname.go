@@ -1,29 +1,86 @@
 package checker
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"net"
-	"net/smtp"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 // HostnameResult wraps the results of a security check against a particular hostname.
+// The checks of the hostname are aggregated from the checks on each of its addresses.
 type HostnameResult struct {
 	*Result
 	Domain    string    `json:"domain"`
 	Hostname  string    `json:"hostname"`
 	Timestamp time.Time `json:"-"`
+	// Results of the checks on each IPv4 and IPv6 address of the hostname.
+	Addresses []AddressResult `json:"addresses,omitempty"`
+	// The certificate chain presented by the hostname, starting with the leaf.
+	Certificates []CertificateSummary `json:"certificates,omitempty"`
+	// The trust store the certificate was verified against: "system", or
+	// the Checker's TrustStore.
+	TrustStore string `json:"trust_store,omitempty"`
+	// The SCTs and stapled OCSP response presented with the certificate.
+	CertificateStatus *CertificateStatus `json:"certificate_status,omitempty"`
+	// Whether senders enforcing MTA-STS would accept the certificate of
+	// each of the hostname's addresses.
+	PKIX *PKIXValidation `json:"pkix,omitempty"`
+	// The TLS versions and cipher suites accepted by the hostname, if they
+	// were enumerated by the tls-enumeration check.
+	TLSSupport *TLSSupport `json:"tls_support,omitempty"`
+	// What the hostname told us about itself.
+	Capabilities *SMTPCapabilities `json:"capabilities,omitempty"`
 }
 
+// MarshalJSON prevents HostnameResult from inheriting the version of
+// MarshalJSON implemented by Result.
+func (h HostnameResult) MarshalJSON() ([]byte, error) {
+	type FakeResult Result
+	return json.Marshal(struct {
+		FakeResult
+		StatusText        string               `json:"status_text,omitempty"`
+		Addresses         []AddressResult      `json:"addresses,omitempty"`
+		Certificates      []CertificateSummary `json:"certificates,omitempty"`
+		TrustStore        string               `json:"trust_store,omitempty"`
+		CertificateStatus *CertificateStatus   `json:"certificate_status,omitempty"`
+		PKIX              *PKIXValidation      `json:"pkix,omitempty"`
+		TLSSupport        *TLSSupport          `json:"tls_support,omitempty"`
+		Capabilities      *SMTPCapabilities    `json:"capabilities,omitempty"`
+	}{
+		FakeResult:        FakeResult(*h.Result),
+		StatusText:        h.StatusText(),
+		Addresses:         h.Addresses,
+		Certificates:      h.Certificates,
+		TrustStore:        h.TrustStore,
+		CertificateStatus: h.CertificateStatus,
+		PKIX:              h.PKIX,
+		TLSSupport:        h.TLSSupport,
+		Capabilities:      h.Capabilities,
+	})
+}
+
+// couldConnect returns true if we could connect to at least one of the
+// hostname's addresses.
 func (h HostnameResult) couldConnect() bool {
-	return h.subcheckSucceeded(Connectivity)
+	if result, ok := h.Checks[Connectivity]; ok {
+		return result.Status == Success || result.Status == Warning
+	}
+	return false
 }
 
+// couldSTARTTLS returns true if we could negotiate TLS with the hostname,
+// even if the server misbehaved around STARTTLS.
 func (h HostnameResult) couldSTARTTLS() bool {
-	return h.subcheckSucceeded(STARTTLS)
+	if result, ok := h.Checks[STARTTLS]; ok {
+		return result.Status == Success || result.Status == Warning
+	}
+	return false
 }
 
 // PolicyMatches return true iff a given mx matches an array of patterns.
@@ -70,33 +127,79 @@ func getThisHostname() string {
 
 // Performs an SMTP dial with a short timeout.
 // https://github.com/golang/go/issues/16436
-func smtpDialWithTimeout(hostname string, timeout time.Duration) (*smtp.Client, error) {
-	if _, _, err := net.SplitHostPort(hostname); err != nil {
-		hostname += ":25"
-	}
-	conn, err := net.DialTimeout("tcp", hostname, timeout)
-	if err != nil {
-		return nil, err
-	}
-	client, err := smtp.NewClient(conn, hostname)
-	if err != nil {
-		return client, err
+func smtpDialWithTimeout(hostname string, timeout time.Duration) (*smtpClient, error) {
+	c := Checker{Timeout: timeout}
+	return c.smtpDialContext(context.Background(), hostname)
+}
+
+// contextConn is a connection that is closed as soon as its context is done,
+// which interrupts any pending reads or writes.
+type contextConn struct {
+	net.Conn
+	stop chan struct{}
+	once sync.Once
+}
+
+func newContextConn(ctx context.Context, conn net.Conn) net.Conn {
+	if ctx.Done() == nil {
+		// This context can never be cancelled.
+		return conn
 	}
-	return client, client.Hello(getThisHostname())
+	c := &contextConn{Conn: conn, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-c.stop:
+		}
+	}()
+	return c
 }
 
-// Simply tries to StartTLS with the server.
-func checkStartTLS(client *smtp.Client) *Result {
+// Close closes the connection and stops watching its context.
+func (c *contextConn) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return c.Conn.Close()
+}
+
+// smtpDialContext performs an SMTP dial with a short timeout. The connection
+// is closed when ctx is done.
+func (c *Checker) smtpDialContext(ctx context.Context, hostname string) (*smtpClient, error) {
+	client, _, err := c.smtpDialRecording(ctx, hostname)
+	return client, err
+}
+
+// Tries to StartTLS with the server, and checks that it advertises STARTTLS
+// and accepts EHLO before and after the handshake as senders expect. Returns
+// the extensions advertised in response to the EHLO that StartTLS sends
+// after the handshake.
+func checkStartTLS(client *smtpClient) (*Result, []string) {
 	result := MakeResult(STARTTLS)
 	ok, _ := client.Extension("StartTLS")
 	if !ok {
-		return result.Failure("Server does not advertise support for STARTTLS.")
+		// Some servers only advertise STARTTLS in response to a second EHLO.
+		extensions, err := ehlo(client.Text)
+		if err != nil || !hasExtension(extensions, "STARTTLS") {
+			return result.Code("starttls.not_advertised").Failure("Server does not advertise support for STARTTLS."), nil
+		}
+		result.Code("starttls.advertised_late").Warning("Server only advertised STARTTLS after we sent EHLO a second time. Senders don't retry EHLO, so they would deliver mail without TLS.")
 	}
-	config := tls.Config{InsecureSkipVerify: true}
+	// Since Go 1.18, clients only offer TLS 1.2 and up by default. Offer TLS
+	// 1.0 and 1.1 too, so that servers which only accept those, like a
+	// checkertest.MTA configured that way, get the version check's warning
+	// rather than failing STARTTLS.
+	config := tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
 	if err := client.StartTLS(&config); err != nil {
-		return result.Failure("Could not complete a TLS handshake.")
+		if state, ok := client.TLSConnectionState(); ok && state.HandshakeComplete {
+			return result.Code("starttls.ehlo_refused_after_handshake").Failure("Server completed the TLS handshake, but didn't accept EHLO afterwards: %v", err), nil
+		}
+		return result.Code("starttls.handshake_failed").Failure("Could not complete a TLS handshake."), nil
 	}
-	return result.Success()
+	extensions := client.extensions
+	if len(extensions) == 0 {
+		result.Code("starttls.no_extensions").Warning("Server didn't advertise any SMTP extensions after STARTTLS, so senders can't use extensions like SIZE or 8BITMIME over TLS.")
+	}
+	return result.Success(), extensions
 }
 
 // If no MX matching policy was provided, then we'll default to accepting matches
@@ -110,44 +213,76 @@ func defaultValidMX(domain, hostname string) []string {
 	return []string{domain, hostname}
 }
 
-// Validates that a certificate chain is valid for this system roots.
-func verifyCertChain(state tls.ConnectionState) error {
+// Validates that a certificate chain is valid for roots, or for the system
+// roots if roots is nil, at time now.
+func verifyCertChain(state tls.ConnectionState, roots *x509.CertPool, now time.Time) error {
 	pool := x509.NewCertPool()
 	for _, peerCert := range state.PeerCertificates[1:] {
 		pool.AddCert(peerCert)
 	}
 	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
-		Roots:         certRoots,
+		Roots:         roots,
 		Intermediates: pool,
+		CurrentTime:   now,
 	})
 	return err
 }
 
-// certRoots is the certificate roots to use for verifying
-// a TLS certificate. It is nil by default so that the system
-// root certs are used.
-//
-// It is a global variable because it is used as a test hook.
-var certRoots *x509.CertPool
+// validatePKIX checks that the certificate presented over a TLS connection is
+// valid for hostname and chains to one of the Checker's roots, whether or not
+// it was authenticated with DANE.
+func (c *Checker) validatePKIX(state tls.ConnectionState, hostname string) *PKIXValidation {
+	validation := &PKIXValidation{}
+	if len(state.PeerCertificates) == 0 {
+		validation.ChainError = "no certificate was presented"
+		return validation
+	}
+	hostname = withoutPort(strings.TrimSuffix(hostname, "."))
+	if err := state.PeerCertificates[0].VerifyHostname(hostname); err != nil {
+		validation.HostnameError = err.Error()
+	}
+	if err := verifyCertChain(state, c.RootCAs, c.now()); err != nil {
+		validation.ChainError = err.Error()
+	}
+	return validation
+}
 
 // Checks that the certificate presented is valid for a particular hostname, unexpired,
-// and chains to a trusted root.
-func checkCert(client *smtp.Client, domain, hostname string) *Result {
+// and chains to one of the Checker's roots.
+// If the certificate was already authenticated via DANE, it doesn't need to
+// chain to a trusted root or match the hostname.
+func (c *Checker) checkCert(client *smtpClient, domain, hostname string, daneAuthenticated bool) *Result {
 	result := MakeResult(Certificate)
 	state, ok := client.TLSConnectionState()
 	if !ok {
-		return result.Error("TLS not initiated properly.")
+		return result.Code("tls.not_initiated").Error("TLS not initiated properly.")
+	}
+	return c.validateCert(state, hostname, daneAuthenticated, result)
+}
+
+// validateCert checks the certificate presented over a TLS connection to
+// hostname, as described for checkCert.
+func (c *Checker) validateCert(state tls.ConnectionState, hostname string, daneAuthenticated bool, result *Result) *Result {
+	validateCertStrength(state.PeerCertificates, result)
+	if daneAuthenticated {
+		// DANE-authenticated certificates don't need to be valid for PKIX,
+		// and their expiry dates are ignored.
+		return result.Success()
 	}
 	cert := state.PeerCertificates[0]
+	validateCertExpiry(cert, c.certExpiryWindow(), c.now(), result)
+	// Certificates issued by a custom trust store's CAs aren't expected to be
+	// logged to Certificate Transparency.
+	validateCertificateStatus(summarizeCertificateStatus(state), c.now(), c.RootCAs == nil, result)
 	// If hostname is an FQDN, it might end with '.'
 	hostname = strings.TrimSuffix(hostname, ".")
 	err := cert.VerifyHostname(withoutPort(hostname))
 	if err != nil {
-		result.Failure("Name in cert doesn't match hostname: %v", err)
+		result.Code("cert.hostname_mismatch").Failure("Name in cert doesn't match hostname: %v", err)
 	}
-	err = verifyCertChain(state)
+	err = verifyCertChain(state, c.RootCAs, c.now())
 	if err != nil {
-		return result.Failure("Certificate root is not trusted: %v", err)
+		return result.Code("cert.untrusted").Failure("Certificate root is not trusted by the %s trust store: %v", c.trustStore(), err)
 	}
 	return result.Success()
 }
@@ -168,34 +303,34 @@ func checkTLSCipher(hostname string, timeout time.Duration) *Result {
 		tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA}
 	client, err := smtpDialWithTimeout(hostname, timeout)
 	if err != nil {
-		return result.Error("Could not establish connection with hostname %s", hostname)
+		return result.Code("tls.cipher_probe_failed").Error("Could not establish connection with hostname %s", hostname)
 	}
 	defer client.Close()
 	config := tlsConfigForCipher(badCiphers)
 	err = client.StartTLS(&config)
 	if err == nil {
-		return result.Failure("Server should NOT be able to negotiate any ciphers with RC4.")
+		return result.Code("tls.rc4_enabled").Failure("Server should NOT be able to negotiate any ciphers with RC4.")
 	}
 	return result.Success()
 }
 
-func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration) *Result {
+func (c *Checker) checkTLSVersion(ctx context.Context, client *smtpClient, hostname string) *Result {
 	result := MakeResult(Version)
 
 	// Check the TLS version of the existing connection.
 	tlsConnectionState, ok := client.TLSConnectionState()
 	if !ok {
 		// We shouldn't end up here because we already checked that STARTTLS succeeded.
-		return result.Error("Could not check TLS connection version.")
+		return result.Code("tls.version_unknown").Error("Could not check TLS connection version.")
 	}
 	if tlsConnectionState.Version < tls.VersionTLS12 {
-		result = result.Warning("Server should support TLSv1.2, but doesn't.")
+		result = result.Code("tls.version_outdated").Warning("Server should support TLSv1.2, but doesn't.")
 	}
 
 	// Attempt to connect with an old SSL version.
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	client, err := c.smtpDialContext(ctx, hostname)
 	if err != nil {
-		return result.Error("Could not establish connection: %v", err)
+		return result.Code("smtp.connection_failed").Error("Could not establish connection: %v", err)
 	}
 	defer client.Close()
 	config := tls.Config{
@@ -205,31 +340,64 @@ func checkTLSVersion(client *smtp.Client, hostname string, timeout time.Duration
 	}
 	err = client.StartTLS(&config)
 	if err == nil {
-		return result.Failure("Server should NOT support SSLv2/3, but does.")
+		return result.Code("tls.sslv3_enabled").Failure("Server should NOT support SSLv2/3, but does.")
 	}
 	return result.Success()
 }
 
 // checkHostname returns the result of c.CheckHostname or FullCheckHostname,
 // using or updating the Checker's cache.
-func (c *Checker) checkHostname(domain string, hostname string) HostnameResult {
+// If ctx is done before the check completes, a cancelled result is returned
+// and the cache isn't updated.
+func (c *Checker) checkHostname(ctx context.Context, domain string, hostname string) HostnameResult {
+	if ctx.Err() != nil {
+		return cancelledHostnameResult(domain, hostname, ctx.Err())
+	}
 	check := c.CheckHostname
 	if check == nil {
 		// If CheckHostname hasn't been set, default to the full set of checks.
-		check = FullCheckHostname
+		check = func(domain string, hostname string, _ time.Duration) HostnameResult {
+			return c.fullCheckHostname(ctx, domain, hostname)
+		}
 	}
 
-	if c.Cache == nil {
-		return check(domain, hostname, c.timeout())
+	if c.Cache != nil {
+		if hostnameResult, err := c.Cache.GetHostnameScan(hostname); err == nil {
+			return hostnameResult
+		}
 	}
-	hostnameResult, err := c.Cache.GetHostnameScan(hostname)
-	if err != nil {
-		hostnameResult = check(domain, hostname, c.timeout())
+	// CheckHostname may not honour ctx, so stop waiting for it once ctx is done.
+	done := make(chan HostnameResult, 1)
+	go func() {
+		done <- check(domain, hostname, c.timeout())
+	}()
+	var hostnameResult HostnameResult
+	select {
+	case hostnameResult = <-done:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		return cancelledHostnameResult(domain, hostname, ctx.Err())
+	}
+	if c.Cache != nil {
 		c.Cache.PutHostnameScan(hostname, hostnameResult)
 	}
 	return hostnameResult
 }
 
+// cancelledHostnameResult returns the result of a hostname check that was
+// cancelled before it completed.
+func cancelledHostnameResult(domain string, hostname string, err error) HostnameResult {
+	r := HostnameResult{
+		Domain:    domain,
+		Hostname:  hostname,
+		Result:    MakeResult("hostnames"),
+		Timestamp: time.Now(),
+	}
+	r.addCheck(MakeResult(Connectivity).Code("check.cancelled").Error("Check was cancelled before it completed: %v", err))
+	return r
+}
+
 // NoopCheckHostname returns a fake error result containing `domain` and `hostname`.
 func NoopCheckHostname(domain string, hostname string, _ time.Duration) HostnameResult {
 	r := HostnameResult{
@@ -237,7 +405,7 @@ func NoopCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 		Hostname: hostname,
 		Result:   MakeResult("hostnames"),
 	}
-	r.addCheck(MakeResult(Connectivity).Error("Skipping hostname checks"))
+	r.addCheck(MakeResult(Connectivity).Code("check.skipped").Error("Skipping hostname checks"))
 	return r
 }
 
@@ -245,32 +413,40 @@ func NoopCheckHostname(domain string, hostname string, _ time.Duration) Hostname
 // `domain` is the mail domain that this server serves email for.
 // `hostname` is the hostname for this server.
 func FullCheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
+	return Checker{}.FullCheckHostname(domain, hostname, timeout)
+}
+
+// FullCheckHostname is like the FullCheckHostname function, but uses c's
+// other settings, such as its Resolver and RootCAs.
+func (c Checker) FullCheckHostname(domain string, hostname string, timeout time.Duration) HostnameResult {
+	c.Timeout = timeout
+	return c.fullCheckHostname(context.Background(), domain, hostname)
+}
+
+// fullCheckHostname performs FullCheckHostname using the Checker's settings.
+// Each of the hostname's addresses is checked separately.
+func (c *Checker) fullCheckHostname(ctx context.Context, domain string, hostname string) HostnameResult {
 	result := HostnameResult{
-		Domain:    domain,
-		Hostname:  hostname,
-		Result:    MakeResult("hostnames"),
-		Timestamp: time.Now(),
+		Domain:     domain,
+		Hostname:   hostname,
+		Result:     MakeResult("hostnames"),
+		Timestamp:  time.Now(),
+		TrustStore: c.trustStore(),
 	}
 
-	// Connect to the SMTP server and use that connection to perform as many checks as possible.
-	connectivityResult := MakeResult(Connectivity)
-	client, err := smtpDialWithTimeout(hostname, timeout)
+	addresses, err := c.lookupAddresses(ctx, hostname)
 	if err != nil {
-		result.addCheck(connectivityResult.Error("Could not establish connection: %v", err))
+		result.addCheck(MakeResult(Connectivity).Code("smtp.address_lookup_failed").Error("Could not look up addresses: %v", err))
 		return result
 	}
-	defer client.Close()
-	result.addCheck(connectivityResult.Success())
-
-	result.addCheck(checkStartTLS(client))
-	if result.Status != Success {
-		return result
+	var tlsa []TLSARecord
+	var tlsaSecure bool
+	if c.selectedChecks()[DANE] {
+		tlsa, tlsaSecure = c.lookupTLSA(ctx, hostname)
 	}
-	result.addCheck(checkCert(client, domain, hostname))
-	// result.addCheck(checkTLSCipher(hostname))
-
-	// Creates a new connection to check for SSLv2/3 support because we can't call starttls twice.
-	result.addCheck(checkTLSVersion(client, hostname, timeout))
-
+	for _, address := range addresses {
+		result.Addresses = append(result.Addresses, c.checkAddress(ctx, domain, hostname, address, tlsa, tlsaSecure))
+	}
+	result.aggregateAddresses()
 	return result
 }
@@ -0,0 +1,358 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MessageDetail is the machine-readable form of a message added to a
+// Result: Details[i] describes Messages[i]. Unlike the text of messages,
+// codes and parameter names are stable, so they can be used for stats,
+// alerting rules and translations.
+type MessageDetail struct {
+	// Code identifies the kind of message, e.g. "cert.hostname_mismatch".
+	// Messages that weren't given a code use "<check>.<level>".
+	Code string `json:"code"`
+	// Level is "error", "failure", "warning" or "info".
+	Level string `json:"level"`
+	// Params are the values formatted into the message, keyed by the names
+	// listed for its code.
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// MarshalJSON writes MessageDetail to JSON. It adds the remediation and
+// link of its code to the output.
+func (d MessageDetail) MarshalJSON() ([]byte, error) {
+	type FakeMessageDetail MessageDetail
+	code := messageCodes[d.Code]
+	return json.Marshal(struct {
+		FakeMessageDetail
+		Remediation string `json:"remediation,omitempty"`
+		Link        string `json:"link,omitempty"`
+	}{
+		FakeMessageDetail: FakeMessageDetail(d),
+		Remediation:       code.Remediation,
+		Link:              code.Link,
+	})
+}
+
+// MessageCode describes a kind of message that checks report.
+type MessageCode struct {
+	// Params names the values formatted into the message, in order.
+	Params []string `json:"params,omitempty"`
+	// Remediation explains how to fix the problem the message reports.
+	Remediation string `json:"remediation,omitempty"`
+	// Link points to more information about the problem.
+	Link string `json:"link,omitempty"`
+}
+
+// MessageCodes returns every message code, e.g. to translate messages or
+// document alerting rules.
+func MessageCodes() map[string]MessageCode {
+	codes := make(map[string]MessageCode, len(messageCodes))
+	for name, code := range messageCodes {
+		codes[name] = code
+	}
+	return codes
+}
+
+// messageParams names the values formatted into a message with code.
+func messageParams(code string, a []interface{}) map[string]interface{} {
+	names := messageCodes[code].Params
+	if len(names) == 0 || len(a) == 0 {
+		return nil
+	}
+	params := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		if i >= len(a) {
+			break
+		}
+		switch value := a[i].(type) {
+		case time.Time:
+			params[name] = value
+		case error:
+			params[name] = value.Error()
+		case fmt.Stringer:
+			params[name] = value.String()
+		default:
+			params[name] = value
+		}
+	}
+	return params
+}
+
+const (
+	rfc3207 = "https://tools.ietf.org/html/rfc3207"
+	rfc6960 = "https://tools.ietf.org/html/rfc6960"
+	rfc6962 = "https://tools.ietf.org/html/rfc6962"
+	rfc7672 = "https://tools.ietf.org/html/rfc7672"
+	rfc8314 = "https://tools.ietf.org/html/rfc8314"
+	rfc8460 = "https://tools.ietf.org/html/rfc8460"
+	rfc8461 = "https://tools.ietf.org/html/rfc8461"
+	rfc8689 = "https://tools.ietf.org/html/rfc8689"
+	rfc8996 = "https://tools.ietf.org/html/rfc8996"
+)
+
+// messageCodes lists the codes of the messages that checks report.
+var messageCodes = map[string]MessageCode{
+	// Checks that couldn't run.
+	"check.cancelled": {Params: []string{"error"},
+		Remediation: "The scan took too long, so some checks didn't complete. Try again later."},
+	"check.skipped": {},
+
+	// Connecting to mailservers.
+	"smtp.address_lookup_failed": {Params: []string{"error"},
+		Remediation: "Publish A or AAAA records for your mailserver's hostname."},
+	"smtp.connection_failed": {Params: []string{"error"},
+		Remediation: "Make sure your mailserver accepts connections from the internet on port 25, or on the port published for it."},
+	"smtp.address_unreachable": {Params: []string{"address"},
+		Remediation: "Make sure every address published for your mailserver accepts connections, or stop publishing the ones that don't."},
+	"smtp.no_greeting": {Params: []string{"error"},
+		Remediation: "Make sure your mailserver greets clients with a 220 reply once they connect."},
+	"smtp.ehlo_refused": {Params: []string{"error"},
+		Remediation: "Make sure your mailserver accepts EHLO."},
+
+	// STARTTLS.
+	"starttls.not_advertised": {
+		Remediation: "Enable STARTTLS on your mailserver, with a certificate for its hostname.",
+		Link:        rfc3207},
+	"starttls.advertised_late": {
+		Remediation: "Advertise STARTTLS in response to the first EHLO.",
+		Link:        rfc3207},
+	"starttls.handshake_failed": {
+		Remediation: "Check your mailserver's TLS configuration: its certificate, private key, and the TLS versions and cipher suites it accepts.",
+		Link:        rfc3207},
+	"starttls.ehlo_refused_after_handshake": {Params: []string{"error"},
+		Remediation: "Make sure your mailserver accepts EHLO once TLS has been negotiated.",
+		Link:        rfc3207 + "#section-4.2"},
+	"starttls.no_extensions": {
+		Remediation: "Advertise the same extensions after STARTTLS as before it.",
+		Link:        rfc3207 + "#section-4.2"},
+	"starttls.send_failed": {Params: []string{"error"}},
+	"starttls_injection.answered_before_tls": {
+		Remediation: "Make sure your mailserver discards commands that were sent along with STARTTLS.",
+		Link:        "https://nvd.nist.gov/vuln/detail/CVE-2011-0411"},
+	"starttls_injection.vulnerable": {Params: []string{"reply"},
+		Remediation: "Upgrade your mailserver, or configure it to discard commands that were sent along with STARTTLS.",
+		Link:        "https://nvd.nist.gov/vuln/detail/CVE-2011-0411"},
+
+	// TLS versions and cipher suites.
+	"tls.not_initiated": {},
+	"tls.handshake_failed": {Params: []string{"error"},
+		Remediation: "Check your mailserver's TLS configuration: its certificate, private key, and the TLS versions and cipher suites it accepts."},
+	"tls.implicit_handshake_failed": {Params: []string{"error"},
+		Remediation: "Make sure the server negotiates TLS as soon as clients connect, or publish it as a STARTTLS endpoint instead.",
+		Link:        rfc8314},
+	"tls.version_unknown": {},
+	"tls.version_outdated": {
+		Remediation: "Enable TLS 1.2 and TLS 1.3.",
+		Link:        rfc8996},
+	"tls.deprecated_version": {Params: []string{"version"},
+		Remediation: "Disable TLS 1.0 and TLS 1.1, and make sure TLS 1.2 and TLS 1.3 are enabled.",
+		Link:        rfc8996},
+	"tls.sslv3_enabled": {
+		Remediation: "Disable SSLv2 and SSLv3.",
+		Link:        "https://tools.ietf.org/html/rfc7568"},
+	"tls.export_cipher": {Params: []string{"cipher_suite", "version"},
+		Remediation: "Disable export-grade cipher suites."},
+	"tls.null_cipher": {Params: []string{"cipher_suite", "version"},
+		Remediation: "Disable cipher suites that don't encrypt."},
+	"tls.rc4_cipher": {Params: []string{"cipher_suite", "version"},
+		Remediation: "Disable RC4 cipher suites.",
+		Link:        "https://tools.ietf.org/html/rfc7465"},
+	"tls.rc4_enabled": {
+		Remediation: "Disable RC4 cipher suites.",
+		Link:        "https://tools.ietf.org/html/rfc7465"},
+	"tls.des_cipher": {Params: []string{"cipher_suite", "version"},
+		Remediation: "Disable DES and 3DES cipher suites."},
+	"tls.cbc_only": {
+		Remediation: "Enable AES-GCM or ChaCha20-Poly1305 cipher suites."},
+	"tls.no_handshake_accepted": {
+		Remediation: "Enable TLS 1.2 and TLS 1.3, with AES-GCM or ChaCha20-Poly1305 cipher suites."},
+	"tls.enumeration_error":   {Params: []string{"error"}},
+	"tls.cipher_probe_failed": {Params: []string{"hostname"}},
+
+	// Certificates.
+	"cert.hostname_mismatch": {Params: []string{"error"},
+		Remediation: "Get a certificate that's valid for your mailserver's hostname, which is the name in your MX record."},
+	"cert.untrusted": {Params: []string{"trust_store", "error"},
+		Remediation: "Get a certificate from a publicly trusted certificate authority, like Let's Encrypt, and make sure your mailserver presents its intermediate certificates too. Also make sure it hasn't expired."},
+	"cert.expiring_soon": {Params: []string{"days", "expiry"},
+		Remediation: "Renew your certificate, or automate its renewal."},
+	"cert.weak_key": {Params: []string{"certificate", "bits", "algorithm", "min_bits"},
+		Remediation: "Get a certificate with an RSA key of at least 2048 bits, or an ECDSA key."},
+	"cert.weak_signature": {Params: []string{"certificate", "algorithm"},
+		Remediation: "Get a certificate signed with SHA-256 or better."},
+	"cert.no_scts": {
+		Remediation: "Get a certificate from a certificate authority that logs its certificates to Certificate Transparency.",
+		Link:        rfc6962},
+	"cert.ocsp_invalid": {Params: []string{"error"},
+		Remediation: "Make sure your mailserver staples OCSP responses from your certificate's issuer, or disable stapling.",
+		Link:        rfc6960},
+	"cert.ocsp_revoked": {
+		Remediation: "Your certificate has been revoked. Replace it.",
+		Link:        rfc6960},
+	"cert.ocsp_unknown": {
+		Remediation: "Make sure your mailserver staples OCSP responses for the certificate it presents.",
+		Link:        rfc6960},
+	"cert.ocsp_stale": {Params: []string{"next_update"},
+		Remediation: "Make sure your mailserver refreshes the OCSP responses it staples.",
+		Link:        rfc6960},
+	"cert.ocsp_not_yet_valid": {Params: []string{"this_update"},
+		Remediation: "Make sure your mailserver's clock is correct.",
+		Link:        rfc6960},
+
+	// DANE.
+	"dane.unsigned": {Params: []string{"name"},
+		Remediation: "Sign your zone with DNSSEC, or stop publishing TLSA records.",
+		Link:        rfc7672 + "#section-2.2"},
+	"dane.unusable_records": {Params: []string{"count", "name"},
+		Remediation: "Publish DANE-TA(2) or DANE-EE(3) TLSA records.",
+		Link:        rfc7672 + "#section-3.1"},
+	"dane.mismatch": {Params: []string{"name", "error"},
+		Remediation: "Update your TLSA records to match your certificate, publishing records for the next certificate before you deploy it.",
+		Link:        rfc7672 + "#section-3.1"},
+	"dane.not_published": {Params: []string{"hostname"},
+		Remediation: "Sign your zone with DNSSEC and publish TLSA records for each of your mailservers.",
+		Link:        rfc7672},
+	"dane.unusable": {Params: []string{"hostname"},
+		Remediation: "Fix the TLSA records published for this mailserver.",
+		Link:        rfc7672},
+
+	// MTA-STS.
+	"mta_sts.record_missing": {Params: []string{"error"},
+		Remediation: "Publish a TXT record at _mta-sts.<your domain>, like \"v=STSv1; id=20190101\".",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.record_count": {Params: []string{"count"},
+		Remediation: "Publish exactly one TXT record starting with \"v=STSv1\" at _mta-sts.<your domain>.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.record_invalid_field": {Params: []string{"field"},
+		Remediation: "Separate the fields of your MTA-STS TXT record with semicolons, like \"v=STSv1; id=20190101\".",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.record_duplicate_field": {Params: []string{"field"},
+		Remediation: "Remove the duplicate field from your MTA-STS TXT record.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.record_unknown_field": {Params: []string{"field"},
+		Remediation: "Remove the unknown field from your MTA-STS TXT record.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.record_invalid_id": {Params: []string{"id"},
+		Remediation: "Use an id of 1 to 32 letters and digits, like the date you last changed your policy.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.policy_unavailable": {Params: []string{"domain"},
+		Remediation: "Serve your policy at https://mta-sts.<your domain>/.well-known/mta-sts.txt.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_invalid": {Params: []string{"problem"},
+		Remediation: "Fix your MTA-STS policy file.",
+		Link:        rfc8461 + "#section-3.2"},
+	"mta_sts.policy_warning": {Params: []string{"problem"},
+		Link: rfc8461 + "#section-3.2"},
+	"mta_sts.mode_testing": {
+		Remediation: "Once you've confirmed that senders can deliver mail to you over TLS, for instance with TLS-RPT reports, switch your policy's mode to \"enforce\".",
+		Link:        rfc8461 + "#section-5"},
+	"mta_sts.mode_none": {
+		Remediation: "Switch your policy's mode to \"testing\" or \"enforce\", unless you're deliberately removing your policy.",
+		Link:        rfc8461 + "#section-8.3"},
+	"mta_sts.mx_not_in_policy": {Params: []string{"mx"},
+		Remediation: "Add the MX to your policy's mx fields, or remove it from your MX records.",
+		Link:        rfc8461 + "#section-4.1"},
+	"mta_sts.mx_no_starttls": {Params: []string{"mx"},
+		Remediation: "Enable STARTTLS on the MX, or remove it from your MX records and policy.",
+		Link:        rfc8461 + "#section-4.2"},
+	"mta_sts.policy_host_dns": {Params: []string{"host", "error"},
+		Remediation: "Publish A or AAAA records for mta-sts.<your domain>.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_request_error": {Params: []string{"url", "error"}},
+	"mta_sts.policy_host_https": {Params: []string{"host", "error"},
+		Remediation: "Serve HTTPS on port 443 of mta-sts.<your domain>.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_host_slow": {Params: []string{"host", "seconds"},
+		Remediation: "Serve your policy file faster, for instance from a CDN.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_redirect": {Params: []string{"url", "location"},
+		Remediation: "Serve your policy file directly from https://mta-sts.<your domain>/.well-known/mta-sts.txt.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_http_status": {Params: []string{"url", "status"},
+		Remediation: "Serve your policy file with HTTP status 200 OK.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_content_type": {Params: []string{"content_type"},
+		Remediation: "Serve your policy file with \"Content-Type: text/plain\".",
+		Link:        rfc8461 + "#section-3.2"},
+	"mta_sts.policy_read_error": {Params: []string{"error"}},
+	"mta_sts.policy_host_no_certificate": {
+		Remediation: "Serve your policy over HTTPS with a certificate for mta-sts.<your domain>.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_host_cert_hostname_mismatch": {Params: []string{"host", "error"},
+		Remediation: "Get a certificate for mta-sts.<your domain> for your policy host.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.policy_host_cert_untrusted": {Params: []string{"trust_store", "error"},
+		Remediation: "Get a certificate for your policy host from a publicly trusted certificate authority, and make sure it hasn't expired.",
+		Link:        rfc8461 + "#section-3.3"},
+	"mta_sts.id_unchanged": {Params: []string{"last_seen", "id"},
+		Remediation: "Change the id in your _mta-sts TXT record whenever you change your policy.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.id_changed_policy_unchanged": {Params: []string{"old_id", "new_id"},
+		Remediation: "Only change the id in your _mta-sts TXT record along with your policy.",
+		Link:        rfc8461 + "#section-3.1"},
+	"mta_sts.snapshot_unavailable": {Params: []string{"error"}},
+	"mta_sts.snapshot_not_saved":   {Params: []string{"error"}},
+
+	// TLS-RPT.
+	"tls_rpt.missing": {Params: []string{"domain"},
+		Remediation: "Publish a TXT record at _smtp._tls.<your domain>, like \"v=TLSRPTv1; rua=mailto:tlsrpt@<your domain>\".",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.missing_in_testing_mode": {
+		Remediation: "Publish a TLS-RPT record, so that you learn which senders would fail to deliver mail to you before you switch to \"enforce\".",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.record_count": {Params: []string{"count"},
+		Remediation: "Publish exactly one TXT record starting with \"v=TLSRPTv1\" at _smtp._tls.<your domain>.",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.invalid_version": {Params: []string{"version"},
+		Remediation: "Start your TLS-RPT record with \"v=TLSRPTv1\".",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.missing_rua": {
+		Remediation: "Add a rua field to your TLS-RPT record, like \"rua=mailto:tlsrpt@<your domain>\".",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.invalid_uri": {Params: []string{"uri", "error"},
+		Remediation: "Use mailto: or https: URIs in your TLS-RPT record's rua field.",
+		Link:        rfc8460 + "#section-3"},
+	"tls_rpt.no_valid_uris": {
+		Remediation: "Use mailto: or https: URIs in your TLS-RPT record's rua field.",
+		Link:        rfc8460 + "#section-3"},
+
+	// Backup MXs.
+	"mx.backup_no_starttls": {Params: []string{"hostname"},
+		Remediation: "Enable STARTTLS on your backup MX, or remove it from your MX records."},
+	"mx.backup_failing": {Params: []string{"hostname"},
+		Remediation: "Secure your backup MX like your primary MXs, or remove it from your MX records."},
+	"mx.backup_warnings": {Params: []string{"hostname"},
+		Remediation: "Secure your backup MX like your primary MXs."},
+
+	// REQUIRETLS.
+	"requiretls.all":  {Params: []string{"domain"}, Link: rfc8689},
+	"requiretls.none": {Params: []string{"domain"}, Link: rfc8689},
+	"requiretls.some": {Params: []string{"domain", "supported", "unsupported"},
+		Remediation: "Enable REQUIRETLS on all of your mailservers, or none of them.",
+		Link:        rfc8689},
+
+	// Mail submission.
+	"submission.service_not_offered": {Params: []string{"domain", "service"},
+		Link: "https://tools.ietf.org/html/rfc6186#section-3.4"},
+	"submission.no_srv": {Params: []string{"domain"},
+		Remediation: "Publish _submission._tcp and _submissions._tcp SRV records for your submission servers.",
+		Link:        "https://tools.ietf.org/html/rfc6186"},
+	"submission.guessed_endpoints": {Params: []string{"hostname"},
+		Link: "https://tools.ietf.org/html/rfc6186"},
+	"submission.no_implicit_tls": {Params: []string{"domain"},
+		Remediation: "Offer submission over implicit TLS on port 465, and publish it in a _submissions._tcp SRV record.",
+		Link:        rfc8314 + "#section-3.3"},
+	"submission.smtp_over_tls_refused": {Params: []string{"error"},
+		Remediation: "Make sure the server speaks SMTP once TLS has been negotiated.",
+		Link:        rfc8314},
+
+	// The STARTTLS Everywhere policy list.
+	"policylist.not_listed": {Params: []string{"domain"}},
+	"policylist.queued":     {Params: []string{"domain"}},
+	"policylist.awaiting_validation": {Params: []string{"domain"},
+		Remediation: "Follow the link in the validation email we sent to your domain's contact address."},
+}
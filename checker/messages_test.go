@@ -0,0 +1,130 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMessagesHaveCodes checks that every message the checks add has a
+// registered code, with a param for each of its arguments.
+func TestMessagesHaveCodes(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := map[string]bool{"Error": true, "Failure": true, "Warning": true, "Info": true}
+	for _, file := range pkgs["checker"].Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !levels[sel.Sel.Name] || len(call.Args) == 0 {
+				return true
+			}
+			if _, ok := call.Args[0].(*ast.BasicLit); !ok {
+				// Not a message, e.g. log.Error(err).
+				return true
+			}
+			position := fset.Position(call.Pos())
+			coded, ok := sel.X.(*ast.CallExpr)
+			if !ok || len(coded.Args) != 1 {
+				t.Errorf("%s: expected message to have a code", position)
+				return true
+			}
+			if fun, ok := coded.Fun.(*ast.SelectorExpr); !ok || fun.Sel.Name != "Code" {
+				t.Errorf("%s: expected message to have a code", position)
+				return true
+			}
+			lit, ok := coded.Args[0].(*ast.BasicLit)
+			if !ok {
+				t.Errorf("%s: expected code to be a string literal", position)
+				return true
+			}
+			name, _ := strconv.Unquote(lit.Value)
+			code, ok := messageCodes[name]
+			if !ok {
+				t.Errorf("%s: code %s isn't registered", position, name)
+			} else if len(code.Params) != len(call.Args)-1 {
+				t.Errorf("%s: expected %d params for %s, got %d arguments", position, len(code.Params), name, len(call.Args)-1)
+			}
+			return true
+		})
+	}
+}
+
+func TestMessageDetails(t *testing.T) {
+	result := MakeResult(Certificate)
+	result.Code("cert.hostname_mismatch").Failure("Name in cert doesn't match hostname: %v", errors.New("x509: bad name"))
+	result.Warning("Something %s happened.", "odd")
+	if len(result.Details) != len(result.Messages) {
+		t.Fatalf("expected a detail for each message, got %v", result.Details)
+	}
+	want := []MessageDetail{
+		{Code: "cert.hostname_mismatch", Level: "failure", Params: map[string]interface{}{"error": "x509: bad name"}},
+		{Code: "certificate.warning", Level: "warning"},
+	}
+	if !reflect.DeepEqual(result.Details, want) {
+		t.Errorf("expected details %v, got %v", want, result.Details)
+	}
+	if result.Messages[0] != "Failure: Name in cert doesn't match hostname: x509: bad name" {
+		t.Errorf("expected the human message to be kept, got %q", result.Messages[0])
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Details []map[string]interface{} `json:"details"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	detail := got.Details[0]
+	if detail["code"] != "cert.hostname_mismatch" || detail["remediation"] == nil {
+		t.Errorf("expected code and remediation in JSON, got %s", b)
+	}
+	if params, ok := detail["params"].(map[string]interface{}); !ok || params["error"] != "x509: bad name" {
+		t.Errorf("expected params in JSON, got %s", b)
+	}
+	if _, ok := got.Details[1]["remediation"]; ok {
+		t.Errorf("expected no remediation for uncoded messages, got %s", b)
+	}
+}
+
+func TestMergedMessageDetails(t *testing.T) {
+	unreachable := func(address string) AddressResult {
+		return addressResult(address, MakeResult(Connectivity).Code("smtp.connection_failed").Error("Could not establish connection: %v", "timeout"))
+	}
+	failing := addressResult("192.0.2.2", MakeResult(Connectivity),
+		MakeResult(STARTTLS).Code("starttls.not_advertised").Failure("Server does not advertise support for STARTTLS."))
+	tests := []struct {
+		addresses []AddressResult
+		name      string
+		want      MessageDetail
+	}{
+		{[]AddressResult{unreachable("192.0.2.1"), unreachable("192.0.2.2")}, Connectivity,
+			MessageDetail{Code: "smtp.connection_failed", Level: "error", Params: map[string]interface{}{"error": "timeout"}}},
+		{[]AddressResult{addressResult("192.0.2.1", MakeResult(Connectivity), MakeResult(STARTTLS)), failing}, STARTTLS,
+			MessageDetail{Code: "starttls.not_advertised", Level: "failure", Params: map[string]interface{}{"address": "192.0.2.2"}}},
+	}
+	for _, test := range tests {
+		merged := mergeChecks(test.name, test.addresses)
+		if len(merged.Details) != 1 || !reflect.DeepEqual(merged.Details[0], test.want) {
+			t.Errorf("expected merged details [%v], got %v", test.want, merged.Details)
+		}
+	}
+}
@@ -4,12 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
-	"net/http"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -20,6 +15,24 @@ type MTASTSResult struct {
 	Policy string // Text of MTA-STS policy file
 	Mode   string
 	MXs    []string
+	// Whether the _mta-sts TXT record was authenticated with DNSSEC.
+	RecordDNSSEC bool
+	// The id field of the _mta-sts TXT record.
+	RecordID string
+	// Certificates presented by the policy host, mta-sts.<domain>.
+	PolicyHostCertificates []CertificateSummary
+	// The trust store the policy host's certificate was verified against.
+	PolicyHostTrustStore string
+	// policyFetched is true if the policy file could be retrieved, in which
+	// case its MXs should be validated against the hostname results.
+	policyFetched bool
+	// policyHostPKIX is whether senders would accept the policy host's
+	// certificate. If they wouldn't, they ignore the policy.
+	policyHostPKIX *PKIXValidation
+	// policyValid is true if the policy file parsed without failures, before
+	// validateMXs adds the problems with the MXs to its check. Senders
+	// discard policies that don't.
+	policyValid bool
 }
 
 // MakeMTASTSResult constructs a base result object and returns its pointer.
@@ -36,14 +49,23 @@ func (m MTASTSResult) MarshalJSON() ([]byte, error) {
 	type FakeResult Result
 	return json.Marshal(struct {
 		FakeResult
-		Policy string   `json:"policy"`
-		Mode   string   `json:"mode"`
-		MXs    []string `json:"mxs"`
+		Policy       string   `json:"policy"`
+		Mode         string   `json:"mode"`
+		MXs          []string `json:"mxs"`
+		RecordDNSSEC bool     `json:"record_dnssec"`
+		RecordID     string   `json:"record_id,omitempty"`
+		// Certificates presented by the policy host.
+		PolicyHostCertificates []CertificateSummary `json:"policy_host_certificates,omitempty"`
+		PolicyHostTrustStore   string               `json:"policy_host_trust_store,omitempty"`
 	}{
-		FakeResult: FakeResult(*m.Result),
-		Policy:     m.Policy,
-		Mode:       m.Mode,
-		MXs:        m.MXs,
+		FakeResult:             FakeResult(*m.Result),
+		Policy:                 m.Policy,
+		Mode:                   m.Mode,
+		MXs:                    m.MXs,
+		RecordDNSSEC:           m.RecordDNSSEC,
+		RecordID:               m.RecordID,
+		PolicyHostCertificates: m.PolicyHostCertificates,
+		PolicyHostTrustStore:   m.PolicyHostTrustStore,
 	})
 }
 
@@ -57,113 +79,90 @@ func filterByPrefix(records []string, prefix string) []string {
 	return filtered
 }
 
-func getKeyValuePairs(record string, lineDelimiter string,
-	pairDelimiter string) map[string]string {
-	parsed := make(map[string]string)
-	for _, line := range strings.Split(record, lineDelimiter) {
-		split := strings.Split(strings.TrimSpace(line), pairDelimiter)
-		if len(split) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(split[0])
-		value := strings.TrimSpace(split[1])
-		if parsed[key] == "" {
-			parsed[key] = value
-		} else {
-			parsed[key] = parsed[key] + " " + value
-		}
-	}
-	return parsed
-}
-
-func checkMTASTSRecord(domain string, timeout time.Duration) *Result {
+// checkMTASTSRecord checks the _mta-sts TXT record for domain, and returns
+// the record's id and whether the record was authenticated with DNSSEC.
+func checkMTASTSRecord(ctx context.Context, resolver Resolver, domain string, timeout time.Duration) (*Result, string, bool) {
 	result := MakeResult(MTASTSText)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	var r net.Resolver
-	records, err := r.LookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
+	records, secure, err := resolver.LookupTXT(ctx, fmt.Sprintf("_mta-sts.%s", domain))
 	if err != nil {
-		return result.Failure("Couldn't find an MTA-STS TXT record: %v.", err)
+		return result.Code("mta_sts.record_missing").Failure("Couldn't find an MTA-STS TXT record: %v.", err), "", false
 	}
-	return validateMTASTSRecord(records, result)
+	result, id := validateMTASTSRecord(records, result)
+	return result, id, secure
 }
 
-func validateMTASTSRecord(records []string, result *Result) *Result {
+var mtaSTSIDPattern = regexp.MustCompile("^[a-zA-Z0-9]{1,32}$")
+
+// validateMTASTSRecord validates the _mta-sts TXT records for a domain, and
+// returns the id of the MTA-STS record.
+// https://tools.ietf.org/html/rfc8461#section-3.1
+func validateMTASTSRecord(records []string, result *Result) (*Result, string) {
 	records = filterByPrefix(records, "v=STSv1")
 	if len(records) != 1 {
-		return result.Failure("Exactly 1 MTA-STS TXT record required, found %d.", len(records))
-	}
-	record := getKeyValuePairs(records[0], ";", "=")
-
-	idPattern := regexp.MustCompile("^[a-zA-Z0-9]+$")
-	if !idPattern.MatchString(record["id"]) {
-		return result.Failure("Invalid MTA-STS TXT record id %s.", record["id"])
+		return result.Code("mta_sts.record_count").Failure("Exactly 1 MTA-STS TXT record required, found %d.", len(records)), ""
 	}
-	return result.Success()
-}
-
-func checkMTASTSPolicyFile(domain string, hostnameResults map[string]HostnameResult, timeout time.Duration) (*Result, string, map[string]string) {
-	result := MakeResult(MTASTSPolicyFile)
-	client := &http.Client{
-		Timeout: timeout,
-		// Don't follow redirects.
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
-	policyURL := fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain)
-	resp, err := client.Get(policyURL)
-	if err != nil {
-		return result.Failure("Couldn't find policy file at %s.", policyURL), "", map[string]string{}
-	}
-	if resp.StatusCode != 200 {
-		return result.Failure("Couldn't get policy file: %s returned %s.", policyURL, resp.Status), "", map[string]string{}
-	}
-	// Media type should be text/plain, ignoring other Content-Type parms.
-	// Format: Content-Type := type "/" subtype *[";" parameter]
-	for _, contentType := range resp.Header["Content-Type"] {
-		contentType := strings.ToLower(contentType)
-		if !strings.HasPrefix(contentType, "text/plain") {
-			result.Warning("The media type specified by your policy file's Content-Type header should be text/plain.")
+	id := ""
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(records[0], ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		split := strings.SplitN(field, "=", 2)
+		if len(split) != 2 {
+			result.Code("mta_sts.record_invalid_field").Failure("Invalid field %q in MTA-STS TXT record, expected key=value.", field)
+			continue
+		}
+		key, value := split[0], split[1]
+		if seen[key] {
+			result.Code("mta_sts.record_duplicate_field").Failure("The %s field appears more than once in your MTA-STS TXT record.", key)
+			continue
+		}
+		seen[key] = true
+		switch key {
+		case "v":
+		case "id":
+			id = value
+		default:
+			result.Code("mta_sts.record_unknown_field").Warning("Unknown field %q in your MTA-STS TXT record will be ignored by senders.", key)
 		}
 	}
-	defer resp.Body.Close()
-	// Read up to 64,000 bytes of response body.
-	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64000))
-	if err != nil {
-		return result.Error("Couldn't read policy file: %v.", err), "", map[string]string{}
+	if !mtaSTSIDPattern.MatchString(id) {
+		return result.Code("mta_sts.record_invalid_id").Failure("Invalid MTA-STS TXT record id %s. The id must be 1 to 32 letters and digits.", id), id
 	}
-
-	policy := validateMTASTSPolicyFile(string(body), result)
-	validateMTASTSMXs(strings.Split(policy["mx"], " "), hostnameResults, result)
-	return result, string(body), policy
+	return result.Success(), id
 }
 
-func validateMTASTSPolicyFile(body string, result *Result) map[string]string {
-	policy := getKeyValuePairs(body, "\n", ":")
-
-	if policy["version"] != "STSv1" {
-		result.Failure("Your MTA-STS policy file version must be STSv1.")
+// checkMTASTSPolicyFile validates the MTA-STS policy file fetched from the
+// policy host. The MXs listed in the policy are validated separately, by
+// validateMTASTSMXs, once the hostname checks have completed.
+func checkMTASTSPolicyFile(domain string, fetch policyHostFetch) (*Result, MTASTSPolicy) {
+	result := MakeResult(MTASTSPolicyFile)
+	if fetch.body == "" {
+		return result.Code("mta_sts.policy_unavailable").Failure("Couldn't get policy file from https://mta-sts.%s/.well-known/mta-sts.txt.", domain), MTASTSPolicy{}
 	}
+	return result, validateMTASTSPolicyFile(fetch.body, result)
+}
 
-	if policy["mode"] == "" {
-		result.Failure("Your MTA-STS policy file must specify mode.")
-	}
-	if m := policy["mode"]; m == "testing" {
-		result.Warning("You're still in \"testing\" mode; senders won't enforce TLS when connecting to your mailservers. We recommend switching from \"testing\" to \"enforce\" to get the full security benefits of MTA-STS, as long as it hasn't been affecting your deliverability.")
-	} else if m == "none" {
-		result.Failure("MTA-STS policy is in \"none\" mode; senders won't enforce TLS when connecting to your mailservers.")
-	} else if m != "enforce" {
-		result.Failure("Mode must be one of \"enforce\", \"testing\", or \"none\", got %s", m)
+// validateMTASTSPolicyFile parses the policy file body, adding a message to
+// result for each problem found.
+func validateMTASTSPolicyFile(body string, result *Result) MTASTSPolicy {
+	policy, diagnostics := ParseMTASTSPolicy(body)
+	for _, d := range diagnostics {
+		if d.Status == Failure {
+			result.Code("mta_sts.policy_invalid").Failure("%s", d)
+		} else {
+			result.Code("mta_sts.policy_warning").Warning("%s", d)
+		}
 	}
 
-	if policy["max_age"] == "" {
-		result.Failure("Your MTA-STS policy file must specify max_age.")
-	}
-	if i, err := strconv.Atoi(policy["max_age"]); err != nil || i <= 0 || i > 31557600 {
-		result.Failure("MTA-STS max_age must be a positive integer <= 31557600.")
+	if policy.Mode == "testing" {
+		result.Code("mta_sts.mode_testing").Warning("You're still in \"testing\" mode; senders won't enforce TLS when connecting to your mailservers. We recommend switching from \"testing\" to \"enforce\" to get the full security benefits of MTA-STS, as long as it hasn't been affecting your deliverability.")
+	} else if policy.Mode == "none" {
+		result.Code("mta_sts.mode_none").Failure("MTA-STS policy is in \"none\" mode; senders won't enforce TLS when connecting to your mailservers.")
 	}
-
 	return policy
 }
 
@@ -175,26 +174,52 @@ func validateMTASTSMXs(policyFileMXs []string, dnsMXs map[string]HostnameResult,
 			continue
 		}
 		if !PolicyMatches(dnsMX, policyFileMXs) {
-			result.Failure("%s appears in the DNS record but not the MTA-STS policy file",
+			result.Code("mta_sts.mx_not_in_policy").Failure("%s appears in the DNS record but not the MTA-STS policy file",
 				dnsMX)
 		} else if !dnsMXResult.couldSTARTTLS() {
-			result.Failure("%s appears in the DNS record and MTA-STS policy file, but doesn't support STARTTLS",
+			result.Code("mta_sts.mx_no_starttls").Failure("%s appears in the DNS record and MTA-STS policy file, but doesn't support STARTTLS",
 				dnsMX)
 		}
 	}
 }
 
-func (c Checker) checkMTASTS(domain string, hostnameResults map[string]HostnameResult) *MTASTSResult {
-	if c.checkMTASTSOverride != nil {
-		// Allow the Checker to mock this function.
-		return c.checkMTASTSOverride(domain, hostnameResults)
-	}
+// fetchMTASTS performs the MTA-STS checks that don't depend on the hostname
+// checks, so that it can run concurrently with them.
+func (c Checker) fetchMTASTS(ctx context.Context, domain string) *MTASTSResult {
 	result := MakeMTASTSResult()
-	result.addCheck(checkMTASTSRecord(domain, c.timeout()))
-	policyResult, policy, policyMap := checkMTASTSPolicyFile(domain, hostnameResults, c.timeout())
+	recordResult, id, secure := checkMTASTSRecord(ctx, c.resolver(), domain, c.timeout())
+	result.addCheck(recordResult)
+	result.RecordDNSSEC = secure
+	result.RecordID = id
+	fetch := c.fetchMTASTSPolicy(ctx, domain)
+	for _, check := range fetch.checks {
+		result.addCheck(check)
+	}
+	result.PolicyHostCertificates = fetch.certificates
+	result.PolicyHostTrustStore = c.trustStore()
+	result.policyHostPKIX = fetch.pkix
+	policyResult, policy := checkMTASTSPolicyFile(domain, fetch)
 	result.addCheck(policyResult)
-	result.Policy = policy
-	result.Mode = policyMap["mode"]
-	result.MXs = strings.Split(policyMap["mx"], " ")
+	result.Policy = fetch.body
+	result.Mode = policy.Mode
+	result.MXs = policy.MXs
+	result.policyFetched = fetch.body != ""
+	result.policyValid = policyResult.Status <= Warning
+	if c.Cache != nil && recordResult.Status < Failure && policyResult.Status < Failure {
+		if consistencyResult := c.checkMTASTSConsistency(domain, id, fetch.body); consistencyResult != nil {
+			result.addCheck(consistencyResult)
+		}
+	}
 	return result
 }
+
+// validateMXs checks the MXs listed in the policy file against the results
+// of the hostname checks.
+func (m *MTASTSResult) validateMXs(hostnameResults map[string]HostnameResult) {
+	policyResult, ok := m.Checks[MTASTSPolicyFile]
+	if !ok || !m.policyFetched {
+		return
+	}
+	validateMTASTSMXs(m.MXs, hostnameResults, policyResult)
+	m.addCheck(policyResult)
+}
@@ -0,0 +1,82 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MTASTSSnapshot is the MTA-STS record id and policy seen for a domain by a
+// previous scan.
+type MTASTSSnapshot struct {
+	ID     string    `json:"id"`
+	Policy string    `json:"policy"`
+	Time   time.Time `json:"time"`
+}
+
+// canonicalPolicy returns a representation of policy which is the same for
+// policies that senders treat the same way, even if they're formatted or
+// ordered differently.
+func canonicalPolicy(policy MTASTSPolicy) string {
+	mxs := append([]string{}, policy.MXs...)
+	for i := range mxs {
+		mxs[i] = strings.ToLower(mxs[i])
+	}
+	sort.Strings(mxs)
+	extensions := []string{}
+	for key, value := range policy.Extensions {
+		extensions = append(extensions, key+": "+value)
+	}
+	sort.Strings(extensions)
+	return fmt.Sprintf("version: %s\nmode: %s\nmax_age: %d\nmx: %s\n%s",
+		policy.Version, policy.Mode, policy.MaxAge, strings.Join(mxs, " "), strings.Join(extensions, "\n"))
+}
+
+// checkMTASTSConsistency compares the record id and policy for domain with
+// the ones seen by the last scan, since senders only fetch a new policy when
+// the id changes. Returns nil if the cache's store can't remember them.
+// https://tools.ietf.org/html/rfc8461#section-3.3
+func (c *Checker) checkMTASTSConsistency(domain, id, body string) *Result {
+	store, ok := c.Cache.ScanStore.(MTASTSSnapshotStore)
+	if !ok {
+		return nil
+	}
+	result := MakeResult(MTASTSConsistency)
+	current := MTASTSSnapshot{ID: id, Policy: body, Time: time.Now()}
+	last, err := store.GetMTASTSSnapshot(domain)
+	if err == ErrNoMTASTSSnapshot {
+		// This is the first time we've seen this domain's policy.
+		return putMTASTSSnapshot(store, domain, current, result.Success())
+	}
+	if err != nil {
+		// Don't overwrite the snapshot we couldn't retrieve.
+		return result.Code("mta_sts.snapshot_unavailable").Error("Couldn't retrieve the MTA-STS record id and policy we saw last time: %v", err)
+	}
+	lastPolicy, _ := ParseMTASTSPolicy(last.Policy)
+	policy, _ := ParseMTASTSPolicy(body)
+	policyChanged := canonicalPolicy(lastPolicy) != canonicalPolicy(policy)
+	if policyChanged && last.ID == id {
+		// Keep the old snapshot, so that we keep reporting the stale id
+		// until it's updated.
+		return result.Code("mta_sts.id_unchanged").Failure("Your MTA-STS policy has changed since we saw it on %s, but the id in your _mta-sts TXT record is still %s. "+
+			"Senders that cached your old policy won't fetch the new one until the old one expires, which can take up to its max_age. "+
+			"Change the id whenever you change your policy.",
+			last.Time.UTC().Format("2006-01-02"), id)
+	}
+	if !policyChanged && last.ID != id {
+		result.Code("mta_sts.id_changed_policy_unchanged").Warning("The id in your _mta-sts TXT record changed from %s to %s, but your MTA-STS policy didn't. "+
+			"Each id change makes senders fetch your policy again, so only change it along with your policy.",
+			last.ID, id)
+	}
+	return putMTASTSSnapshot(store, domain, current, result)
+}
+
+// putMTASTSSnapshot stores snapshot for the next scan of domain, and adds an
+// error to result if it couldn't be stored.
+func putMTASTSSnapshot(store MTASTSSnapshotStore, domain string, snapshot MTASTSSnapshot, result *Result) *Result {
+	if err := store.PutMTASTSSnapshot(domain, snapshot); err != nil {
+		return result.Code("mta_sts.snapshot_not_saved").Error("Couldn't save your MTA-STS record id and policy for the next scan: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckMTASTSConsistency(t *testing.T) {
+	const policy = "version: STSv1\nmode: enforce\nmx: a.example.com\nmx: b.example.com\nmax_age: 86400\n"
+	const reordered = "version: STSv1\r\nmode: enforce\r\nmax_age: 86400\r\nmx: b.example.com\r\nmx: a.example.com\r\n"
+	const changed = "version: STSv1\nmode: enforce\nmx: c.example.com\nmax_age: 86400\n"
+	c := Checker{Cache: MakeSimpleCache(time.Hour)}
+	tests := []struct {
+		id      string
+		body    string
+		status  Status
+		message string
+	}{
+		{"1", policy, Success, ""},
+		{"1", reordered, Success, ""},
+		{"1", changed, Failure, "still 1"},
+		// The stale id keeps being reported until it's changed.
+		{"1", changed, Failure, "still 1"},
+		{"2", changed, Success, ""},
+		{"3", changed, Warning, "changed from 2 to 3"},
+	}
+	for i, test := range tests {
+		result := c.checkMTASTSConsistency("example.com", test.id, test.body)
+		if result.Status != test.status {
+			t.Errorf("%d: expected status %v, got %v", i, test.status, result)
+		}
+		if test.message != "" && (len(result.Messages) != 1 || !strings.Contains(result.Messages[0], test.message)) {
+			t.Errorf("%d: expected message containing %q, got %v", i, test.message, result.Messages)
+		}
+	}
+}
+
+// failingSnapshotStore is a snapshot store whose operations fail, like a
+// database that's down.
+type failingSnapshotStore struct {
+	ScanStore
+	getErr, putErr error
+	puts           int
+}
+
+func (s *failingSnapshotStore) GetMTASTSSnapshot(string) (MTASTSSnapshot, error) {
+	return MTASTSSnapshot{}, s.getErr
+}
+
+func (s *failingSnapshotStore) PutMTASTSSnapshot(string, MTASTSSnapshot) error {
+	s.puts++
+	return s.putErr
+}
+
+func TestCheckMTASTSConsistencyStoreErrors(t *testing.T) {
+	store := &failingSnapshotStore{getErr: errors.New("connection refused")}
+	c := Checker{Cache: &ScanCache{ScanStore: store}}
+	result := c.checkMTASTSConsistency("example.com", "1", "version: STSv1\n")
+	if result.Status != Error || result.Details[0].Code != "mta_sts.snapshot_unavailable" {
+		t.Errorf("expected an error when the snapshot can't be retrieved, got %v", result)
+	}
+	if store.puts != 0 {
+		t.Error("expected the snapshot that couldn't be retrieved not to be overwritten")
+	}
+
+	store.getErr = ErrNoMTASTSSnapshot
+	store.putErr = errors.New("connection refused")
+	result = c.checkMTASTSConsistency("example.com", "1", "version: STSv1\n")
+	if result.Status != Error || result.Details[0].Code != "mta_sts.snapshot_not_saved" {
+		t.Errorf("expected an error when the snapshot can't be saved, got %v", result)
+	}
+}
+
+// hostnameStore is a ScanStore that can't remember MTA-STS snapshots, like
+// ones implemented before they were added.
+type hostnameStore struct{ ScanStore }
+
+func TestCheckMTASTSConsistencyWithoutSnapshots(t *testing.T) {
+	cache := MakeSimpleCache(time.Hour)
+	cache.ScanStore = hostnameStore{cache.ScanStore}
+	c := Checker{Cache: cache}
+	if result := c.checkMTASTSConsistency("example.com", "1", "version: STSv1\n"); result != nil {
+		t.Errorf("expected the check to be skipped without a snapshot store, got %v", result)
+	}
+}
@@ -0,0 +1,180 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// slowPolicyResponse is how long the policy host can take to serve the
+// policy file before we warn that senders may give up on fetching it.
+const slowPolicyResponse = 3 * time.Second
+
+// policyHostFetch is the outcome of fetching a policy file from the policy
+// host, mta-sts.<domain>.
+type policyHostFetch struct {
+	// A result for each step of the fetch that was attempted, in order.
+	checks []*Result
+	// Certificates presented by the policy host.
+	certificates []CertificateSummary
+	// Whether senders would accept the policy host's certificate.
+	pkix *PKIXValidation
+	// Body of the policy file, which is empty if it couldn't be fetched.
+	body string
+}
+
+func (f *policyHostFetch) add(result *Result) *Result {
+	f.checks = append(f.checks, result)
+	return result
+}
+
+// fetchMTASTSPolicy fetches the policy file for domain, checking the policy
+// host's DNS, TLS certificate and HTTP response along the way. Unlike a
+// default HTTP client, it reports each of these problems separately, and
+// doesn't follow redirects.
+// https://tools.ietf.org/html/rfc8461#section-3.3
+func (c *Checker) fetchMTASTSPolicy(ctx context.Context, domain string) policyHostFetch {
+	fetch := policyHostFetch{}
+	host := "mta-sts." + domain
+	policyURL := fmt.Sprintf("https://%s/.well-known/mta-sts.txt", host)
+
+	dnsResult := fetch.add(MakeResult(MTASTSPolicyHostDNS))
+	lookupCtx, cancel := context.WithTimeout(ctx, c.timeout())
+	ips, _, err := c.resolver().LookupIP(lookupCtx, host)
+	cancel()
+	if err != nil || len(ips) == 0 {
+		dnsResult.Code("mta_sts.policy_host_dns").Failure("Couldn't find any addresses for %s, the policy host: %v.", host, err)
+		return fetch
+	}
+	dnsResult.Success()
+
+	port := c.PolicyHostPort
+	if port == "" {
+		port = "443"
+	}
+	network := c.network()
+	// The state of the TLS connection the policy was fetched over.
+	var state *tls.ConnectionState
+	client := &http.Client{
+		Timeout: c.timeout(),
+		Transport: &http.Transport{
+			// Connect to the addresses we looked up with our resolver.
+			// Each address is tried in turn, and the last error is reported
+			// if none of them complete a handshake.
+			DialTLS: func(_, _ string) (net.Conn, error) {
+				var dialErr error
+				for _, ip := range ips {
+					conn, err := network.dial(ctx, net.JoinHostPort(ip.String(), port), c.timeout())
+					if err != nil {
+						dialErr = err
+						continue
+					}
+					// The certificate is verified below, so that we can report
+					// why it's invalid and still check the rest of the response.
+					tlsConn := network.tlsClient(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+					if err := tlsConn.Handshake(); err != nil {
+						conn.Close()
+						dialErr = err
+						continue
+					}
+					connState := tlsConn.ConnectionState()
+					state = &connState
+					return tlsConn, nil
+				}
+				return nil, dialErr
+			},
+			DisableKeepAlives: true,
+		},
+		// RFC 8461 forbids following redirects.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest("GET", policyURL, nil)
+	if err != nil {
+		fetch.add(MakeResult(MTASTSPolicyHostTLS)).Code("mta_sts.policy_request_error").Error("Couldn't build request for %s: %v.", policyURL, err)
+		return fetch
+	}
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	elapsed := time.Since(start)
+	tlsResult := fetch.add(MakeResult(MTASTSPolicyHostTLS))
+	if err != nil {
+		tlsResult.Code("mta_sts.policy_host_https").Failure("Couldn't connect to %s over HTTPS: %v.", host, err)
+		return fetch
+	}
+	defer resp.Body.Close()
+	fetch.certificates = c.validatePolicyHostCert(state, host, tlsResult)
+	if state != nil {
+		fetch.pkix = c.validatePKIX(*state, host)
+	}
+
+	timeResult := fetch.add(MakeResult(MTASTSPolicyResponseTime))
+	if elapsed > slowPolicyResponse {
+		timeResult.Code("mta_sts.policy_host_slow").Warning("%s took %.1f seconds to respond. Senders may give up on fetching slow policies.",
+			host, elapsed.Seconds())
+	} else {
+		timeResult.Success()
+	}
+
+	redirectResult := fetch.add(MakeResult(MTASTSPolicyRedirect))
+	if location := resp.Header.Get("Location"); resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		redirectResult.Code("mta_sts.policy_redirect").Failure("%s redirects to %q, but senders must not follow redirects when fetching a policy.",
+			policyURL, location)
+		return fetch
+	}
+	redirectResult.Success()
+
+	statusResult := fetch.add(MakeResult(MTASTSPolicyHTTPStatus))
+	if resp.StatusCode != http.StatusOK {
+		statusResult.Code("mta_sts.policy_http_status").Failure("Couldn't get policy file: %s returned %s.", policyURL, resp.Status)
+		return fetch
+	}
+	statusResult.Success()
+
+	typeResult := fetch.add(MakeResult(MTASTSPolicyContentType))
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || strings.ToLower(mediaType) != "text/plain" {
+		typeResult.Code("mta_sts.policy_content_type").Warning("The media type specified by your policy file's Content-Type header should be text/plain, got %q.",
+			resp.Header.Get("Content-Type"))
+	} else {
+		typeResult.Success()
+	}
+
+	// Read one byte past the size limit, so the parser can report policy
+	// files that are too large.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMTASTSPolicySize+1))
+	if err != nil {
+		statusResult.Code("mta_sts.policy_read_error").Error("Couldn't read policy file: %v.", err)
+		return fetch
+	}
+	fetch.body = string(body)
+	return fetch
+}
+
+// validatePolicyHostCert checks that the policy host presented a certificate
+// that's valid for host, and returns the certificate chain's details.
+func (c *Checker) validatePolicyHostCert(state *tls.ConnectionState, host string, result *Result) []CertificateSummary {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		result.Code("mta_sts.policy_host_no_certificate").Error("Policy host didn't present a certificate.")
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	validateCertStrength(state.PeerCertificates, result)
+	validateCertExpiry(cert, c.certExpiryWindow(), c.now(), result)
+	if err := cert.VerifyHostname(host); err != nil {
+		result.Code("mta_sts.policy_host_cert_hostname_mismatch").Failure("Policy host's certificate isn't valid for %s: %v.", host, err)
+	}
+	if err := verifyCertChain(*state, c.RootCAs, c.now()); err != nil {
+		result.Code("mta_sts.policy_host_cert_untrusted").Failure("Policy host's certificate isn't trusted by the %s trust store: %v.", c.trustStore(), err)
+	}
+	result.Success()
+	return summarizeChain(state.PeerCertificates)
+}
@@ -0,0 +1,204 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// policyHostResolver resolves every MTA-STS policy host to the loopback
+// address.
+type policyHostResolver struct {
+	mockResolver
+}
+
+func (policyHostResolver) LookupIP(_ context.Context, name string) ([]net.IP, bool, error) {
+	if strings.HasPrefix(name, "mta-sts.") {
+		return []net.IP{net.ParseIP("127.0.0.1")}, false, nil
+	}
+	return nil, false, nil
+}
+
+// policyHostAddressesResolver resolves every MTA-STS policy host to ips.
+type policyHostAddressesResolver struct {
+	mockResolver
+	ips []net.IP
+}
+
+func (r policyHostAddressesResolver) LookupIP(_ context.Context, name string) ([]net.IP, bool, error) {
+	return r.ips, false, nil
+}
+
+// servePolicyHost serves handler over HTTPS with a certificate for
+// certName, and returns a Checker that fetches policies from the server.
+func servePolicyHost(t *testing.T, certName string, handler http.HandlerFunc) (*httptest.Server, Checker) {
+	certPEM := createCert(key, certName)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(certPEM))
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	return server, Checker{Timeout: testTimeout, Resolver: policyHostResolver{}, RootCAs: roots, PolicyHostPort: port}
+}
+
+const testPolicy = "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n"
+
+func checkStatuses(t *testing.T, fetch policyHostFetch, want map[string]Status) {
+	got := make(map[string]Status)
+	for _, check := range fetch.checks {
+		got[check.Name] = check.Status
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected checks %v, got %v", want, got)
+	}
+	for name, status := range want {
+		if s, ok := got[name]; !ok || s != status {
+			t.Errorf("Expected %s to have status %v, got %v", name, status, got)
+		}
+	}
+}
+
+func TestFetchMTASTSPolicy(t *testing.T) {
+	server, c := servePolicyHost(t, "mta-sts.example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(testPolicy))
+	})
+	defer server.Close()
+
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{
+		MTASTSPolicyHostDNS:      Success,
+		MTASTSPolicyHostTLS:      Success,
+		MTASTSPolicyResponseTime: Success,
+		MTASTSPolicyRedirect:     Success,
+		MTASTSPolicyHTTPStatus:   Success,
+		MTASTSPolicyContentType:  Success,
+	})
+	if fetch.body != testPolicy {
+		t.Errorf("Expected policy body %q, got %q", testPolicy, fetch.body)
+	}
+	if len(fetch.certificates) != 1 || fetch.certificates[0].DNSNames[0] != "mta-sts.example.com" {
+		t.Errorf("Expected summary of the policy host's certificate, got %v", fetch.certificates)
+	}
+}
+
+func TestFetchMTASTSPolicyBadCertificate(t *testing.T) {
+	server, c := servePolicyHost(t, "localhost", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(testPolicy))
+	})
+	defer server.Close()
+
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{
+		MTASTSPolicyHostDNS:      Success,
+		MTASTSPolicyHostTLS:      Failure,
+		MTASTSPolicyResponseTime: Success,
+		MTASTSPolicyRedirect:     Success,
+		MTASTSPolicyHTTPStatus:   Success,
+		MTASTSPolicyContentType:  Success,
+	})
+}
+
+func TestFetchMTASTSPolicyFirstAddressFails(t *testing.T) {
+	server, c := servePolicyHost(t, "mta-sts.example.com", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(testPolicy))
+	})
+	defer server.Close()
+	// Another address of the policy host accepts connections, but hangs up
+	// instead of completing the handshake.
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", c.PolicyHostPort))
+	if err != nil {
+		t.Skipf("Couldn't listen on a second loopback address: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	c.Resolver = policyHostAddressesResolver{ips: []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}}
+
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	if fetch.body != testPolicy {
+		t.Errorf("Expected the policy to be fetched from the second address, got checks %v", fetch.checks)
+	}
+
+	c.Resolver = policyHostAddressesResolver{ips: []net.IP{net.ParseIP("127.0.0.2")}}
+	fetch = c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{
+		MTASTSPolicyHostDNS: Success,
+		MTASTSPolicyHostTLS: Failure,
+	})
+}
+
+func TestFetchMTASTSPolicyRedirect(t *testing.T) {
+	server, c := servePolicyHost(t, "mta-sts.example.com", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.net/mta-sts.txt", http.StatusMovedPermanently)
+	})
+	defer server.Close()
+
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{
+		MTASTSPolicyHostDNS:      Success,
+		MTASTSPolicyHostTLS:      Success,
+		MTASTSPolicyResponseTime: Success,
+		MTASTSPolicyRedirect:     Failure,
+	})
+	if fetch.body != "" {
+		t.Errorf("Expected redirect not to be followed, got body %q", fetch.body)
+	}
+}
+
+func TestFetchMTASTSPolicyStatusAndContentType(t *testing.T) {
+	server, c := servePolicyHost(t, "mta-sts.example.com", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "mta-sts.missing.") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testPolicy))
+	})
+	defer server.Close()
+
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{
+		MTASTSPolicyHostDNS:      Success,
+		MTASTSPolicyHostTLS:      Success,
+		MTASTSPolicyResponseTime: Success,
+		MTASTSPolicyRedirect:     Success,
+		MTASTSPolicyHTTPStatus:   Success,
+		MTASTSPolicyContentType:  Warning,
+	})
+
+	fetch = c.fetchMTASTSPolicy(context.Background(), "missing.example.com")
+	if fetch.checks[len(fetch.checks)-1].Name != MTASTSPolicyHTTPStatus ||
+		fetch.checks[len(fetch.checks)-1].Status != Failure {
+		t.Errorf("Expected 404 to fail the HTTP status check, got %v", fetch.checks)
+	}
+}
+
+func TestFetchMTASTSPolicyNoDNS(t *testing.T) {
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}}
+	fetch := c.fetchMTASTSPolicy(context.Background(), "example.com")
+	checkStatuses(t, fetch, map[string]Status{MTASTSPolicyHostDNS: Failure})
+	result, _ := checkMTASTSPolicyFile("example.com", fetch)
+	if result.Status != Failure {
+		t.Errorf("Expected policy file check to fail without a policy host, got %v", result)
+	}
+}
@@ -0,0 +1,219 @@
+package checker
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// maxMTASTSPolicySize is the size of the largest policy file we accept.
+// Senders limit the size of policy files they'll fetch, and 64,000 bytes is
+// far larger than any legitimate policy.
+const maxMTASTSPolicySize = 64000
+
+// maxMTASTSMaxAge is the largest max_age allowed by RFC 8461, about a year.
+const maxMTASTSMaxAge = 31557600
+
+// MTASTSPolicy is an MTA-STS policy file parsed by ParseMTASTSPolicy.
+// https://tools.ietf.org/html/rfc8461#section-3.2
+type MTASTSPolicy struct {
+	Version string
+	Mode    string
+	MXs     []string
+	MaxAge  int
+	// Extensions holds fields whose keys aren't defined by RFC 8461.
+	Extensions map[string]string
+}
+
+// PolicyDiagnostic describes a problem found while parsing a policy file.
+type PolicyDiagnostic struct {
+	// Line is the 1-indexed line the problem was found on, or 0 if the
+	// problem concerns the whole file, like a missing field.
+	Line    int
+	Status  Status
+	Message string
+}
+
+func (d PolicyDiagnostic) String() string {
+	if d.Line == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("Line %d: %s", d.Line, d.Message)
+}
+
+// ParseMTASTSPolicy parses body according to the policy grammar in RFC 8461,
+// and returns the policy along with a diagnostic for each problem found. The
+// policy is only valid if none of the diagnostics are failures.
+func ParseMTASTSPolicy(body string) (MTASTSPolicy, []PolicyDiagnostic) {
+	p := policyParser{
+		policy: MTASTSPolicy{
+			MXs:        []string{},
+			Extensions: make(map[string]string),
+		},
+		seen: make(map[string]int),
+	}
+	p.parse(body)
+	return p.policy, p.diagnostics
+}
+
+// Known policy keys. Keys are case-sensitive.
+var mtaSTSPolicyKeys = map[string]bool{
+	"version": true,
+	"mode":    true,
+	"max_age": true,
+	"mx":      true,
+}
+
+var (
+	policyKeyPattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_\-.]{0,31}$`)
+	policyMaxAgePattern = regexp.MustCompile(`^[0-9]{1,10}$`)
+	hostnameLabel       = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// policyParser holds the state of a single ParseMTASTSPolicy call.
+type policyParser struct {
+	policy      MTASTSPolicy
+	diagnostics []PolicyDiagnostic
+	// Line on which each non-mx key was first seen.
+	seen map[string]int
+}
+
+func (p *policyParser) failure(line int, format string, a ...interface{}) {
+	p.diagnostics = append(p.diagnostics,
+		PolicyDiagnostic{Line: line, Status: Failure, Message: fmt.Sprintf(format, a...)})
+}
+
+func (p *policyParser) warning(line int, format string, a ...interface{}) {
+	p.diagnostics = append(p.diagnostics,
+		PolicyDiagnostic{Line: line, Status: Warning, Message: fmt.Sprintf(format, a...)})
+}
+
+func (p *policyParser) parse(body string) {
+	if len(body) > maxMTASTSPolicySize {
+		p.failure(0, "Your MTA-STS policy file is larger than %d bytes.", maxMTASTSPolicySize)
+	}
+	lines := strings.Split(body, "\n")
+	// The line delimiter after the last field is optional.
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		p.parseLine(i+1, strings.TrimSuffix(line, "\r"))
+	}
+
+	if _, ok := p.seen["version"]; !ok {
+		p.failure(0, "Your MTA-STS policy file must specify version: STSv1.")
+	}
+	if _, ok := p.seen["mode"]; !ok {
+		p.failure(0, "Your MTA-STS policy file must specify mode.")
+	}
+	if _, ok := p.seen["max_age"]; !ok {
+		p.failure(0, "Your MTA-STS policy file must specify max_age.")
+	}
+	if len(p.policy.MXs) == 0 && p.policy.Mode != "none" {
+		p.failure(0, "Your MTA-STS policy file must specify at least one mx.")
+	}
+}
+
+func (p *policyParser) parseLine(n int, line string) {
+	if strings.ContainsRune(line, '\r') {
+		p.failure(n, "Lines must end with CRLF or LF, found a bare CR.")
+		return
+	}
+	if strings.Trim(line, " \t") == "" {
+		p.warning(n, "Blank lines aren't allowed between fields, and may cause some senders to reject your policy.")
+		return
+	}
+	if !utf8.ValidString(line) {
+		p.failure(n, "Line isn't valid UTF-8.")
+		return
+	}
+	line = strings.TrimLeft(line, " \t")
+	// Split on the first colon only, since extension values may contain
+	// colons.
+	i := strings.Index(line, ":")
+	if i < 0 {
+		p.failure(n, "Expected a field of the form \"key: value\", got %q.", line)
+		return
+	}
+	key, value := line[:i], strings.Trim(line[i+1:], " \t")
+	if !policyKeyPattern.MatchString(key) {
+		p.failure(n, "Invalid key %q. Keys may only contain letters, digits, \"_\", \"-\" and \".\", with no space before the colon.", key)
+		return
+	}
+	if value == "" {
+		p.failure(n, "Missing value for %s.", key)
+		return
+	}
+	if key == "mx" {
+		p.parseMX(n, value)
+		return
+	}
+	if first, ok := p.seen[key]; ok {
+		p.failure(n, "Duplicate %s field, already set on line %d.", key, first)
+		return
+	}
+	p.seen[key] = n
+	switch key {
+	case "version":
+		p.policy.Version = value
+		if value != "STSv1" {
+			p.failure(n, "Your MTA-STS policy file version must be STSv1, got %q.", value)
+		}
+	case "mode":
+		p.policy.Mode = value
+		if value != "enforce" && value != "testing" && value != "none" {
+			p.failure(n, "Mode must be one of \"enforce\", \"testing\", or \"none\", got %q.", value)
+		}
+	case "max_age":
+		maxAge, err := strconv.Atoi(value)
+		if !policyMaxAgePattern.MatchString(value) || err != nil || maxAge <= 0 || maxAge > maxMTASTSMaxAge {
+			p.failure(n, "MTA-STS max_age must be a positive integer <= %d, got %q.", maxMTASTSMaxAge, value)
+			return
+		}
+		p.policy.MaxAge = maxAge
+	default:
+		if mtaSTSPolicyKeys[strings.ToLower(key)] {
+			p.failure(n, "Keys are case-sensitive: use %q instead of %q.", strings.ToLower(key), key)
+			return
+		}
+		for _, r := range value {
+			if unicode.IsControl(r) {
+				p.failure(n, "Value for %s contains a control character.", key)
+				return
+			}
+		}
+		p.policy.Extensions[key] = value
+	}
+}
+
+// parseMX validates an mx pattern, which is a hostname whose leftmost label
+// may be a "*" wildcard.
+func (p *policyParser) parseMX(n int, value string) {
+	hostname := strings.TrimPrefix(value, "*.")
+	if strings.Contains(hostname, "*") {
+		p.failure(n, "Invalid mx pattern %q. A wildcard must be the entire leftmost label, as in \"*.example.com\".", value)
+		return
+	}
+	if strings.HasSuffix(hostname, ".") {
+		p.failure(n, "Invalid mx pattern %q. Patterns must not end with \".\".", value)
+		return
+	}
+	ascii, err := idna.ToASCII(hostname)
+	if err != nil || len(ascii) > 253 {
+		p.failure(n, "Invalid mx pattern %q.", value)
+		return
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if !hostnameLabel.MatchString(label) {
+			p.failure(n, "Invalid mx pattern %q. %q isn't a valid hostname label.", value, label)
+			return
+		}
+	}
+	p.policy.MXs = append(p.policy.MXs, value)
+}
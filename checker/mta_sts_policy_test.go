@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	policy, diagnostics := ParseMTASTSPolicy("version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\nfoo-ext: a:b:c\r\n")
+	if len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diagnostics)
+	}
+	want := MTASTSPolicy{
+		Version:    "STSv1",
+		Mode:       "enforce",
+		MXs:        []string{"mail.example.com", "*.example.net"},
+		MaxAge:     604800,
+		Extensions: map[string]string{"foo-ext": "a:b:c"},
+	}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("ParseMTASTSPolicy() = %+v, want %+v", policy, want)
+	}
+}
+
+func TestParseMTASTSPolicyDiagnostics(t *testing.T) {
+	const valid = "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n"
+	tests := []struct {
+		body   string
+		line   int
+		status Status
+		// Substring of the diagnostic's message.
+		message string
+	}{
+		{valid + "mode: testing\n", 5, Failure, "Duplicate mode field, already set on line 2"},
+		{valid + "max_age: 100\n", 5, Failure, "Duplicate max_age"},
+		{"Version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", 1, Failure, "case-sensitive"},
+		{valid + "garbage\n", 5, Failure, "key: value"},
+		{valid + "mx : b.example.com\n", 5, Failure, "Invalid key"},
+		{valid + "mx: mail.*.example.com\n", 5, Failure, "wildcard"},
+		{valid + "mx: *example.com\n", 5, Failure, "wildcard"},
+		{valid + "mx: -mail.example.com\n", 5, Failure, "hostname label"},
+		{valid + "mx: mail.example.com.\n", 5, Failure, "must not end"},
+		{valid + "mx:\n", 5, Failure, "Missing value"},
+		{"version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 31557601\n", 4, Failure, "max_age"},
+		{"version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 1e5\n", 4, Failure, "max_age"},
+		{"version: STSv1\nmode: enforce\n\nmx: mail.example.com\nmax_age: 86400\n", 3, Warning, "Blank lines"},
+		{"version: STSv1\rmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", 1, Failure, "bare CR"},
+		{"version: STSv2\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", 1, Failure, "STSv1"},
+		{"version: STSv1\nmode: enforce\nmax_age: 86400\n", 0, Failure, "at least one mx"},
+		{"mode: enforce\nmx: mail.example.com\nmax_age: 86400\n", 0, Failure, "must specify version"},
+		{valid + strings.Repeat("x", maxMTASTSPolicySize), 0, Failure, "larger than"},
+	}
+	for _, test := range tests {
+		_, diagnostics := ParseMTASTSPolicy(test.body)
+		found := false
+		for _, d := range diagnostics {
+			if d.Line == test.line && d.Status == test.status && strings.Contains(d.Message, test.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("ParseMTASTSPolicy(%q) = %v, want a diagnostic on line %d containing %q",
+				test.body, diagnostics, test.line, test.message)
+		}
+	}
+}
+
+func TestParseMTASTSPolicyNoneModeWithoutMX(t *testing.T) {
+	_, diagnostics := ParseMTASTSPolicy("version: STSv1\nmode: none\nmax_age: 86400\n")
+	if len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics for a none-mode policy without mx, got %v", diagnostics)
+	}
+}
+
+func TestValidateMTASTSPolicyFileLineNumbers(t *testing.T) {
+	result := MakeResult(MTASTSPolicyFile)
+	validateMTASTSPolicyFile("version: STSv1\nmode: enforce\nmode: testing\nmx: mail.example.com\nmax_age: 86400\n", result)
+	if result.Status != Failure || len(result.Messages) != 1 ||
+		!strings.HasPrefix(result.Messages[0], "Failure: Line 3: Duplicate mode field") {
+		t.Errorf("Expected a failure pointing at line 3, got %v", result.Messages)
+	}
+}
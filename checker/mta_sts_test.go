@@ -3,7 +3,6 @@ package checker
 import (
 	"bytes"
 	"encoding/json"
-	"reflect"
 	"testing"
 )
 
@@ -18,36 +17,6 @@ func TestMarshalMTASTSJSON(t *testing.T) {
 	}
 }
 
-func TestGetKeyValuePairs(t *testing.T) {
-	tests := []struct {
-		txt  string
-		ld   string
-		pd   string
-		want map[string]string
-	}{
-		{"", ";", "=", map[string]string{}},
-		{"v=STSv1; foo;", ";", "=", map[string]string{
-			"v": "STSv1",
-		}},
-		{"v=STSv1; id=20171114T070707;", ";", "=", map[string]string{
-			"v":  "STSv1",
-			"id": "20171114T070707",
-		}},
-		{"version: STSv1\nmode: enforce\nmx: foo.example.com\nmx: bar.example.com\n\n", "\n", ":", map[string]string{
-			"version": "STSv1",
-			"mode":    "enforce",
-			"mx":      "foo.example.com bar.example.com",
-		}},
-	}
-	for _, test := range tests {
-		got := getKeyValuePairs(test.txt, test.ld, test.pd)
-		if !reflect.DeepEqual(got, test.want) {
-			t.Errorf("getKeyValuePairs(%s, %s, %s) = %v, want %v",
-				test.txt, test.ld, test.pd, got, test.want)
-		}
-	}
-}
-
 func TestValidateMTASTSRecord(t *testing.T) {
 	tests := []struct {
 		txt    []string
@@ -58,9 +27,14 @@ func TestValidateMTASTSRecord(t *testing.T) {
 		{[]string{"v=STSv1; id=;"}, Failure},
 		{[]string{"v=STSv1; id=###;"}, Failure},
 		{[]string{"v=spf1 a -all"}, Failure},
+		{[]string{"v=STSv1; id=abc; id=def"}, Failure},
+		{[]string{"v=STSv1; v=STSv1; id=abc"}, Failure},
+		{[]string{"v=STSv1; id=abc; garbage"}, Failure},
+		{[]string{"v=STSv1; id=abc; foo=bar"}, Warning},
+		{[]string{"v=STSv1; id=123456789012345678901234567890123"}, Failure},
 	}
 	for _, test := range tests {
-		result := validateMTASTSRecord(test.txt, &Result{})
+		result, _ := validateMTASTSRecord(test.txt, &Result{})
 		if result.Status != test.status {
 			t.Errorf("validateMTASTSRecord(%v) = %v", test.txt, result)
 		}
@@ -93,8 +67,8 @@ func TestValidateMTASTSMXs(t *testing.T) {
 		Result: &Result{
 			Status: 3,
 			Checks: map[string]*Result{
-				"connectivity": {Connectivity, 0, nil, nil},
-				"starttls":     {STARTTLS, 0, nil, nil},
+				"connectivity": {Connectivity, 0, nil, nil, nil},
+				"starttls":     {STARTTLS, 0, nil, nil, nil},
 			},
 		},
 	}
@@ -102,8 +76,8 @@ func TestValidateMTASTSMXs(t *testing.T) {
 		Result: &Result{
 			Status: 3,
 			Checks: map[string]*Result{
-				"connectivity": {Connectivity, 0, nil, nil},
-				"starttls":     {STARTTLS, 3, nil, nil},
+				"connectivity": {Connectivity, 0, nil, nil, nil},
+				"starttls":     {STARTTLS, 3, nil, nil, nil},
 			},
 		},
 	}
@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"golang.org/x/net/idna"
+)
+
+// MXRecord is an MX record of a domain.
+type MXRecord struct {
+	Hostname string `json:"hostname"`
+	// Preference of the MX. Senders try MXs with lower values first.
+	Pref uint16 `json:"preference"`
+}
+
+// StatusPolicy determines which MXs the status of a DomainResult is derived
+// from.
+type StatusPolicy int
+
+// Values for StatusPolicy
+const (
+	// StatusAllMXs derives the domain status from every MX we could connect
+	// to. This is the default.
+	StatusAllMXs StatusPolicy = iota
+	// StatusPrimaryMXs derives the domain status from the MXs with the lowest
+	// preference value that we could connect to. Backup MXs are only
+	// reported in the backup-mx check.
+	StatusPrimaryMXs
+	// StatusWeighted derives the domain status from the primary MXs, but
+	// problems with backup MXs still downgrade it to a warning.
+	StatusWeighted
+)
+
+// sortMXRecords sorts records by preference, keeping the order of records
+// with the same preference.
+func sortMXRecords(records []MXRecord) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+}
+
+// withoutNullMX removes null MX records from records, and reports whether
+// there were any. A domain that doesn't accept mail publishes a single null
+// MX, whose hostname is ".".
+// https://tools.ietf.org/html/rfc7505
+func withoutNullMX(records []MXRecord) ([]MXRecord, bool) {
+	filtered := []MXRecord{}
+	null := false
+	for _, record := range records {
+		if record.Hostname == "." || record.Hostname == "" {
+			null = true
+		} else {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered, null
+}
+
+// implicitMX returns the MX record that senders use for a domain without MX
+// records: the domain itself, if it has A or AAAA records.
+// https://tools.ietf.org/html/rfc5321#section-5.1
+func (c *Checker) implicitMX(ctx context.Context, domain string) ([]MXRecord, error) {
+	domainASCII, err := idna.ToASCII(domain)
+	if err != nil {
+		return nil, fmt.Errorf("domain name %s couldn't be converted to ASCII", domain)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	ips, _, err := c.resolver().LookupIP(ctx, domainASCII)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("No MX, A or AAAA records found for %s", domain)
+	}
+	return []MXRecord{{Hostname: domainASCII, Pref: 0}}, nil
+}
+
+// primaryHostnames splits hostnames, which should be in MX priority order,
+// into the ones with the lowest preference value and the backups.
+func primaryHostnames(hostnames []string, records []MXRecord) (primary, backup []string) {
+	prefs := make(map[string]uint16)
+	for _, record := range records {
+		if _, ok := prefs[record.Hostname]; !ok {
+			prefs[record.Hostname] = record.Pref
+		}
+	}
+	for _, hostname := range hostnames {
+		if len(primary) == 0 || prefs[hostname] == prefs[primary[0]] {
+			primary = append(primary, hostname)
+		} else {
+			backup = append(backup, hostname)
+		}
+	}
+	return primary, backup
+}
+
+// checkBackupMXs reports problems with the backup MXs of a domain. Attackers
+// who can block connections to the primary MXs can force senders to deliver
+// through a backup, so backups need to be as secure as the primaries.
+func checkBackupMXs(backup []string, hostnameResults map[string]HostnameResult) *Result {
+	result := MakeResult(BackupMX)
+	for _, hostname := range backup {
+		hostnameResult := hostnameResults[hostname]
+		if !hostnameResult.couldSTARTTLS() {
+			result.Code("mx.backup_no_starttls").Failure("Backup MX %s doesn't support STARTTLS. An attacker who blocks your primary MXs can make senders deliver your mail through it in plaintext.", hostname)
+		} else if hostnameResult.Status >= Failure {
+			result.Code("mx.backup_failing").Failure("Backup MX %s failed some of our checks. An attacker who blocks your primary MXs can make senders deliver your mail through it.", hostname)
+		} else if hostnameResult.Status == Warning {
+			result.Code("mx.backup_warnings").Warning("Backup MX %s has some warnings. Backup MXs should be as secure as your primary MXs.", hostname)
+		}
+	}
+	return result.Success()
+}
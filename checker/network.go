@@ -0,0 +1,65 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// network makes the connections that checks use, so that they can be
+// recorded and replayed. See Checker.Record and Checker.Replay.
+type network interface {
+	// dial connects to address over TCP.
+	dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, error)
+	// tlsClient returns a TLS client connection over conn, which is, or
+	// wraps, a connection returned by dial.
+	tlsClient(conn net.Conn, config *tls.Config) tlsConn
+}
+
+// tlsConn is the part of *tls.Conn that checks use.
+type tlsConn interface {
+	net.Conn
+	Handshake() error
+	ConnectionState() tls.ConnectionState
+}
+
+// directNetwork connects to servers over the network.
+type directNetwork struct{}
+
+func (directNetwork) dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+func (directNetwork) tlsClient(conn net.Conn, config *tls.Config) tlsConn {
+	return tls.Client(conn, config)
+}
+
+func (c *Checker) network() network {
+	if c.Replay != nil {
+		return replayNetwork{c.Replay}
+	}
+	if c.Record != nil {
+		return recordingNetwork{c.Record}
+	}
+	return directNetwork{}
+}
+
+// now returns the current time, or the time of the recording being
+// replayed, so that certificates are checked as they were when recorded.
+func (c *Checker) now() time.Time {
+	if c.Replay != nil {
+		return c.Replay.Time
+	}
+	return time.Now()
+}
+
+// wrappedConn is implemented by connections that wrap another.
+type wrappedConn interface {
+	unwrap() net.Conn
+}
+
+func (c *contextConn) unwrap() net.Conn   { return c.Conn }
+func (c *recordingConn) unwrap() net.Conn { return c.Conn }
+func (c bufferedConn) unwrap() net.Conn   { return c.Conn }
@@ -0,0 +1,13 @@
+//go:build !go1.13
+// +build !go1.13
+
+package checker
+
+import "net"
+
+// notFoundError is the error that a DNSResolver returns when name doesn't
+// have any records of the type that was looked up. net.DNSError has no
+// IsNotFound before Go 1.13, so only its message says so.
+func notFoundError(name, server string) *net.DNSError {
+	return &net.DNSError{Err: errNoSuchHost, Name: name, Server: server}
+}
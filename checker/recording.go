@@ -0,0 +1,372 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A Recording holds everything a Checker observed on the network: DNS
+// answers, SMTP transcripts, the outcome of TLS handshakes and MTA-STS
+// policy fetches. A Checker whose Record field is set adds to a Recording
+// as it checks, and one whose Replay field is set checks against a
+// Recording instead of the network, so that a scan can be reproduced
+// exactly, e.g. to debug a bug report or to turn it into a test.
+type Recording struct {
+	// Time is when the recording was made. Replayed checks use it as the
+	// current time, e.g. to check certificate expiry.
+	Time        time.Time        `json:"time"`
+	DNS         []*DNSRecording  `json:"dns"`
+	Connections []*ConnRecording `json:"connections"`
+
+	mu sync.Mutex
+	// How many of each DNS query and connection have been replayed, keyed
+	// by DNSRecording.key or ConnRecording.Address.
+	dnsReplayed  map[string]int
+	connReplayed map[string]int
+}
+
+// DNSRecording is the answer to a DNS query.
+type DNSRecording struct {
+	// Type is the type of the query, like "MX", and Name the name queried.
+	Type   string       `json:"type"`
+	Name   string       `json:"name"`
+	MX     []*net.MX    `json:"mx,omitempty"`
+	TXT    []string     `json:"txt,omitempty"`
+	IP     []net.IP     `json:"ip,omitempty"`
+	TLSA   []TLSARecord `json:"tlsa,omitempty"`
+	SRV    []*net.SRV   `json:"srv,omitempty"`
+	Secure bool         `json:"secure,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// ConnRecording is a TCP connection, as seen by a Checker.
+type ConnRecording struct {
+	Address string `json:"address"`
+	// DialError is set if the connection couldn't be made. Unreachable
+	// records whether that was because this machine had no route to the
+	// address's network.
+	DialError   string       `json:"dial_error,omitempty"`
+	Unreachable bool         `json:"unreachable,omitempty"`
+	Events      []*ConnEvent `json:"events,omitempty"`
+}
+
+// ConnEvent is something that happened on a connection: data was sent or
+// received, a read failed, or a TLS handshake was made. Data sent or
+// received after a handshake is what was sent or received over TLS.
+type ConnEvent struct {
+	Sent     connData      `json:"sent,omitempty"`
+	Received connData      `json:"received,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Timeout  bool          `json:"timeout,omitempty"`
+	TLS      *TLSRecording `json:"tls,omitempty"`
+}
+
+// TLSRecording is the outcome of a TLS handshake.
+type TLSRecording struct {
+	Error       string `json:"error,omitempty"`
+	Version     uint16 `json:"version,omitempty"`
+	CipherSuite uint16 `json:"cipher_suite,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	// Curve is the group used for the key exchange, as reported in
+	// NegotiatedTLS.
+	Curve        string   `json:"curve,omitempty"`
+	Certificates [][]byte `json:"certificates,omitempty"`
+	OCSPResponse []byte   `json:"ocsp_response,omitempty"`
+	SCTs         [][]byte `json:"scts,omitempty"`
+}
+
+// connData is data sent or received on a connection. It's marshalled as a
+// string, so that SMTP transcripts are readable, unless it isn't valid
+// UTF-8, in which case it's base64-encoded after a "base64:" prefix.
+type connData []byte
+
+const base64Prefix = "base64:"
+
+func (d connData) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(d) && !strings.HasPrefix(string(d), base64Prefix) {
+		return json.Marshal(string(d))
+	}
+	return json.Marshal(base64Prefix + base64.StdEncoding.EncodeToString(d))
+}
+
+func (d *connData) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(s, base64Prefix) {
+		*d = connData(s)
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(s[len(base64Prefix):])
+	*d = data
+	return err
+}
+
+// NewRecording returns an empty Recording, made now.
+func NewRecording() *Recording {
+	return &Recording{
+		Time:        time.Now(),
+		DNS:         []*DNSRecording{},
+		Connections: []*ConnRecording{},
+	}
+}
+
+// LoadRecording reads a Recording saved by Save.
+func LoadRecording(r io.Reader) (*Recording, error) {
+	recording := &Recording{}
+	if err := json.NewDecoder(r).Decode(recording); err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
+
+// Save writes the recording as JSON.
+func (r *Recording) Save(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *Recording) addDNS(record *DNSRecording) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DNS = append(r.DNS, record)
+}
+
+func (r *Recording) addConnection(record *ConnRecording) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Connections = append(r.Connections, record)
+}
+
+// recordingResolver records the answers of another Resolver.
+type recordingResolver struct {
+	Resolver
+	recording *Recording
+}
+
+func (r recordingResolver) add(record *DNSRecording, secure bool, err error) {
+	record.Secure = secure
+	if err != nil {
+		record.Error = err.Error()
+	}
+	r.recording.addDNS(record)
+}
+
+func (r recordingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, bool, error) {
+	mxs, secure, err := r.Resolver.LookupMX(ctx, name)
+	r.add(&DNSRecording{Type: "MX", Name: name, MX: mxs}, secure, err)
+	return mxs, secure, err
+}
+
+func (r recordingResolver) LookupTXT(ctx context.Context, name string) ([]string, bool, error) {
+	txts, secure, err := r.Resolver.LookupTXT(ctx, name)
+	r.add(&DNSRecording{Type: "TXT", Name: name, TXT: txts}, secure, err)
+	return txts, secure, err
+}
+
+func (r recordingResolver) LookupIP(ctx context.Context, name string) ([]net.IP, bool, error) {
+	ips, secure, err := r.Resolver.LookupIP(ctx, name)
+	r.add(&DNSRecording{Type: "IP", Name: name, IP: ips}, secure, err)
+	return ips, secure, err
+}
+
+func (r recordingResolver) LookupTLSA(ctx context.Context, name string) ([]TLSARecord, bool, error) {
+	records, secure, err := r.Resolver.LookupTLSA(ctx, name)
+	r.add(&DNSRecording{Type: "TLSA", Name: name, TLSA: records}, secure, err)
+	return records, secure, err
+}
+
+func (r recordingResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, bool, error) {
+	srvs, secure, err := r.Resolver.LookupSRV(ctx, name)
+	r.add(&DNSRecording{Type: "SRV", Name: name, SRV: srvs}, secure, err)
+	return srvs, secure, err
+}
+
+// recordingNetwork connects to servers over the network, recording what
+// happens on each connection.
+type recordingNetwork struct {
+	recording *Recording
+}
+
+func (n recordingNetwork) dial(ctx context.Context, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := directNetwork{}.dial(ctx, address, timeout)
+	record := &ConnRecording{Address: address}
+	if err != nil {
+		record.DialError = err.Error()
+		record.Unreachable = networkUnreachable(err)
+		n.recording.addConnection(record)
+		return nil, err
+	}
+	n.recording.addConnection(record)
+	return &transcriptConn{Conn: conn, record: record, recording: n.recording}, nil
+}
+
+func (n recordingNetwork) tlsClient(conn net.Conn, config *tls.Config) tlsConn {
+	transcript, ok := innermostConn(conn).(*transcriptConn)
+	if !ok {
+		return tls.Client(conn, config)
+	}
+	return &recordingTLSConn{Conn: tls.Client(conn, config), transcript: transcript}
+}
+
+// innermostConn returns the connection that conn wraps, if any.
+func innermostConn(conn net.Conn) net.Conn {
+	for {
+		wrapper, ok := conn.(wrappedConn)
+		if !ok {
+			return conn
+		}
+		conn = wrapper.unwrap()
+	}
+}
+
+// transcriptConn records the data sent and received on a connection, until
+// a TLS handshake starts. From then on, it keeps the raw data it receives
+// only to recover the key exchange group, and recordingTLSConn records what
+// is sent and received over TLS.
+type transcriptConn struct {
+	net.Conn
+	record    *ConnRecording
+	recording *Recording
+	tls       bool
+	handshake []byte
+}
+
+func (c *transcriptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.recording.mu.Lock()
+	defer c.recording.mu.Unlock()
+	if c.tls {
+		if room := maxRecording - len(c.handshake); n > 0 && room > 0 {
+			if n < room {
+				room = n
+			}
+			c.handshake = append(c.handshake, b[:room]...)
+		}
+		return n, err
+	}
+	c.received(b[:n], err)
+	return n, err
+}
+
+func (c *transcriptConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.recording.mu.Lock()
+	defer c.recording.mu.Unlock()
+	if !c.tls {
+		c.sent(b[:n])
+	}
+	return n, err
+}
+
+// lastEvent returns the last event on the connection, or nil.
+func (c *transcriptConn) lastEvent() *ConnEvent {
+	if len(c.record.Events) == 0 {
+		return nil
+	}
+	return c.record.Events[len(c.record.Events)-1]
+}
+
+// received records data read from the connection, and any read error.
+// Consecutive reads are merged. It's called with c.recording.mu held.
+func (c *transcriptConn) received(data []byte, err error) {
+	if len(data) > 0 {
+		if last := c.lastEvent(); last != nil && last.Received != nil {
+			last.Received = append(last.Received, data...)
+		} else {
+			c.record.Events = append(c.record.Events, &ConnEvent{Received: append(connData{}, data...)})
+		}
+	}
+	if err != nil {
+		event := &ConnEvent{Error: err.Error()}
+		if netErr, ok := err.(net.Error); ok {
+			event.Timeout = netErr.Timeout()
+		}
+		c.record.Events = append(c.record.Events, event)
+	}
+}
+
+// sent records data written to the connection. Consecutive writes are
+// merged. It's called with c.recording.mu held.
+func (c *transcriptConn) sent(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if last := c.lastEvent(); last != nil && last.Sent != nil {
+		last.Sent = append(last.Sent, data...)
+		return
+	}
+	c.record.Events = append(c.record.Events, &ConnEvent{Sent: append(connData{}, data...)})
+}
+
+// recordingTLSConn records a TLS handshake made over a transcriptConn, and
+// what is sent and received over TLS afterwards.
+type recordingTLSConn struct {
+	*tls.Conn
+	transcript *transcriptConn
+	once       sync.Once
+	err        error
+}
+
+func (c *recordingTLSConn) Handshake() error {
+	c.once.Do(func() {
+		c.transcript.recording.mu.Lock()
+		c.transcript.tls = true
+		c.transcript.recording.mu.Unlock()
+		c.err = c.Conn.Handshake()
+		c.transcript.recording.mu.Lock()
+		defer c.transcript.recording.mu.Unlock()
+		record := &TLSRecording{}
+		if c.err != nil {
+			record.Error = c.err.Error()
+		} else {
+			state := c.Conn.ConnectionState()
+			record.Version = state.Version
+			record.CipherSuite = state.CipherSuite
+			record.ALPN = state.NegotiatedProtocol
+			for _, cert := range state.PeerCertificates {
+				record.Certificates = append(record.Certificates, cert.Raw)
+			}
+			record.OCSPResponse = state.OCSPResponse
+			record.SCTs = state.SignedCertificateTimestamps
+			handshake := recordingConn{data: c.transcript.handshake}
+			record.Curve = handshake.curve()
+		}
+		c.transcript.record.Events = append(c.transcript.record.Events, &ConnEvent{TLS: record})
+	})
+	return c.err
+}
+
+func (c *recordingTLSConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	c.transcript.recording.mu.Lock()
+	defer c.transcript.recording.mu.Unlock()
+	c.transcript.received(b[:n], err)
+	return n, err
+}
+
+func (c *recordingTLSConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b)
+	c.transcript.recording.mu.Lock()
+	defer c.transcript.recording.mu.Unlock()
+	c.transcript.sent(b[:n])
+	return n, err
+}
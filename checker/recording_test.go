@@ -0,0 +1,116 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnDataJSON(t *testing.T) {
+	tests := []struct {
+		data connData
+		want string
+	}{
+		{connData("220 localhost ESMTP\r\n"), `"220 localhost ESMTP\r\n"`},
+		{connData{22, 3, 1, 0xff}, `"base64:FgMB/w=="`},
+		{connData("base64:not really"), `"base64:YmFzZTY0Om5vdCByZWFsbHk="`},
+	}
+	for _, test := range tests {
+		b, err := json.Marshal(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != test.want {
+			t.Errorf("expected %q to be marshalled as %s, got %s", test.data, test.want, b)
+		}
+		var data connData
+		if err := json.Unmarshal(b, &data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("expected %s to be unmarshalled as %q, got %q", b, test.data, data)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ln := fakeSMTPServer{config: fakeSMTPConfig(t), vulnerable: true}.listen(t)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(certString))
+	port := ln.Addr().String()[strings.LastIndex(ln.Addr().String(), ":"):]
+
+	recording := NewRecording()
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}, RootCAs: roots, Record: recording}
+	recorded := c.fullCheckHostname(context.Background(), "", "localhost"+port)
+	ln.Close()
+	if len(recording.DNS) == 0 || len(recording.Connections) == 0 {
+		t.Fatalf("expected DNS answers and connections to be recorded, got %+v", recording)
+	}
+
+	var saved bytes.Buffer
+	if err := recording.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadRecording(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server is gone, so this only passes if nothing touches the network.
+	c = Checker{Timeout: testTimeout, RootCAs: roots, Replay: loaded}
+	replayed := c.fullCheckHostname(context.Background(), "", "localhost"+port)
+	if replayed.Checks[STARTTLSInjection].Status != Failure {
+		t.Errorf("expected the replayed server to be vulnerable to STARTTLS injection, got %v", replayed.Checks)
+	}
+	if !reflect.DeepEqual(recorded.Checks, replayed.Checks) {
+		t.Errorf("expected replayed checks %v to match recorded checks %v", replayed.Checks, recorded.Checks)
+	}
+	if !reflect.DeepEqual(recorded.Capabilities, replayed.Capabilities) {
+		t.Errorf("expected replayed capabilities %+v to match recorded capabilities %+v", replayed.Capabilities, recorded.Capabilities)
+	}
+	if !reflect.DeepEqual(recorded.Certificates, replayed.Certificates) {
+		t.Errorf("expected replayed certificates %v to match recorded certificates %v", replayed.Certificates, recorded.Certificates)
+	}
+}
+
+func TestRecordChecksHostnamesOneAtATime(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	check := func(domain, hostname string, _ time.Duration) HostnameResult {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return HostnameResult{Domain: domain, Hostname: hostname, Result: MakeResult("hostnames")}
+	}
+	hostnames := []string{"mx1.example.com", "mx2.example.com", "mx3.example.com"}
+	for _, c := range []Checker{
+		{CheckHostname: check, Concurrency: 4, Record: NewRecording()},
+		{CheckHostname: check, Concurrency: 4, Replay: NewRecording()},
+	} {
+		maxRunning = 0
+		c.checkHostnames(context.Background(), "example.com", hostnames)
+		if maxRunning != 1 {
+			t.Errorf("expected hostnames to be checked one at a time, but %d were checked at once", maxRunning)
+		}
+	}
+}
+
+func TestReplayMissingConnection(t *testing.T) {
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}, Replay: NewRecording()}
+	result := c.checkAddress(context.Background(), "", "localhost", "127.0.0.1:25", nil, false)
+	if result.Checks[Connectivity].Status != Error || !strings.Contains(result.Checks[Connectivity].Messages[0], "no recorded connection") {
+		t.Errorf("expected connecting to fail without a recorded connection, got %v", result.Checks)
+	}
+}
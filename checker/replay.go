@@ -0,0 +1,292 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// replayError is an error that was recorded, returned again.
+type replayError struct {
+	msg         string
+	timeout     bool
+	unreachable bool
+}
+
+func (e *replayError) Error() string   { return e.msg }
+func (e *replayError) Timeout() bool   { return e.timeout }
+func (e *replayError) Temporary() bool { return e.timeout }
+
+func dnsKey(queryType, name string) string {
+	return queryType + " " + name
+}
+
+// nextDNS returns the next recorded answer to a query. Once they have all
+// been replayed, the last one is returned again.
+func (r *Recording) nextDNS(queryType, name string) (*DNSRecording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dnsReplayed == nil {
+		r.dnsReplayed = make(map[string]int)
+	}
+	key := dnsKey(queryType, name)
+	var answers []*DNSRecording
+	for _, record := range r.DNS {
+		if record.Type == queryType && record.Name == name {
+			answers = append(answers, record)
+		}
+	}
+	if len(answers) == 0 {
+		return nil, &replayError{msg: fmt.Sprintf("replay: no recorded %s lookup for %s", queryType, name)}
+	}
+	i := r.dnsReplayed[key]
+	if i >= len(answers) {
+		i = len(answers) - 1
+	}
+	r.dnsReplayed[key]++
+	record := answers[i]
+	if record.Error != "" {
+		return record, &replayError{msg: record.Error}
+	}
+	return record, nil
+}
+
+// nextConnection returns the next recorded connection to address.
+func (r *Recording) nextConnection(address string) *ConnRecording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.connReplayed == nil {
+		r.connReplayed = make(map[string]int)
+	}
+	i := r.connReplayed[address]
+	for _, record := range r.Connections {
+		if record.Address != address {
+			continue
+		}
+		if i == 0 {
+			r.connReplayed[address]++
+			return record
+		}
+		i--
+	}
+	return nil
+}
+
+// replayResolver answers DNS queries from a Recording.
+type replayResolver struct {
+	recording *Recording
+}
+
+func (r replayResolver) LookupMX(_ context.Context, name string) ([]*net.MX, bool, error) {
+	record, err := r.recording.nextDNS("MX", name)
+	if record == nil {
+		return nil, false, err
+	}
+	return record.MX, record.Secure, err
+}
+
+func (r replayResolver) LookupTXT(_ context.Context, name string) ([]string, bool, error) {
+	record, err := r.recording.nextDNS("TXT", name)
+	if record == nil {
+		return nil, false, err
+	}
+	return record.TXT, record.Secure, err
+}
+
+func (r replayResolver) LookupIP(_ context.Context, name string) ([]net.IP, bool, error) {
+	record, err := r.recording.nextDNS("IP", name)
+	if record == nil {
+		return nil, false, err
+	}
+	return record.IP, record.Secure, err
+}
+
+func (r replayResolver) LookupTLSA(_ context.Context, name string) ([]TLSARecord, bool, error) {
+	record, err := r.recording.nextDNS("TLSA", name)
+	if record == nil {
+		return nil, false, err
+	}
+	return record.TLSA, record.Secure, err
+}
+
+func (r replayResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, bool, error) {
+	record, err := r.recording.nextDNS("SRV", name)
+	if record == nil {
+		return nil, false, err
+	}
+	return record.SRV, record.Secure, err
+}
+
+// replayNetwork replays the connections in a Recording, in the order they
+// were made to each address. That order only holds if hostnames are checked
+// one at a time, as they are while recording or replaying.
+type replayNetwork struct {
+	recording *Recording
+}
+
+func (n replayNetwork) dial(_ context.Context, address string, _ time.Duration) (net.Conn, error) {
+	record := n.recording.nextConnection(address)
+	if record == nil {
+		return nil, &replayError{msg: fmt.Sprintf("replay: no recorded connection to %s", address)}
+	}
+	if record.DialError != "" {
+		return nil, &replayError{msg: record.DialError, unreachable: record.Unreachable}
+	}
+	return &replayConn{record: record, events: record.Events}, nil
+}
+
+func (n replayNetwork) tlsClient(conn net.Conn, config *tls.Config) tlsConn {
+	replay, ok := innermostConn(conn).(*replayConn)
+	if !ok {
+		return tls.Client(conn, config)
+	}
+	return &replayTLSConn{Conn: conn, replay: replay}
+}
+
+// replayAddr is the address of both ends of a replayed connection.
+type replayAddr string
+
+func (a replayAddr) Network() string { return "tcp" }
+func (a replayAddr) String() string  { return string(a) }
+
+// replayConn returns the data and errors recorded on a connection, in
+// order, and ignores whatever is written to it.
+type replayConn struct {
+	record *ConnRecording
+	mu     sync.Mutex
+	// events that haven't been replayed yet, and data from the last
+	// received event that hasn't been read.
+	events  []*ConnEvent
+	pending []byte
+	// tls is the last TLS handshake replayed.
+	tls *TLSRecording
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.pending) == 0 {
+		c.skipSent()
+		if len(c.events) == 0 {
+			return 0, io.EOF
+		}
+		event := c.events[0]
+		switch {
+		case event.Received != nil:
+			c.pending = event.Received
+		case event.Error != "":
+			c.events = c.events[1:]
+			return 0, &replayError{msg: event.Error, timeout: event.Timeout}
+		default:
+			return 0, &replayError{msg: "replay: read before the recorded TLS handshake"}
+		}
+		c.events = c.events[1:]
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *replayConn) skipSent() {
+	for len(c.events) > 0 && c.events[0].Sent != nil {
+		c.events = c.events[1:]
+	}
+}
+
+// nextTLS returns the next recorded TLS handshake, or nil if the
+// connection didn't get that far when it was recorded.
+func (c *replayConn) nextTLS() *TLSRecording {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = nil
+	c.skipSent()
+	if len(c.events) == 0 || c.events[0].TLS == nil {
+		return nil
+	}
+	c.tls = c.events[0].TLS
+	c.events = c.events[1:]
+	return c.tls
+}
+
+// curve returns the key exchange group of the last TLS handshake.
+func (c *replayConn) curve() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tls == nil {
+		return ""
+	}
+	return c.tls.Curve
+}
+
+func (c *replayConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return replayAddr("replay") }
+func (c *replayConn) RemoteAddr() net.Addr               { return replayAddr(c.record.Address) }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// replayTLSConn replays a TLS connection over a replayConn.
+type replayTLSConn struct {
+	net.Conn
+	replay *replayConn
+	once   sync.Once
+	state  tls.ConnectionState
+	err    error
+}
+
+func (c *replayTLSConn) Handshake() error {
+	c.once.Do(func() {
+		record := c.replay.nextTLS()
+		switch {
+		case record == nil:
+			c.err = &replayError{msg: "replay: no recorded TLS handshake"}
+		case record.Error != "":
+			c.err = &replayError{msg: record.Error}
+		default:
+			c.state = record.connectionState()
+		}
+	})
+	return c.err
+}
+
+func (c *replayTLSConn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+func (c *replayTLSConn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *replayTLSConn) Write(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// connectionState returns the state of the recorded TLS connection.
+func (r *TLSRecording) connectionState() tls.ConnectionState {
+	state := tls.ConnectionState{
+		Version:                     r.Version,
+		HandshakeComplete:           true,
+		CipherSuite:                 r.CipherSuite,
+		NegotiatedProtocol:          r.ALPN,
+		OCSPResponse:                r.OCSPResponse,
+		SignedCertificateTimestamps: r.SCTs,
+	}
+	for _, der := range r.Certificates {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			state.PeerCertificates = append(state.PeerCertificates, cert)
+		}
+	}
+	return state
+}
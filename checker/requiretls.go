@@ -0,0 +1,61 @@
+package checker
+
+import (
+	"sort"
+	"strings"
+)
+
+// advertisesREQUIRETLS returns true if each of the hostname's addresses that
+// negotiated TLS advertised REQUIRETLS afterwards.
+// https://tools.ietf.org/html/rfc8689#section-4
+func (h HostnameResult) advertisesREQUIRETLS() bool {
+	advertised := false
+	for _, address := range h.Addresses {
+		if address.Capabilities == nil || address.Capabilities.TLS == nil {
+			continue
+		}
+		if !address.Capabilities.RequireTLS {
+			return false
+		}
+		advertised = true
+	}
+	return advertised
+}
+
+// checkDomainREQUIRETLS reports which of a domain's hostnames advertise
+// REQUIRETLS, which senders use to require that a message is only relayed
+// over validated TLS. The result is informational: REQUIRETLS is optional,
+// so it never changes the domain's status. Returns nil if none of the
+// hostnames support STARTTLS.
+func checkDomainREQUIRETLS(domain string, hostnameResults map[string]HostnameResult) *Result {
+	result := MakeResult(REQUIRETLS)
+	hostnames := make([]string, 0, len(hostnameResults))
+	for hostname := range hostnameResults {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	supported, unsupported := []string{}, []string{}
+	for _, hostname := range hostnames {
+		hostnameResult := hostnameResults[hostname]
+		if !hostnameResult.couldSTARTTLS() {
+			continue
+		}
+		if hostnameResult.advertisesREQUIRETLS() {
+			supported = append(supported, hostname)
+		} else {
+			unsupported = append(unsupported, hostname)
+		}
+	}
+	switch {
+	case len(supported) == 0 && len(unsupported) == 0:
+		return nil
+	case len(unsupported) == 0:
+		result.Code("requiretls.all").Info("All of %s's mailservers advertise REQUIRETLS, so it can receive messages sent with REQUIRETLS.", domain)
+	case len(supported) == 0:
+		result.Code("requiretls.none").Info("None of %s's mailservers advertise REQUIRETLS. Messages sent with REQUIRETLS will be returned to their sender.", domain)
+	default:
+		result.Code("requiretls.some").Info("Only some of %s's mailservers advertise REQUIRETLS (%s). Messages sent with REQUIRETLS may be returned to their sender if they're delivered to the others (%s).",
+			domain, strings.Join(supported, ", "), strings.Join(unsupported, ", "))
+	}
+	return result.Success()
+}
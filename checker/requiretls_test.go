@@ -0,0 +1,51 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+)
+
+func requireTLSHostname(advertised ...bool) HostnameResult {
+	h := HostnameResult{Result: MakeResult("hostnames")}
+	for _, requireTLS := range advertised {
+		address := addressResult("192.0.2.1", MakeResult(Connectivity), MakeResult(STARTTLS))
+		address.Capabilities = &SMTPCapabilities{RequireTLS: requireTLS, TLS: &NegotiatedTLS{}}
+		h.Addresses = append(h.Addresses, address)
+	}
+	h.aggregateAddresses()
+	return h
+}
+
+func TestCheckDomainREQUIRETLS(t *testing.T) {
+	noSTARTTLS := HostnameResult{Result: MakeResult("hostnames")}
+	noSTARTTLS.addCheck(MakeResult(Connectivity))
+	noSTARTTLS.addCheck(MakeResult(STARTTLS).Failure("Server does not advertise support for STARTTLS."))
+	tests := []struct {
+		hostnames map[string]HostnameResult
+		message   string
+	}{
+		{map[string]HostnameResult{"mx1": noSTARTTLS}, ""},
+		{map[string]HostnameResult{"mx1": requireTLSHostname(true), "mx2": requireTLSHostname(true, true), "mx3": noSTARTTLS},
+			"All of example.com's mailservers"},
+		{map[string]HostnameResult{"mx1": requireTLSHostname(false), "mx2": requireTLSHostname(true, false)},
+			"None of example.com's mailservers"},
+		{map[string]HostnameResult{"mx1": requireTLSHostname(true), "mx2": requireTLSHostname(false)},
+			"(mx1). Messages sent with REQUIRETLS may be returned to their sender if they're delivered to the others (mx2)"},
+	}
+	for _, test := range tests {
+		result := checkDomainREQUIRETLS("example.com", test.hostnames)
+		if test.message == "" {
+			if result != nil {
+				t.Errorf("expected no result without STARTTLS, got %v", result)
+			}
+			continue
+		}
+		if result == nil || result.Status != Success {
+			t.Fatalf("expected an informational result, got %v", result)
+		}
+		if len(result.Messages) != 1 || !strings.HasPrefix(result.Messages[0], "Info: ") ||
+			!strings.Contains(result.Messages[0], test.message) {
+			t.Errorf("expected message containing %q, got %v", test.message, result.Messages)
+		}
+	}
+}
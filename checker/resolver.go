@@ -0,0 +1,267 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver performs the DNS lookups needed to check a domain.
+// Each lookup also reports whether the answer was authenticated with DNSSEC.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, bool, error)
+	LookupTXT(ctx context.Context, name string) ([]string, bool, error)
+	// LookupIP returns both the IPv4 and IPv6 addresses of a host.
+	LookupIP(ctx context.Context, name string) ([]net.IP, bool, error)
+	LookupTLSA(ctx context.Context, name string) ([]TLSARecord, bool, error)
+	// LookupSRV returns the SRV records for name, such as
+	// "_submission._tcp.example.com".
+	LookupSRV(ctx context.Context, name string) ([]*net.SRV, bool, error)
+}
+
+// SystemResolver is the default Resolver. It looks names up like the rest of
+// the system, with net.Resolver, which can't tell whether answers were
+// authenticated with DNSSEC, so none of them are reported as authenticated.
+// net.Resolver doesn't support TLSA records either: those are looked up like
+// a DNSResolver does, so that DANE can be checked.
+type SystemResolver struct {
+	net.Resolver
+}
+
+// LookupMX returns the MX records for name, sorted by preference.
+func (r *SystemResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, bool, error) {
+	mxs, err := r.Resolver.LookupMX(ctx, name)
+	return mxs, false, err
+}
+
+// LookupTXT returns the TXT records for name.
+func (r *SystemResolver) LookupTXT(ctx context.Context, name string) ([]string, bool, error) {
+	txts, err := r.Resolver.LookupTXT(ctx, name)
+	return txts, false, err
+}
+
+// LookupIP returns the IPv4 and IPv6 addresses of name.
+func (r *SystemResolver) LookupIP(ctx context.Context, name string) ([]net.IP, bool, error) {
+	addrs, err := r.Resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, false, err
+	}
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, false, nil
+}
+
+// LookupTLSA returns the TLSA records for name, using a DNSResolver.
+func (r *SystemResolver) LookupTLSA(ctx context.Context, name string) ([]TLSARecord, bool, error) {
+	return (&DNSResolver{}).LookupTLSA(ctx, name)
+}
+
+// LookupSRV returns the SRV records for name, sorted by priority and
+// randomized by weight.
+func (r *SystemResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, bool, error) {
+	_, srvs, err := r.Resolver.LookupSRV(ctx, "", "", name)
+	return srvs, false, err
+}
+
+// DNSResolver sends queries to a single upstream nameserver, and trusts the
+// upstream's DNSSEC validation: answers are authenticated iff the upstream
+// sets the AD bit in its response. Checkers use it when $DNS_RESOLVER is set.
+type DNSResolver struct {
+	// Server is the address of the upstream nameserver, such as "127.0.0.1:53".
+	// If empty, $DNS_RESOLVER is used, or else the first nameserver in
+	// /etc/resolv.conf.
+	Server string
+}
+
+func (r *DNSResolver) server() (string, error) {
+	server := r.Server
+	if server == "" {
+		server = os.Getenv("DNS_RESOLVER")
+	}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return net.JoinHostPort(server, "53"), nil
+		}
+		return server, nil
+	}
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	if len(config.Servers) == 0 {
+		return "", fmt.Errorf("no nameservers configured in /etc/resolv.conf")
+	}
+	return net.JoinHostPort(config.Servers[0], config.Port), nil
+}
+
+// errNoSuchHost is the message of the errors that net.Resolver and
+// DNSResolver return when a name has no records of the type looked up.
+const errNoSuchHost = "no such host"
+
+// isNotFound reports whether err means that the name looked up has no
+// records of that type, rather than that the lookup failed. It compares the
+// message rather than IsNotFound, which was only added in Go 1.13.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.Err == errNoSuchHost
+}
+
+// exchange queries the upstream for records of type qtype at name. Like
+// with net.Resolver, both NXDOMAIN and an answer without any records of
+// type qtype are returned as a "no such host" net.DNSError whose IsNotFound
+// is set.
+func (r *DNSResolver) exchange(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+	server, err := r.server()
+	if err != nil {
+		return nil, false, err
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, true)
+	// Ask the upstream to tell us whether it validated the answer.
+	// https://tools.ietf.org/html/rfc6840#section-5.7
+	msg.AuthenticatedData = true
+	client := dns.Client{}
+	resp, _, err := client.ExchangeContext(ctx, msg, server)
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, server)
+	}
+	if err != nil {
+		return nil, false, &net.DNSError{Err: err.Error(), Name: name, Server: server}
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		for _, rr := range resp.Answer {
+			if rr.Header().Rrtype == qtype {
+				return resp.Answer, resp.AuthenticatedData, nil
+			}
+		}
+		return nil, resp.AuthenticatedData, notFoundError(name, server)
+	case dns.RcodeNameError:
+		return nil, resp.AuthenticatedData, notFoundError(name, server)
+	default:
+		return nil, false, &net.DNSError{Err: "server returned " + dns.RcodeToString[resp.Rcode], Name: name, Server: server}
+	}
+}
+
+// LookupMX returns the MX records for name, sorted by preference.
+func (r *DNSResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, bool, error) {
+	answer, secure, err := r.exchange(ctx, name, dns.TypeMX)
+	if err != nil {
+		return nil, secure, err
+	}
+	mxs := []*net.MX{}
+	for _, rr := range answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			mxs = append(mxs, &net.MX{Host: mx.Mx, Pref: mx.Preference})
+		}
+	}
+	sort.SliceStable(mxs, func(i, j int) bool { return mxs[i].Pref < mxs[j].Pref })
+	return mxs, secure, nil
+}
+
+// LookupTXT returns the TXT records for name. Like net.LookupTXT, the
+// character-strings of each record are concatenated.
+func (r *DNSResolver) LookupTXT(ctx context.Context, name string) ([]string, bool, error) {
+	answer, secure, err := r.exchange(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, secure, err
+	}
+	txts := []string{}
+	for _, rr := range answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return txts, secure, nil
+}
+
+// LookupIP returns the A and AAAA records for name. The answer is only
+// authenticated if both lookups were authenticated. If one of the lookups
+// fails, like the AAAA lookups of some broken nameservers, the addresses
+// found by the other are still returned.
+func (r *DNSResolver) LookupIP(ctx context.Context, name string) ([]net.IP, bool, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, false, nil
+	}
+	ips := []net.IP{}
+	secure := true
+	var lookupErr, notFoundErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		answer, qsecure, err := r.exchange(ctx, name, qtype)
+		if err != nil && !isNotFound(err) {
+			lookupErr = err
+			secure = false
+			continue
+		}
+		if err != nil {
+			notFoundErr = err
+		}
+		secure = secure && qsecure
+		for _, rr := range answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A)
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA)
+			}
+		}
+	}
+	if lookupErr != nil && len(ips) == 0 {
+		return nil, false, lookupErr
+	}
+	if len(ips) == 0 {
+		return nil, secure, notFoundErr
+	}
+	return ips, secure, nil
+}
+
+// LookupTLSA returns the TLSA records for name.
+func (r *DNSResolver) LookupTLSA(ctx context.Context, name string) ([]TLSARecord, bool, error) {
+	answer, secure, err := r.exchange(ctx, name, dns.TypeTLSA)
+	if err != nil {
+		return nil, secure, err
+	}
+	records := []TLSARecord{}
+	for _, rr := range answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok {
+			records = append(records, TLSARecord{
+				Usage:        tlsa.Usage,
+				Selector:     tlsa.Selector,
+				MatchingType: tlsa.MatchingType,
+				Certificate:  strings.ToLower(tlsa.Certificate),
+			})
+		}
+	}
+	return records, secure, nil
+}
+
+// LookupSRV returns the SRV records for name, sorted by priority and then
+// by descending weight.
+func (r *DNSResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, bool, error) {
+	answer, secure, err := r.exchange(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil, secure, err
+	}
+	srvs := []*net.SRV{}
+	for _, rr := range answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			srvs = append(srvs, &net.SRV{Target: srv.Target, Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+		}
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight > srvs[j].Weight
+	})
+	return srvs, secure, nil
+}
@@ -0,0 +1,199 @@
+package checker
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// stubNameserver serves the given resource records from a local UDP
+// nameserver. Answers for names under "signed.example." have the AD bit set,
+// and AAAA queries for names under "broken-aaaa.example." fail.
+func stubNameserver(t *testing.T, records []string) *dns.Server {
+	zone := []dns.RR{}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zone = append(zone, rr)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		q := req.Question[0]
+		exists := false
+		for _, rr := range zone {
+			if !strings.EqualFold(rr.Header().Name, q.Name) {
+				continue
+			}
+			exists = true
+			if rr.Header().Rrtype == q.Qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		if !exists {
+			resp.Rcode = dns.RcodeNameError
+		}
+		if q.Qtype == dns.TypeAAAA && strings.HasSuffix(q.Name, "broken-aaaa.example.") {
+			resp.Rcode = dns.RcodeServerFailure
+		}
+		resp.AuthenticatedData = strings.HasSuffix(q.Name, "signed.example.")
+		w.WriteMsg(resp)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return server
+}
+
+var stubZone = []string{
+	"signed.example. 300 IN MX 20 mx2.signed.example.",
+	"signed.example. 300 IN MX 10 mx1.signed.example.",
+	"_mta-sts.signed.example. 300 IN TXT \"v=STSv1; \" \"id=1234\"",
+	"mx1.signed.example. 300 IN A 192.0.2.1",
+	"mx1.signed.example. 300 IN AAAA 2001:db8::1",
+	"_25._tcp.mx1.signed.example. 300 IN TLSA 3 1 1 ABCDEF",
+	"mx3.signed.example. 300 IN A 192.0.2.3",
+	"unsigned.test. 300 IN MX 10 mx.unsigned.test.",
+	"mx.broken-aaaa.example. 300 IN A 192.0.2.2",
+}
+
+func TestDNSResolver(t *testing.T) {
+	server := stubNameserver(t, stubZone)
+	defer server.Shutdown()
+	r := &DNSResolver{Server: server.PacketConn.LocalAddr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	mxs, secure, err := r.LookupMX(ctx, "signed.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secure {
+		t.Error("expected MX lookup for signed.example to be authenticated")
+	}
+	if len(mxs) != 2 || mxs[0].Host != "mx1.signed.example." || mxs[0].Pref != 10 {
+		t.Errorf("expected MXs sorted by preference, got %v %v", mxs[0], mxs[1])
+	}
+
+	if _, secure, _ = r.LookupMX(ctx, "unsigned.test"); secure {
+		t.Error("expected MX lookup for unsigned.test not to be authenticated")
+	}
+
+	txts, _, err := r.LookupTXT(ctx, "_mta-sts.signed.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txts) != 1 || txts[0] != "v=STSv1; id=1234" {
+		t.Errorf("expected TXT strings to be concatenated, got %q", txts)
+	}
+
+	ips, _, err := r.LookupIP(ctx, "mx1.signed.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 {
+		t.Errorf("expected IPv4 and IPv6 addresses, got %v", ips)
+	}
+
+	ips, _, err = r.LookupIP(ctx, "mx.broken-aaaa.example")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.2")) {
+		t.Errorf("expected the IPv4 address despite the failed AAAA lookup, got %v, %v", ips, err)
+	}
+	if _, _, err = r.LookupIP(ctx, "nonexistent.test"); err == nil {
+		t.Error("expected an error when neither lookup succeeds")
+	}
+
+	tlsa, _, err := r.LookupTLSA(ctx, "_25._tcp.mx1.signed.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsa) != 1 || tlsa[0].String() != "3 1 1 abcdef" {
+		t.Errorf("unexpected TLSA records %v", tlsa)
+	}
+
+	_, _, err = r.LookupMX(ctx, "nonexistent.test")
+	if dnsErr, ok := err.(*net.DNSError); !ok || dnsErr.Err != "no such host" {
+		t.Errorf("expected not found error for NXDOMAIN, got %v", err)
+	}
+	if !isNotFound(err) {
+		t.Errorf("expected NXDOMAIN to be reported as not found, got %v", err)
+	}
+
+	// signed.example exists, but doesn't have any TXT records.
+	txts, secure, err = r.LookupTXT(ctx, "signed.example")
+	if !isNotFound(err) || len(txts) != 0 {
+		t.Errorf("expected not found error for an empty answer, got %v, %v", txts, err)
+	}
+	if !secure {
+		t.Error("expected the authenticated empty answer to be reported as authenticated")
+	}
+	// A missing AAAA record doesn't make the A record any less authenticated.
+	ips, secure, err = r.LookupIP(ctx, "mx3.signed.example")
+	if err != nil || len(ips) != 1 || !secure {
+		t.Errorf("expected an authenticated IPv4 address, got %v, %v, %v", ips, secure, err)
+	}
+	if _, _, err = r.LookupIP(ctx, "_mta-sts.signed.example"); !isNotFound(err) {
+		t.Errorf("expected not found error for a name without addresses, got %v", err)
+	}
+}
+
+func TestSystemResolver(t *testing.T) {
+	r := &SystemResolver{}
+	ips, secure, err := r.LookupIP(context.Background(), "127.0.0.1")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expected IP literals to be returned as-is, got %v, %v", ips, err)
+	}
+	if secure {
+		t.Error("expected the system resolver never to report answers as authenticated")
+	}
+	if _, ok := (&Checker{}).resolver().(*SystemResolver); !ok && os.Getenv("DNS_RESOLVER") == "" {
+		t.Errorf("expected the system resolver to be the default, got %T", (&Checker{}).resolver())
+	}
+}
+
+func TestCheckDomainDNSSEC(t *testing.T) {
+	server := stubNameserver(t, stubZone)
+	defer server.Shutdown()
+	resolver := &DNSResolver{Server: server.PacketConn.LocalAddr().String()}
+	c := Checker{
+		Timeout:       time.Second,
+		Resolver:      resolver,
+		CheckHostname: mockCheckHostname,
+		// Only check the MTA-STS record, rather than fetching the policy.
+		checkMTASTSOverride: func(domain string, _ map[string]HostnameResult) *MTASTSResult {
+			result := MakeMTASTSResult()
+			recordResult, id, secure := checkMTASTSRecord(context.Background(), resolver, domain, time.Second)
+			result.addCheck(recordResult)
+			result.RecordID = id
+			result.RecordDNSSEC = secure
+			return result
+		},
+	}
+	result := c.CheckDomain("signed.example", nil)
+	if !result.MXDNSSEC {
+		t.Error("expected MX records for signed.example to be authenticated")
+	}
+	if !result.MTASTSResult.RecordDNSSEC {
+		t.Error("expected MTA-STS record for signed.example to be authenticated")
+	}
+	if !result.MTASTSResult.subcheckSucceeded(MTASTSText) {
+		t.Errorf("expected MTA-STS record check to succeed, got %v", result.MTASTSResult.Checks[MTASTSText])
+	}
+
+	result = c.CheckDomain("unsigned.test", nil)
+	if result.MXDNSSEC {
+		t.Error("expected MX records for unsigned.test not to be authenticated")
+	}
+}
@@ -3,6 +3,7 @@ package checker
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 // Status is an enum encoding the status of the overall check.
@@ -46,6 +47,8 @@ type Result struct {
 	Status   Status             `json:"status"`
 	Messages []string           `json:"messages,omitempty"`
 	Checks   map[string]*Result `json:"checks,omitempty"`
+	// Details[i] is the machine-readable form of Messages[i].
+	Details []MessageDetail `json:"details,omitempty"`
 }
 
 // MakeResult constructs a base result object and returns its pointer.
@@ -62,28 +65,93 @@ func MakeResult(name string) *Result {
 // The Error status will override any other existing status for this check.
 // Typically, when a check encounters an error, it stops executing.
 func (r *Result) Error(format string, a ...interface{}) *Result {
-	r.Status = SetStatus(r.Status, Error)
-	r.Messages = append(r.Messages, fmt.Sprintf("Error: "+format, a...))
-	return r
+	return r.add(Error, "error", "", format, a)
 }
 
 // Failure adds a failure message to this check result.
 // The Failure status will override any Status other than Error.
 // Whenever Failure is called, the entire check is failed.
 func (r *Result) Failure(format string, a ...interface{}) *Result {
-	r.Status = SetStatus(r.Status, Failure)
-	r.Messages = append(r.Messages, fmt.Sprintf("Failure: "+format, a...))
-	return r
+	return r.add(Failure, "failure", "", format, a)
 }
 
 // Warning adds a warning message to this check result.
 // The Warning status only supercedes the Success status.
 func (r *Result) Warning(format string, a ...interface{}) *Result {
-	r.Status = SetStatus(r.Status, Warning)
-	r.Messages = append(r.Messages, fmt.Sprintf("Warning: "+format, a...))
+	return r.add(Warning, "warning", "", format, a)
+}
+
+// Info adds an informational message to this check result, without changing
+// its status.
+func (r *Result) Info(format string, a ...interface{}) *Result {
+	return r.add(Success, "info", "", format, a)
+}
+
+// Code returns a CodedResult that adds messages with code to this check
+// result, e.g. r.Code("cert.hostname_mismatch").Failure(...). The arguments
+// to the message are reported as its params, named by the code's entry in
+// MessageCodes.
+func (r *Result) Code(code string) CodedResult {
+	return CodedResult{result: r, code: code}
+}
+
+// CodedResult adds messages with a code to a Result.
+type CodedResult struct {
+	result *Result
+	code   string
+}
+
+// Error adds an error message with c's code to its result.
+func (c CodedResult) Error(format string, a ...interface{}) *Result {
+	return c.result.add(Error, "error", c.code, format, a)
+}
+
+// Failure adds a failure message with c's code to its result.
+func (c CodedResult) Failure(format string, a ...interface{}) *Result {
+	return c.result.add(Failure, "failure", c.code, format, a)
+}
+
+// Warning adds a warning message with c's code to its result.
+func (c CodedResult) Warning(format string, a ...interface{}) *Result {
+	return c.result.add(Warning, "warning", c.code, format, a)
+}
+
+// Info adds an informational message with c's code to its result.
+func (c CodedResult) Info(format string, a ...interface{}) *Result {
+	return c.result.add(Success, "info", c.code, format, a)
+}
+
+// add appends a message and its details to r. Messages without a code are
+// given "<check>.<level>".
+func (r *Result) add(status Status, level string, code string, format string, a []interface{}) *Result {
+	r.Status = SetStatus(r.Status, status)
+	prefix := strings.ToUpper(level[:1]) + level[1:] + ": "
+	r.Messages = append(r.Messages, fmt.Sprintf(prefix+format, a...))
+	detail := MessageDetail{Code: code, Level: level}
+	if code == "" {
+		detail.Code = r.Name + "." + level
+	} else {
+		detail.Params = messageParams(code, a)
+	}
+	r.Details = append(r.Details, detail)
 	return r
 }
 
+// detail returns the details of the i-th message of r. Messages of results
+// that were stored before messages had details are given "<check>.<level>",
+// like other messages without a code.
+func (r *Result) detail(i int) MessageDetail {
+	if i < len(r.Details) {
+		return r.Details[i]
+	}
+	for _, level := range []string{"error", "failure", "warning", "info"} {
+		if strings.HasPrefix(strings.ToLower(r.Messages[i]), level+": ") {
+			return MessageDetail{Code: r.Name + "." + level, Level: level}
+		}
+	}
+	return MessageDetail{Code: r.Name + ".unknown"}
+}
+
 // Success simply sets the status of Result to a Success.
 // Status is set if no other status has been declared on this check.
 func (r *Result) Success() *Result {
@@ -109,31 +177,37 @@ func (r *Result) addCheck(checkResult *Result) {
 
 // IDs for checks that can be run
 const (
-	Connectivity     = "connectivity"
-	STARTTLS         = "starttls"
-	Version          = "version"
-	Certificate      = "certificate"
-	MTASTS           = "mta-sts"
-	MTASTSText       = "mta-sts-text"
-	MTASTSPolicyFile = "mta-sts-policy-file"
-	PolicyList       = "policylist"
+	Connectivity             = "connectivity"
+	STARTTLS                 = "starttls"
+	STARTTLSInjection        = "starttls-injection"
+	ImplicitTLS              = "implicit-tls"
+	Version                  = "version"
+	Certificate              = "certificate"
+	DANE                     = "dane"
+	TLSEnumeration           = "tls-enumeration"
+	MTASTS                   = "mta-sts"
+	MTASTSText               = "mta-sts-text"
+	MTASTSPolicyFile         = "mta-sts-policy-file"
+	MTASTSPolicyHostDNS      = "mta-sts-policy-host-dns"
+	MTASTSPolicyHostTLS      = "mta-sts-policy-host-tls"
+	MTASTSPolicyRedirect     = "mta-sts-policy-redirect"
+	MTASTSPolicyHTTPStatus   = "mta-sts-policy-http-status"
+	MTASTSPolicyContentType  = "mta-sts-policy-content-type"
+	MTASTSPolicyResponseTime = "mta-sts-policy-response-time"
+	MTASTSConsistency        = "mta-sts-consistency"
+	PolicyList               = "policylist"
+	TLSRPT                   = "tls-rpt"
+	BackupMX                 = "backup-mx"
+	REQUIRETLS               = "requiretls"
+	SubmissionSRV            = "submission-srv"
 )
 
-// Text descriptions of checks that can be run
-var checkNames = map[string]string{
-	Connectivity:     "Server connectivity",
-	STARTTLS:         "Support for inbound STARTTLS",
-	Version:          "Secure version of TLS",
-	Certificate:      "Valid certificate",
-	MTASTS:           "Inbound MTA-STS support",
-	MTASTSText:       "Correct MTA-STS DNS record",
-	MTASTSPolicyFile: "Correct MTA-STS policy file",
-	PolicyList:       "Status on EFF's STARTTLS Everywhere policy list",
-}
-
 // Description returns the full-text name of a check.
 func (r Result) Description() string {
-	return checkNames[r.Name]
+	if check, ok := checkIndex[r.Name]; ok {
+		return check.Description
+	}
+	return ""
 }
 
 // MarshalJSON writes Result to JSON. It adds status_text and description to
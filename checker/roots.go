@@ -0,0 +1,21 @@
+package checker
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadRootCAs reads a bundle of PEM-encoded root certificates from path,
+// for use as a Checker's RootCAs.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRootCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "bundle.pem")
+	ioutil.WriteFile(bundle, []byte(certString), 0600)
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{bundle, false},
+		{empty, true},
+		{filepath.Join(dir, "missing.pem"), true},
+	}
+	for _, test := range tests {
+		pool, err := LoadRootCAs(test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("LoadRootCAs(%s): expected error %t, got %v", test.path, test.wantErr, err)
+		}
+		if err == nil && pool == nil {
+			t.Errorf("LoadRootCAs(%s): expected a pool", test.path)
+		}
+	}
+}
+
+func TestTrustStore(t *testing.T) {
+	tests := []struct {
+		c    Checker
+		want string
+	}{
+		{Checker{}, "system"},
+		{Checker{RootCAs: x509.NewCertPool()}, "custom"},
+		{Checker{RootCAs: x509.NewCertPool(), TrustStore: "/etc/corp-ca.pem"}, "/etc/corp-ca.pem"},
+	}
+	for _, test := range tests {
+		if got := test.c.trustStore(); got != test.want {
+			t.Errorf("expected trust store %q, got %q", test.want, got)
+		}
+	}
+}
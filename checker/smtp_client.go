@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// smtpClient is a minimal SMTP client, like net/smtp's Client, whose TLS
+// connections are made by a network, so that they can be recorded and
+// replayed.
+type smtpClient struct {
+	// Text is the textproto.Conn used by the client.
+	Text      *textproto.Conn
+	conn      net.Conn
+	network   network
+	tls       tlsConn
+	localName string
+	// Extensions advertised in response to the last EHLO, keyed by keyword,
+	// and as listed by parseEHLO.
+	ext        map[string]string
+	extensions []string
+	// startingTLS, if set, is called once the server accepts STARTTLS,
+	// before the TLS handshake begins.
+	startingTLS func()
+}
+
+// newSMTPClient returns a client using conn, once it has read the server's
+// greeting.
+func newSMTPClient(conn net.Conn, network network) (*smtpClient, error) {
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		text.Close()
+		return nil, err
+	}
+	return &smtpClient{Text: text, conn: conn, network: network}, nil
+}
+
+// cmd sends a command and returns the server's response.
+func (c *smtpClient) cmd(expectCode int, format string, args ...interface{}) (int, string, error) {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	return c.Text.ReadResponse(expectCode)
+}
+
+// Hello sends EHLO, or HELO if the server doesn't accept EHLO, announcing
+// localName.
+func (c *smtpClient) Hello(localName string) error {
+	c.localName = localName
+	if err := c.ehlo(); err != nil {
+		_, _, err = c.cmd(250, "HELO %s", localName)
+		return err
+	}
+	return nil
+}
+
+func (c *smtpClient) ehlo() error {
+	_, msg, err := c.cmd(250, "EHLO %s", c.localName)
+	if err != nil {
+		return err
+	}
+	ext := make(map[string]string)
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		args := strings.SplitN(line, " ", 2)
+		if len(args) > 1 {
+			ext[args[0]] = args[1]
+		} else {
+			ext[args[0]] = ""
+		}
+	}
+	c.ext = ext
+	c.extensions = parseEHLO(msg)
+	return nil
+}
+
+// Extension reports whether the server advertised an extension, and its
+// parameters.
+func (c *smtpClient) Extension(ext string) (bool, string) {
+	param, ok := c.ext[strings.ToUpper(ext)]
+	return ok, param
+}
+
+// StartTLS sends STARTTLS, negotiates TLS using config, and sends EHLO
+// again.
+func (c *smtpClient) StartTLS(config *tls.Config) error {
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+	if c.startingTLS != nil {
+		c.startingTLS()
+	}
+	c.tls = c.network.tlsClient(c.conn, config)
+	c.conn = c.tls
+	c.Text = textproto.NewConn(c.conn)
+	if err := c.tls.Handshake(); err != nil {
+		return err
+	}
+	return c.ehlo()
+}
+
+// TLSConnectionState returns the state of the TLS connection, if STARTTLS
+// was attempted.
+func (c *smtpClient) TLSConnectionState() (tls.ConnectionState, bool) {
+	if c.tls == nil {
+		return tls.ConnectionState{}, false
+	}
+	return c.tls.ConnectionState(), true
+}
+
+// Close closes the connection.
+func (c *smtpClient) Close() error {
+	return c.Text.Close()
+}
@@ -0,0 +1,116 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ehlo sends EHLO over text, and returns the extensions the server
+// advertised.
+func ehlo(text *textproto.Conn) ([]string, error) {
+	id, err := text.Cmd("EHLO %s", getThisHostname())
+	if err != nil {
+		return nil, err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, msg, err := text.ReadResponse(250)
+	if err != nil {
+		return nil, err
+	}
+	return parseEHLO(msg), nil
+}
+
+// parseEHLO returns the extensions listed in the text of an EHLO response,
+// with their keywords in upper case and followed by their parameters.
+func parseEHLO(msg string) []string {
+	extensions := []string{}
+	// The first line of the response is the server's greeting.
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			fields[0] = strings.ToUpper(fields[0])
+			extensions = append(extensions, strings.Join(fields, " "))
+		}
+	}
+	return extensions
+}
+
+func hasExtension(extensions []string, name string) bool {
+	for _, extension := range extensions {
+		if strings.Fields(extension)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// injectionWait is how long we wait, after the TLS handshake, for the server
+// to answer a command that was injected before it.
+const injectionWait = time.Second
+
+// bufferedConn is a connection whose reads start with data that was already
+// buffered by r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// checkSTARTTLSInjection checks whether the server executes commands that
+// were sent in plaintext, in the same packet as STARTTLS, once TLS has been
+// negotiated. A man-in-the-middle could use this to inject commands into
+// the encrypted session (CVE-2011-0411).
+func (c *Checker) checkSTARTTLSInjection(ctx context.Context, address string) *Result {
+	result := MakeResult(STARTTLSInjection)
+	timeout := c.timeout()
+	network := c.network()
+	conn, err := network.dial(ctx, address, timeout)
+	if err != nil {
+		return result.Code("smtp.connection_failed").Error("Could not establish connection: %v", err)
+	}
+	conn = newContextConn(ctx, conn)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return result.Code("smtp.no_greeting").Error("Server didn't greet us: %v", err)
+	}
+	if _, err := ehlo(text); err != nil {
+		return result.Code("smtp.ehlo_refused").Error("Server didn't accept EHLO: %v", err)
+	}
+
+	// Send both commands in a single write, so that they arrive together.
+	if _, err := conn.Write([]byte("STARTTLS\r\nRSET\r\n")); err != nil {
+		return result.Code("starttls.send_failed").Error("Could not send STARTTLS: %v", err)
+	}
+	if _, _, err := text.ReadResponse(220); err != nil {
+		// The server refused the pipelined STARTTLS, which is safe.
+		return result.Success()
+	}
+	if text.R.Buffered() > 0 {
+		return result.Code("starttls_injection.answered_before_tls").Failure("Server answered a command sent after STARTTLS before starting TLS.")
+	}
+	tlsConn := network.tlsClient(bufferedConn{conn, text.R}, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
+	if err := tlsConn.Handshake(); err != nil {
+		return result.Code("tls.handshake_failed").Error("Could not complete a TLS handshake: %v", err)
+	}
+	wait := injectionWait
+	if timeout < wait {
+		wait = timeout
+	}
+	tlsConn.SetReadDeadline(time.Now().Add(wait))
+	line, err := textproto.NewReader(bufio.NewReader(tlsConn)).ReadLine()
+	if err == nil {
+		return result.Code("starttls_injection.vulnerable").Failure("Server answered a command that we sent in plaintext along with STARTTLS (%q) after the TLS handshake. "+
+			"An attacker can use this to inject commands into encrypted SMTP sessions (CVE-2011-0411).", line)
+	}
+	return result.Success()
+}
@@ -0,0 +1,165 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer is a minimal SMTP server that can misbehave around
+// STARTTLS in ways mhale/smtpd doesn't.
+type fakeSMTPServer struct {
+	config *tls.Config
+	// Answer commands that were buffered along with STARTTLS once TLS is
+	// negotiated, like servers vulnerable to CVE-2011-0411.
+	vulnerable bool
+	// Only advertise STARTTLS in response to the second EHLO.
+	hideSTARTTLS bool
+	// Don't advertise any extensions after STARTTLS.
+	dropExtensions bool
+	// Extensions to advertise after STARTTLS, instead of just SIZE.
+	tlsExtensions []string
+	// Reject EHLO after the first one sent over TLS.
+	oneTLSEHLO bool
+}
+
+func (s fakeSMTPServer) listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return ln
+}
+
+func (s fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var rw net.Conn = conn
+	r := bufio.NewReader(conn)
+	rw.Write([]byte("220 localhost ESMTP\r\n"))
+	ehlos, tlsEHLOs, secure := 0, 0, false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch verb {
+		case "EHLO":
+			ehlos++
+			if secure {
+				tlsEHLOs++
+			}
+			switch {
+			case s.oneTLSEHLO && tlsEHLOs > 1:
+				rw.Write([]byte("503 Duplicate EHLO\r\n"))
+			case secure && s.dropExtensions:
+				rw.Write([]byte("250 localhost\r\n"))
+			case secure && len(s.tlsExtensions) > 0:
+				rw.Write([]byte("250-localhost\r\n250-" + strings.Join(s.tlsExtensions, "\r\n250-") + "\r\n250 SIZE 1000\r\n"))
+			case secure || (s.hideSTARTTLS && ehlos < 2):
+				rw.Write([]byte("250-localhost\r\n250 SIZE 1000\r\n"))
+			default:
+				rw.Write([]byte("250-localhost\r\n250 STARTTLS\r\n"))
+			}
+		case "STARTTLS":
+			rw.Write([]byte("220 Ready to start TLS\r\n"))
+			tlsConn := tls.Server(conn, s.config)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			// Commands left over in the plaintext buffer are answered over
+			// TLS by vulnerable servers, and discarded by the rest.
+			if s.vulnerable && r.Buffered() > 0 {
+				r.ReadString('\n')
+				tlsConn.Write([]byte("250 OK\r\n"))
+			}
+			rw, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "QUIT":
+			rw.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			rw.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
+
+func fakeSMTPConfig(t *testing.T) *tls.Config {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestSTARTTLSInjection(t *testing.T) {
+	tests := []struct {
+		vulnerable bool
+		want       Status
+	}{
+		{false, Success},
+		{true, Failure},
+	}
+	c := Checker{Timeout: testTimeout}
+	for _, test := range tests {
+		ln := fakeSMTPServer{config: fakeSMTPConfig(t), vulnerable: test.vulnerable}.listen(t)
+		result := c.checkSTARTTLSInjection(context.Background(), ln.Addr().String())
+		ln.Close()
+		if result.Status != test.want {
+			t.Errorf("vulnerable = %t: expected status %d, got %v", test.vulnerable, test.want, result)
+		}
+	}
+}
+
+func TestSTARTTLSInjectionWithSMTPD(t *testing.T) {
+	ln := smtpListenAndServe(t, fakeSMTPConfig(t))
+	defer ln.Close()
+	c := Checker{Timeout: testTimeout}
+	result := c.checkSTARTTLSInjection(context.Background(), ln.Addr().String())
+	if result.Status != Success {
+		t.Errorf("expected smtpd not to be vulnerable, got %v", result)
+	}
+}
+
+func TestSTARTTLSEHLOProblems(t *testing.T) {
+	tests := []struct {
+		server  fakeSMTPServer
+		want    Status
+		message string
+	}{
+		{fakeSMTPServer{}, Success, ""},
+		{fakeSMTPServer{hideSTARTTLS: true}, Warning, "EHLO a second time"},
+		{fakeSMTPServer{dropExtensions: true}, Warning, "any SMTP extensions"},
+		// EHLO is only sent once after STARTTLS.
+		{fakeSMTPServer{oneTLSEHLO: true}, Success, ""},
+	}
+	c := Checker{Timeout: testTimeout}
+	for _, test := range tests {
+		test.server.config = fakeSMTPConfig(t)
+		ln := test.server.listen(t)
+		client, err := c.smtpDialContext(context.Background(), ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, _ := checkStartTLS(client)
+		client.Close()
+		ln.Close()
+		if result.Status != test.want {
+			t.Errorf("%+v: expected status %d, got %v", test.server, test.want, result)
+		}
+		if test.message != "" && !strings.Contains(strings.Join(result.Messages, " "), test.message) {
+			t.Errorf("%+v: expected message containing %q, got %v", test.server, test.message, result.Messages)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Submission services, which mail clients use to send mail through their
+// provider's servers. With "submission" (usually port 587) TLS is
+// negotiated with STARTTLS, while with "submissions" (usually port 465) it's
+// negotiated as soon as the client connects.
+// https://tools.ietf.org/html/rfc8314#section-3
+const (
+	submissionService  = "submission"
+	submissionsService = "submissions"
+)
+
+// SubmissionEndpoint is a mail submission server found in a domain's SRV
+// records.
+// https://tools.ietf.org/html/rfc6186#section-3.1
+type SubmissionEndpoint struct {
+	Hostname string `json:"hostname"`
+	Port     uint16 `json:"port"`
+	// ImplicitTLS is true if TLS is negotiated as soon as clients connect,
+	// rather than with STARTTLS.
+	ImplicitTLS bool `json:"implicit_tls"`
+	// Guessed is true if the domain doesn't publish SRV records, and this is
+	// where mail clients would look for a submission server instead.
+	Guessed bool `json:"guessed,omitempty"`
+}
+
+func (e SubmissionEndpoint) address() string {
+	return net.JoinHostPort(e.Hostname, fmt.Sprint(e.Port))
+}
+
+// SubmissionResult wraps the results of checking the mail submission
+// servers of a domain, which mail clients (MUAs) connect to, rather than its
+// MXs.
+type SubmissionResult struct {
+	*Result
+	Domain string `json:"domain"`
+	// The endpoints found in the domain's SRV records, in the order clients
+	// should try them.
+	Endpoints []SubmissionEndpoint `json:"endpoints"`
+	// The results of checking each endpoint, keyed by hostname:port.
+	HostnameResults map[string]HostnameResult `json:"hostname_results"`
+}
+
+// MarshalJSON prevents SubmissionResult from inheriting the version of
+// MarshalJSON implemented by Result.
+func (s SubmissionResult) MarshalJSON() ([]byte, error) {
+	type FakeResult Result
+	return json.Marshal(struct {
+		FakeResult
+		StatusText      string                    `json:"status_text,omitempty"`
+		Domain          string                    `json:"domain"`
+		Endpoints       []SubmissionEndpoint      `json:"endpoints"`
+		HostnameResults map[string]HostnameResult `json:"hostname_results"`
+	}{
+		FakeResult:      FakeResult(*s.Result),
+		StatusText:      s.StatusText(),
+		Domain:          s.Domain,
+		Endpoints:       s.Endpoints,
+		HostnameResults: s.HostnameResults,
+	})
+}
+
+// lookupSubmissionEndpoints returns the endpoints published in the SRV
+// records of each submission service for domain.
+func (c *Checker) lookupSubmissionEndpoints(ctx context.Context, domain string) ([]SubmissionEndpoint, *Result) {
+	result := MakeResult(SubmissionSRV)
+	endpoints := []SubmissionEndpoint{}
+	for _, service := range []string{submissionService, submissionsService} {
+		name := fmt.Sprintf("_%s._tcp.%s", service, domain)
+		lookupCtx, cancel := context.WithTimeout(ctx, c.timeout())
+		srvs, _, err := c.resolver().LookupSRV(lookupCtx, name)
+		cancel()
+		if err != nil || len(srvs) == 0 {
+			continue
+		}
+		// A single record whose target is "." means the service isn't
+		// offered.
+		if len(srvs) == 1 && srvs[0].Target == "." {
+			result.Code("submission.service_not_offered").Info("%s publishes that it doesn't offer %s.", domain, service)
+			continue
+		}
+		for _, srv := range srvs {
+			endpoints = append(endpoints, SubmissionEndpoint{
+				Hostname:    strings.TrimSuffix(srv.Target, "."),
+				Port:        srv.Port,
+				ImplicitTLS: service == submissionsService,
+			})
+		}
+	}
+	implicitTLS := false
+	for _, endpoint := range endpoints {
+		implicitTLS = implicitTLS || endpoint.ImplicitTLS
+	}
+	if len(endpoints) == 0 && len(result.Messages) == 0 {
+		result.Code("submission.no_srv").Warning("%s doesn't publish _submission._tcp or _submissions._tcp SRV records, so mail clients can't find its submission servers automatically.", domain)
+		if hostname := c.guessSubmissionHostname(ctx, domain); hostname != "" {
+			endpoints = []SubmissionEndpoint{
+				{Hostname: hostname, Port: 587, Guessed: true},
+				{Hostname: hostname, Port: 465, ImplicitTLS: true, Guessed: true},
+			}
+			result.Code("submission.guessed_endpoints").Info("Checked %s on ports 587 and 465 instead, where mail clients would guess its submission servers are.", hostname)
+		}
+	} else if len(endpoints) > 0 && !implicitTLS {
+		// Clients should prefer implicit TLS, since STARTTLS can be stripped.
+		// https://tools.ietf.org/html/rfc8314#section-3.3
+		result.Code("submission.no_implicit_tls").Warning("%s only offers submission with STARTTLS. Offering implicit TLS (_submissions._tcp, usually on port 465) is recommended.", domain)
+	}
+	return endpoints, result.Success()
+}
+
+// guessSubmissionHostname returns the hostname that mail clients would
+// guess for domain's submission servers, without SRV records: the first of
+// smtp.<domain>, mail.<domain>, domain itself and its most preferred MX
+// that has an address. It returns "" if none of them do.
+func (c *Checker) guessSubmissionHostname(ctx context.Context, domain string) string {
+	resolves := func(hostname string) bool {
+		lookupCtx, cancel := context.WithTimeout(ctx, c.timeout())
+		defer cancel()
+		ips, _, err := c.resolver().LookupIP(lookupCtx, hostname)
+		return err == nil && len(ips) > 0
+	}
+	for _, hostname := range []string{"smtp." + domain, "mail." + domain, domain} {
+		if resolves(hostname) {
+			return hostname
+		}
+	}
+	if records, _, err := c.lookupMXs(ctx, domain); err == nil && len(records) > 0 {
+		if mx := strings.TrimSuffix(records[0].Hostname, "."); resolves(mx) {
+			return mx
+		}
+	}
+	return ""
+}
+
+// CheckSubmission checks the mail submission servers that domain publishes
+// in its SRV records, with the same certificate and TLS version checks that
+// are performed on MXs. If there aren't any SRV records, the hostname mail
+// clients would guess is checked on ports 587 and 465 instead.
+func (c *Checker) CheckSubmission(domain string) SubmissionResult {
+	return c.CheckSubmissionContext(context.Background(), domain)
+}
+
+// CheckSubmissionContext is like CheckSubmission, but the checks are
+// abandoned once ctx is done.
+func (c *Checker) CheckSubmissionContext(ctx context.Context, domain string) SubmissionResult {
+	result := SubmissionResult{
+		Result:          MakeResult("submission"),
+		Domain:          domain,
+		HostnameResults: make(map[string]HostnameResult),
+	}
+	endpoints, srvResult := c.lookupSubmissionEndpoints(ctx, domain)
+	result.Endpoints = endpoints
+	result.addCheck(srvResult)
+	for _, endpoint := range endpoints {
+		if _, ok := result.HostnameResults[endpoint.address()]; ok {
+			continue
+		}
+		hostnameResult := c.checkSubmissionEndpoint(ctx, domain, endpoint)
+		result.HostnameResults[endpoint.address()] = hostnameResult
+		result.Status = SetStatus(result.Status, hostnameResult.Status)
+	}
+	return result
+}
+
+// checkSubmissionEndpoint checks each address of a submission endpoint.
+func (c *Checker) checkSubmissionEndpoint(ctx context.Context, domain string, endpoint SubmissionEndpoint) HostnameResult {
+	result := HostnameResult{
+		Domain:     domain,
+		Hostname:   endpoint.address(),
+		Result:     MakeResult("hostnames"),
+		Timestamp:  time.Now(),
+		TrustStore: c.trustStore(),
+	}
+	addresses, err := c.lookupAddresses(ctx, endpoint.address())
+	if err != nil {
+		result.addCheck(MakeResult(Connectivity).Code("smtp.address_lookup_failed").Error("Could not look up addresses: %v", err))
+		return result
+	}
+	for _, address := range addresses {
+		var addressResult AddressResult
+		if endpoint.ImplicitTLS {
+			addressResult = c.checkImplicitTLSAddress(ctx, endpoint.Hostname, address)
+		} else {
+			// DANE isn't checked, since it isn't commonly used for submission.
+			addressResult = c.checkAddress(ctx, domain, endpoint.Hostname, address, nil, false)
+		}
+		result.Addresses = append(result.Addresses, addressResult)
+	}
+	result.aggregateAddresses()
+	return result
+}
+
+// checkImplicitTLSAddress is like checkAddress, for servers that negotiate
+// TLS as soon as we connect.
+func (c *Checker) checkImplicitTLSAddress(ctx context.Context, hostname, address string) AddressResult {
+	host, _, _ := net.SplitHostPort(address)
+	result := AddressResult{
+		Result:  MakeResult("addresses"),
+		Address: host,
+		Network: addressNetwork(net.ParseIP(host)),
+	}
+	timeout := c.timeout()
+
+	connectivityResult := MakeResult(Connectivity)
+	network := c.network()
+	conn, err := network.dial(ctx, address, timeout)
+	if err != nil {
+		result.unreachable = networkUnreachable(err)
+		result.addCheck(connectivityResult.Code("smtp.connection_failed").Error("Could not establish connection: %v", err))
+		return result
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	result.addCheck(connectivityResult.Success())
+
+	tlsResult := MakeResult(ImplicitTLS)
+	// Record the handshake, which starts the connection, and what the server
+	// sent once it completed.
+	handshake := &recordingConn{Conn: conn, tlsStart: 0}
+	tlsConn := network.tlsClient(newContextConn(ctx, handshake), &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS10})
+	defer tlsConn.Close()
+	if err := tlsConn.Handshake(); err != nil {
+		result.addCheck(tlsResult.Code("tls.implicit_handshake_failed").Failure("Could not complete a TLS handshake: %v", err))
+		return result
+	}
+	session := &recordingConn{Conn: tlsConn, tlsStart: -1}
+	client, err := newSMTPClient(session, network)
+	if err == nil {
+		err = client.Hello(getThisHostname())
+	}
+	if err != nil {
+		result.addCheck(tlsResult.Code("submission.smtp_over_tls_refused").Failure("Server didn't accept SMTP over TLS: %v", err))
+		return result
+	}
+	defer client.Close()
+	result.addCheck(tlsResult.Success())
+
+	state := tlsConn.ConnectionState()
+	result.Certificates = summarizeChain(state.PeerCertificates)
+	result.CertificateStatus = summarizeCertificateStatus(state)
+	result.Capabilities = session.capabilities(nil, nil)
+	// All of the extensions were advertised over TLS.
+	result.Capabilities.TLSExtensions = result.Capabilities.Extensions
+	result.Capabilities.Extensions = []string{}
+	result.Capabilities.TLS = handshake.negotiatedTLS(state)
+
+	result.addCheck(c.validateCert(state, hostname, false, MakeResult(Certificate)))
+	result.addCheck(c.checkImplicitTLSVersion(ctx, state, address))
+	return result
+}
+
+// checkImplicitTLSVersion is like checkTLSVersion, for servers that
+// negotiate TLS as soon as we connect.
+func (c *Checker) checkImplicitTLSVersion(ctx context.Context, state tls.ConnectionState, address string) *Result {
+	result := MakeResult(Version)
+	if state.Version < tls.VersionTLS12 {
+		result.Code("tls.version_outdated").Warning("Server should support TLSv1.2, but doesn't.")
+	}
+
+	// Attempt to connect with an old SSL version.
+	network := c.network()
+	conn, err := network.dial(ctx, address, c.timeout())
+	if err != nil {
+		return result.Code("smtp.connection_failed").Error("Could not establish connection: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	config := tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionSSL30,
+		MaxVersion:         tls.VersionSSL30,
+	}
+	tlsConn := network.tlsClient(newContextConn(ctx, conn), &config)
+	defer tlsConn.Close()
+	if err := tlsConn.Handshake(); err == nil {
+		return result.Code("tls.sslv3_enabled").Failure("Server should NOT support SSLv2/3, but does.")
+	}
+	return result.Success()
+}
@@ -0,0 +1,169 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mhale/smtpd"
+)
+
+// submissionResolver serves SRV records for submission services, and the
+// addresses of the hostnames mail clients guess without them.
+type submissionResolver struct {
+	mockResolver
+	srvs      map[string][]*net.SRV
+	addresses map[string][]net.IP
+}
+
+func (r submissionResolver) LookupIP(ctx context.Context, name string) ([]net.IP, bool, error) {
+	if ips, ok := r.addresses[name]; ok {
+		return ips, false, nil
+	}
+	return r.mockResolver.LookupIP(ctx, name)
+}
+
+func (r submissionResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, bool, error) {
+	if srvs, ok := r.srvs[name]; ok {
+		return srvs, false, nil
+	}
+	return nil, false, fmt.Errorf("no SRV records for %s", name)
+}
+
+// implicitTLSListenAndServe creates a test smtp server that negotiates TLS
+// as soon as clients connect.
+func implicitTLSListenAndServe(t *testing.T, tlsConfig *tls.Config) net.Listener {
+	srv := &smtpd.Server{
+		Handler:   noopHandler,
+		Hostname:  "example.com",
+		TLSConfig: tlsConfig,
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(tls.NewListener(ln, tlsConfig))
+	return ln
+}
+
+func listenerPort(ln net.Listener) uint16 {
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return uint16(p)
+}
+
+func TestCheckSubmission(t *testing.T) {
+	config := fakeSMTPConfig(t)
+	starttls := smtpListenAndServe(t, config)
+	defer starttls.Close()
+	implicit := implicitTLSListenAndServe(t, config)
+	defer implicit.Close()
+
+	c := Checker{
+		Timeout: testTimeout,
+		Resolver: submissionResolver{srvs: map[string][]*net.SRV{
+			"_submission._tcp.example.com":  {{Target: "localhost.", Port: listenerPort(starttls)}},
+			"_submissions._tcp.example.com": {{Target: "localhost.", Port: listenerPort(implicit)}},
+		}},
+	}
+	result := c.CheckSubmission("example.com")
+	if len(result.Endpoints) != 2 || result.Endpoints[0].ImplicitTLS || !result.Endpoints[1].ImplicitTLS {
+		t.Fatalf("expected a STARTTLS and an implicit TLS endpoint, got %v", result.Endpoints)
+	}
+	if result.Checks[SubmissionSRV].Status != Success {
+		t.Errorf("expected SRV records to be found, got %v", result.Checks[SubmissionSRV])
+	}
+
+	starttlsResult := result.HostnameResults[result.Endpoints[0].address()]
+	if !starttlsResult.couldSTARTTLS() {
+		t.Errorf("expected STARTTLS on the submission port, got %v", starttlsResult.Checks)
+	}
+	implicitResult := result.HostnameResults[result.Endpoints[1].address()]
+	if implicitResult.Checks[ImplicitTLS] == nil || implicitResult.Checks[ImplicitTLS].Status != Success {
+		t.Errorf("expected implicit TLS to succeed, got %v", implicitResult.Checks)
+	}
+	for _, hostnameResult := range []HostnameResult{starttlsResult, implicitResult} {
+		// The certificate is self-signed.
+		if status := hostnameResult.Checks[Certificate].Status; status != Failure {
+			t.Errorf("expected certificate check to fail, got %d", status)
+		}
+		if status := hostnameResult.Checks[Version].Status; status != Success {
+			t.Errorf("expected version check to succeed, got %d", status)
+		}
+	}
+	if implicitResult.Capabilities == nil || implicitResult.Capabilities.TLS == nil ||
+		implicitResult.Capabilities.Banner == "" || len(implicitResult.Capabilities.TLSExtensions) == 0 {
+		t.Errorf("expected capabilities over implicit TLS, got %+v", implicitResult.Capabilities)
+	}
+	if result.Status != Failure {
+		t.Errorf("expected the certificate failure to fail the result, got %d", result.Status)
+	}
+
+	marshalled, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(marshalled), `"implicit_tls":true`) {
+		t.Errorf("expected marshalled result to list endpoints, got %s", marshalled)
+	}
+}
+
+func TestSubmissionSRV(t *testing.T) {
+	mailAddress := map[string][]net.IP{"mail.example.com": {net.ParseIP("192.0.2.1")}}
+	tests := []struct {
+		srvs      map[string][]*net.SRV
+		addresses map[string][]net.IP
+		status    Status
+		message   string
+		guessed   bool
+	}{
+		{nil, nil, Warning, "doesn't publish _submission._tcp or _submissions._tcp SRV records", false},
+		{nil, mailAddress, Warning, "Checked mail.example.com on ports 587 and 465 instead", true},
+		{map[string][]*net.SRV{"_submission._tcp.example.com": {{Target: "mail.example.com.", Port: 587}}},
+			nil, Warning, "only offers submission with STARTTLS", false},
+		{map[string][]*net.SRV{"_submission._tcp.example.com": {{Target: ".", Port: 0}},
+			"_submissions._tcp.example.com": {{Target: "mail.example.com.", Port: 465}}},
+			mailAddress, Success, "doesn't offer submission.", false},
+	}
+	for _, test := range tests {
+		c := Checker{Resolver: submissionResolver{srvs: test.srvs, addresses: test.addresses}}
+		endpoints, result := c.lookupSubmissionEndpoints(context.Background(), "example.com")
+		if result.Status != test.status || !strings.Contains(strings.Join(result.Messages, " "), test.message) {
+			t.Errorf("expected status %d with message %q, got %v", test.status, test.message, result)
+		}
+		if test.guessed && len(endpoints) != 2 {
+			t.Errorf("expected ports 587 and 465 to be guessed, got %v", endpoints)
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.Hostname != "mail.example.com" || endpoint.Guessed != test.guessed {
+				t.Errorf("expected endpoint mail.example.com, guessed %t, got %v", test.guessed, endpoint)
+			}
+		}
+	}
+}
+
+func TestGuessSubmissionHostname(t *testing.T) {
+	address := []net.IP{net.ParseIP("192.0.2.1")}
+	tests := []struct {
+		domain    string
+		addresses map[string][]net.IP
+		want      string
+	}{
+		{"domain.tld", map[string][]net.IP{"smtp.domain.tld": address, "mail.domain.tld": address}, "smtp.domain.tld"},
+		{"domain.tld", map[string][]net.IP{"domain.tld": address}, "domain.tld"},
+		// The most preferred MX, if none of the conventional names resolve.
+		{"domain.tld", map[string][]net.IP{"mail2.domain.tld": address}, "mail2.domain.tld"},
+		{"domain.tld", nil, ""},
+	}
+	for _, test := range tests {
+		c := Checker{Resolver: submissionResolver{addresses: test.addresses}}
+		if got := c.guessSubmissionHostname(context.Background(), test.domain); got != test.want {
+			t.Errorf("guessSubmissionHostname(%s) with addresses for %v = %q, want %q", test.domain, test.addresses, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// checkTLSRPT checks the SMTP TLS Reporting record for domain.
+// https://tools.ietf.org/html/rfc8460#section-3
+func (c *Checker) checkTLSRPT(ctx context.Context, domain string) *Result {
+	result := MakeResult(TLSRPT)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	records, _, err := c.resolver().LookupTXT(ctx, fmt.Sprintf("_smtp._tls.%s", domain))
+	if err != nil || len(filterByPrefix(records, "v=TLSRPTv1")) == 0 {
+		return result.Code("tls_rpt.missing").Warning("No TLS-RPT record found at _smtp._tls.%s, so you won't receive reports from senders that fail to deliver mail to you over TLS.", domain)
+	}
+	return validateTLSRPTRecord(records, result)
+}
+
+// validateTLSRPTRecord checks that exactly one TLS-RPT record is published,
+// and that its reporting URIs are valid.
+func validateTLSRPTRecord(records []string, result *Result) *Result {
+	records = filterByPrefix(records, "v=TLSRPTv1")
+	if len(records) != 1 {
+		return result.Code("tls_rpt.record_count").Failure("Exactly 1 TLS-RPT TXT record required, found %d.", len(records))
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(records[0], ";") {
+		split := strings.SplitN(field, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+	}
+	if fields["v"] != "TLSRPTv1" {
+		return result.Code("tls_rpt.invalid_version").Failure("Invalid TLS-RPT record version %s.", fields["v"])
+	}
+	if fields["rua"] == "" {
+		return result.Code("tls_rpt.missing_rua").Failure("Your TLS-RPT record must specify where to send reports with rua.")
+	}
+	valid := 0
+	for _, uri := range strings.Split(fields["rua"], ",") {
+		if err := validateTLSRPTURI(strings.TrimSpace(uri)); err != nil {
+			result.Code("tls_rpt.invalid_uri").Warning("Invalid TLS-RPT reporting URI %s: %v.", uri, err)
+			continue
+		}
+		valid++
+	}
+	if valid == 0 {
+		return result.Code("tls_rpt.no_valid_uris").Failure("Your TLS-RPT record has no valid reporting URIs.")
+	}
+	return result.Success()
+}
+
+// validateTLSRPTURI checks that uri is a mailto: or https: URI.
+func validateTLSRPTURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	switch parsed.Scheme {
+	case "mailto":
+		address, err := url.PathUnescape(parsed.Opaque)
+		if err != nil {
+			return err
+		}
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid email address")
+		}
+	case "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("missing host")
+		}
+	default:
+		return fmt.Errorf("scheme must be mailto or https")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidateTLSRPTRecord(t *testing.T) {
+	tests := []struct {
+		txt    []string
+		status Status
+	}{
+		{[]string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com"}, Success},
+		{[]string{"v=TLSRPTv1;rua=https://reports.example.com/v1/tls?key=abc"}, Success},
+		{[]string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com,https://reports.example.com/tls"}, Success},
+		{[]string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com,ftp://example.com"}, Warning},
+		{[]string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com", "v=TLSRPTv1; rua=mailto:other@example.com"}, Failure},
+		{[]string{"v=TLSRPTv1;"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=mailto:not an address"}, Failure},
+		{[]string{"v=TLSRPTv1; rua=https:///tls"}, Failure},
+		{[]string{"v=spf1 a -all", "v=TLSRPTv1; rua=mailto:tlsrpt@example.com"}, Success},
+	}
+	for _, test := range tests {
+		result := validateTLSRPTRecord(test.txt, MakeResult(TLSRPT))
+		if result.Status != test.status {
+			t.Errorf("validateTLSRPTRecord(%q) = %v, want status %d", test.txt, result, test.status)
+		}
+	}
+}
+
+func TestCheckTLSRPT(t *testing.T) {
+	server := stubNameserver(t, []string{
+		"_smtp._tls.signed.example. 300 IN TXT \"v=TLSRPTv1; rua=mailto:tlsrpt@signed.example\"",
+	})
+	defer server.Shutdown()
+	c := Checker{Timeout: time.Second, Resolver: &DNSResolver{Server: server.PacketConn.LocalAddr().String()}}
+
+	if result := c.checkTLSRPT(context.Background(), "signed.example"); result.Status != Success {
+		t.Errorf("expected TLS-RPT record to be valid, got %v", result)
+	}
+	if result := c.checkTLSRPT(context.Background(), "unsigned.test"); result.Status != Warning {
+		t.Errorf("expected missing TLS-RPT record to produce a warning, got %v", result)
+	}
+}
@@ -0,0 +1,392 @@
+package checker
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// TLS protocol versions, including ones that crypto/tls doesn't define.
+const (
+	versionSSL30 = 0x0300
+	versionTLS10 = 0x0301
+	versionTLS11 = 0x0302
+	versionTLS12 = 0x0303
+	versionTLS13 = 0x0304
+)
+
+var versionNames = map[uint16]string{
+	versionSSL30: "SSLv3",
+	versionTLS10: "TLSv1.0",
+	versionTLS11: "TLSv1.1",
+	versionTLS12: "TLSv1.2",
+	versionTLS13: "TLSv1.3",
+}
+
+// Cipher suites that we offer when enumerating the suites a server accepts
+// with SSLv3 through TLS 1.2. This includes insecure suites that crypto/tls
+// doesn't implement, which is why the enumeration uses handcrafted
+// ClientHellos.
+var legacyCipherSuites = []uint16{
+	0x0001, 0x0002, 0x003B, // NULL
+	0x0003, 0x0006, 0x0008, 0x0014, // EXPORT
+	0x0004, 0x0005, 0xC007, 0xC011, // RC4
+	0x0009, 0x000A, 0x0016, 0xC008, 0xC012, // DES and 3DES
+	0x002F, 0x0033, 0x0035, 0x0039, 0x003C, 0x003D, 0x0067, 0x006B,
+	0xC009, 0xC00A, 0xC013, 0xC014, 0xC023, 0xC024, 0xC027, 0xC028, // CBC
+	0x009C, 0x009D, 0x009E, 0x009F, 0xC02B, 0xC02C, 0xC02F, 0xC030,
+	0xCCA8, 0xCCA9, 0xCCAA, // AEAD
+}
+
+// Cipher suites that we offer when enumerating the suites a server accepts
+// with TLS 1.3.
+var tls13CipherSuites = []uint16{0x1301, 0x1302, 0x1303}
+
+var cipherSuiteNames = map[uint16]string{
+	0x0001: "TLS_RSA_WITH_NULL_MD5",
+	0x0002: "TLS_RSA_WITH_NULL_SHA",
+	0x003B: "TLS_RSA_WITH_NULL_SHA256",
+	0x0003: "TLS_RSA_EXPORT_WITH_RC4_40_MD5",
+	0x0006: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5",
+	0x0008: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0014: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0xC007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xC011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x000A: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xC008: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xC012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002F: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003C: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003D: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006B: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0xC009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xC00A: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xC013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xC014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xC023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xC024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xC027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xC028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+	0x009C: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009D: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009E: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009F: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xC02B: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xC02C: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xC02F: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xC030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xCCA8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCA9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xCCAA: "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+func cipherSuiteName(id uint16) string {
+	if name, ok := cipherSuiteNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// TLSVersionSupport lists the cipher suites that a mailserver accepts with
+// one version of TLS.
+type TLSVersionSupport struct {
+	Version      string   `json:"version"`
+	CipherSuites []string `json:"cipher_suites"`
+}
+
+// TLSSupport describes the TLS versions and cipher suites that a mailserver
+// accepts. Only versions that the server accepts are listed.
+type TLSSupport struct {
+	Versions []TLSVersionSupport `json:"versions"`
+}
+
+// errHelloRejected is returned by probeHello when the server refuses the
+// ClientHello, or answers it with something other than a ServerHello.
+var errHelloRejected = errors.New("server rejected the ClientHello")
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendExtension appends a TLS extension with the given data.
+func appendExtension(b []byte, extension uint16, data []byte) []byte {
+	b = appendUint16(b, extension)
+	b = appendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// clientHello builds a ClientHello record offering suites with version.
+// TLS 1.3 is offered in the supported_versions extension, along with an
+// X25519 key share, so that servers answer with a TLS 1.3 ServerHello even
+// though we never complete the handshake.
+func clientHello(version uint16, suites []uint16, serverName string) []byte {
+	random := make([]byte, 32)
+	rand.Read(random)
+	legacyVersion := version
+	if version == versionTLS13 {
+		legacyVersion = versionTLS12
+	}
+	body := appendUint16(nil, legacyVersion)
+	body = append(body, random...)
+	body = append(body, 0) // Empty session ID.
+	body = appendUint16(body, uint16(2*len(suites)))
+	for _, suite := range suites {
+		body = appendUint16(body, suite)
+	}
+	body = append(body, 1, 0) // Only the null compression method.
+
+	if version > versionSSL30 {
+		var extensions []byte
+		if serverName != "" && net.ParseIP(serverName) == nil {
+			name := []byte(serverName)
+			data := appendUint16(nil, uint16(len(name)+3))
+			data = append(data, 0) // host_name
+			data = appendUint16(data, uint16(len(name)))
+			extensions = appendExtension(extensions, 0, append(data, name...))
+		}
+		// supported_groups: x25519, secp256r1, secp384r1, secp521r1.
+		groups := []uint16{29, 23, 24, 25}
+		data := appendUint16(nil, uint16(2*len(groups)))
+		for _, group := range groups {
+			data = appendUint16(data, group)
+		}
+		extensions = appendExtension(extensions, 10, data)
+		// ec_point_formats: uncompressed.
+		extensions = appendExtension(extensions, 11, []byte{1, 0})
+		// signature_algorithms: RSA PKCS#1, ECDSA and RSA-PSS with SHA-2, then SHA-1.
+		algorithms := []uint16{0x0401, 0x0501, 0x0601, 0x0403, 0x0503, 0x0603,
+			0x0804, 0x0805, 0x0806, 0x0201, 0x0203}
+		data = appendUint16(nil, uint16(2*len(algorithms)))
+		for _, algorithm := range algorithms {
+			data = appendUint16(data, algorithm)
+		}
+		extensions = appendExtension(extensions, 13, data)
+		// renegotiation_info, which some servers require.
+		extensions = appendExtension(extensions, 0xff01, []byte{0})
+		if version == versionTLS13 {
+			// supported_versions: only TLS 1.3.
+			extensions = appendExtension(extensions, 43, []byte{2, 3, 4})
+			// key_share: a random X25519 public key. We never use the shared
+			// secret, so there's no need to keep the private key.
+			share := make([]byte, 32)
+			rand.Read(share)
+			data = appendUint16(nil, 2+2+uint16(len(share)))
+			data = appendUint16(data, 29)
+			data = appendUint16(data, uint16(len(share)))
+			extensions = appendExtension(extensions, 51, append(data, share...))
+		}
+		body = appendUint16(body, uint16(len(extensions)))
+		body = append(body, extensions...)
+	}
+
+	handshake := []byte{1, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+	recordVersion := uint16(versionTLS10)
+	if version == versionSSL30 {
+		recordVersion = versionSSL30
+	}
+	record := []byte{22}
+	record = appendUint16(record, recordVersion)
+	record = appendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+// readServerHello reads the server's response to a ClientHello, and returns
+// the version and cipher suite it selected. TLS 1.3 servers select their
+// version in the supported_versions extension, including in a
+// HelloRetryRequest, which also means the server accepts TLS 1.3.
+func readServerHello(r io.Reader) (uint16, uint16, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, errHelloRejected
+	}
+	if header[0] != 22 {
+		// Most likely an alert.
+		return 0, 0, errHelloRejected
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, errHelloRejected
+	}
+	// Handshake type (1 byte) and length (3 bytes), then the ServerHello:
+	// version (2 bytes), random (32 bytes), session ID, cipher suite.
+	if len(body) < 4+35 || body[0] != 2 {
+		return 0, 0, errHelloRejected
+	}
+	hello := body[4:]
+	version := uint16(hello[0])<<8 | uint16(hello[1])
+	sessionIDLength := int(hello[34])
+	if len(hello) < 35+sessionIDLength+2 {
+		return 0, 0, errHelloRejected
+	}
+	suite := uint16(hello[35+sessionIDLength])<<8 | uint16(hello[36+sessionIDLength])
+	// The compression method, then the extensions, if there are any.
+	extensions := hello[35+sessionIDLength+2:]
+	if len(extensions) < 3 {
+		return version, suite, nil
+	}
+	extensions = extensions[3:]
+	for len(extensions) >= 4 {
+		extension := uint16(extensions[0])<<8 | uint16(extensions[1])
+		length := int(extensions[2])<<8 | int(extensions[3])
+		if len(extensions) < 4+length {
+			return 0, 0, errHelloRejected
+		}
+		if extension == 43 && length == 2 {
+			version = uint16(extensions[4])<<8 | uint16(extensions[5])
+		}
+		extensions = extensions[4+length:]
+	}
+	return version, suite, nil
+}
+
+// probeHello connects to address, issues STARTTLS, and sends a ClientHello
+// offering suites with version. It returns the version and cipher suite
+// selected by the server, or errHelloRejected.
+func (c *Checker) probeHello(ctx context.Context, address, hostname string, version uint16, suites []uint16) (uint16, uint16, error) {
+	// The connection is closed once this times out.
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	client, err := c.smtpDialContext(ctx, address)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer client.Close()
+	id, err := client.Text.Cmd("STARTTLS")
+	if err != nil {
+		return 0, 0, err
+	}
+	client.Text.StartResponse(id)
+	_, _, err = client.Text.ReadResponse(220)
+	client.Text.EndResponse(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := client.Text.W.Write(clientHello(version, suites, withoutPort(hostname))); err != nil {
+		return 0, 0, err
+	}
+	if err := client.Text.W.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return readServerHello(client.Text.R)
+}
+
+// enumerateCipherSuites returns the cipher suites that the server at address
+// accepts with version, in the order the server prefers them.
+func (c *Checker) enumerateCipherSuites(ctx context.Context, address, hostname string, version uint16) ([]uint16, error) {
+	accepted := []uint16{}
+	offered := legacyCipherSuites
+	if version == versionTLS13 {
+		offered = tls13CipherSuites
+	}
+	remaining := append([]uint16{}, offered...)
+	for len(remaining) > 0 {
+		selectedVersion, suite, err := c.probeHello(ctx, address, hostname, version, remaining)
+		if err == errHelloRejected || (err == nil && selectedVersion != version) {
+			break
+		}
+		if err != nil {
+			return accepted, err
+		}
+		offered := false
+		for i, s := range remaining {
+			if s == suite {
+				offered = true
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+		if !offered {
+			// The server selected a suite we didn't offer.
+			break
+		}
+		accepted = append(accepted, suite)
+	}
+	return accepted, nil
+}
+
+// enumerateTLS determines which TLS versions and cipher suites the server at
+// address accepts. Each probe takes a new connection.
+func (c *Checker) enumerateTLS(ctx context.Context, address, hostname string) (*TLSSupport, error) {
+	support := &TLSSupport{Versions: []TLSVersionSupport{}}
+	for _, version := range []uint16{versionTLS13, versionTLS12, versionTLS11, versionTLS10, versionSSL30} {
+		suites, err := c.enumerateCipherSuites(ctx, address, hostname, version)
+		if len(suites) > 0 {
+			names := []string{}
+			for _, suite := range suites {
+				names = append(names, cipherSuiteName(suite))
+			}
+			support.Versions = append(support.Versions, TLSVersionSupport{
+				Version:      versionNames[version],
+				CipherSuites: names,
+			})
+		}
+		if err != nil {
+			return support, err
+		}
+	}
+	return support, nil
+}
+
+// gradeTLSSupport fails servers that accept broken protocols or cipher
+// suites, and warns about deprecated ones.
+func gradeTLSSupport(support *TLSSupport, result *Result) *Result {
+	if len(support.Versions) == 0 {
+		return result.Code("tls.no_handshake_accepted").Error("Server didn't accept any of the TLS versions and cipher suites we offered.")
+	}
+	aead := false
+	for _, version := range support.Versions {
+		switch version.Version {
+		case versionNames[versionSSL30]:
+			result.Code("tls.sslv3_enabled").Failure("Server accepts SSLv3, which is broken.")
+		case versionNames[versionTLS10], versionNames[versionTLS11]:
+			result.Code("tls.deprecated_version").Warning("Server accepts %s, which is deprecated.", version.Version)
+		case versionNames[versionTLS13]:
+			aead = true
+		}
+		for _, suite := range version.CipherSuites {
+			switch {
+			case strings.Contains(suite, "_EXPORT_"):
+				result.Code("tls.export_cipher").Failure("Server accepts export-grade cipher suite %s with %s.", suite, version.Version)
+			case strings.Contains(suite, "_NULL_"):
+				result.Code("tls.null_cipher").Failure("Server accepts unencrypted cipher suite %s with %s.", suite, version.Version)
+			case strings.Contains(suite, "_RC4_"):
+				result.Code("tls.rc4_cipher").Failure("Server accepts RC4 cipher suite %s with %s.", suite, version.Version)
+			case strings.Contains(suite, "_DES_") || strings.Contains(suite, "_3DES_"):
+				result.Code("tls.des_cipher").Failure("Server accepts DES cipher suite %s with %s.", suite, version.Version)
+			case strings.Contains(suite, "_GCM_") || strings.Contains(suite, "_CHACHA20_"):
+				aead = true
+			}
+		}
+	}
+	if !aead {
+		result.Code("tls.cbc_only").Warning("Server only accepts CBC cipher suites; it should support AES-GCM or ChaCha20-Poly1305.")
+	}
+	return result
+}
+
+// checkTLSSupport enumerates and grades the TLS versions and cipher suites
+// accepted by the server at address.
+func (c *Checker) checkTLSSupport(ctx context.Context, address, hostname string) (*Result, *TLSSupport) {
+	result := MakeResult(TLSEnumeration)
+	support, err := c.enumerateTLS(ctx, address, hostname)
+	if err != nil {
+		result.Code("tls.enumeration_error").Error("Couldn't finish enumerating TLS versions and cipher suites: %v", err)
+	}
+	return gradeTLSSupport(support, result), support
+}
@@ -0,0 +1,179 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestReadServerHello(t *testing.T) {
+	hello := []byte{22, 3, 3, 0, 42, // Record header.
+		2, 0, 0, 38, // Handshake header.
+		3, 3, // TLS 1.2
+	}
+	hello = append(hello, make([]byte, 32)...) // Random.
+	hello = append(hello, 0)                   // Session ID.
+	hello = append(hello, 0xc0, 0x2f, 0)       // Cipher suite and compression method.
+	version, suite, err := readServerHello(bytes.NewReader(hello))
+	if err != nil || version != versionTLS12 || suite != 0xc02f {
+		t.Errorf("readServerHello = %x, %x, %v", version, suite, err)
+	}
+
+	// TLS 1.3 ServerHellos have a legacy version of TLS 1.2, and the real
+	// version in supported_versions.
+	hello = []byte{22, 3, 3, 0, 50, // Record header.
+		2, 0, 0, 46, // Handshake header.
+		3, 3, // TLS 1.2
+	}
+	hello = append(hello, make([]byte, 32)...)     // Random.
+	hello = append(hello, 0)                       // Session ID.
+	hello = append(hello, 0x13, 0x01, 0)           // Cipher suite and compression method.
+	hello = append(hello, 0, 6, 0, 43, 0, 2, 3, 4) // supported_versions.
+	version, suite, err = readServerHello(bytes.NewReader(hello))
+	if err != nil || version != versionTLS13 || suite != 0x1301 {
+		t.Errorf("readServerHello = %x, %x, %v", version, suite, err)
+	}
+
+	alert := []byte{21, 3, 3, 0, 2, 2, 40}
+	if _, _, err := readServerHello(bytes.NewReader(alert)); err != errHelloRejected {
+		t.Errorf("expected alert to be a rejection, got %v", err)
+	}
+}
+
+func TestClientHelloTLS13(t *testing.T) {
+	hello := clientHello(versionTLS13, tls13CipherSuites, "example.com")
+	if !bytes.Equal(hello[9:11], []byte{3, 3}) {
+		t.Errorf("expected a legacy version of TLS 1.2, got %x", hello[9:11])
+	}
+	if !bytes.Contains(hello, []byte{0, 43, 0, 3, 2, 3, 4}) {
+		t.Errorf("expected TLS 1.3 in supported_versions, got %x", hello)
+	}
+	if !bytes.Contains(hello, []byte{0, 51, 0, 38, 0, 36, 0, 29, 0, 32}) {
+		t.Errorf("expected an X25519 key share, got %x", hello)
+	}
+	if bytes.Contains(clientHello(versionTLS12, legacyCipherSuites, "example.com"), []byte{0, 43, 0, 3}) {
+		t.Errorf("expected no supported_versions when offering TLS 1.2")
+	}
+}
+
+// tls13Supported reports whether crypto/tls, which the fake servers in these
+// tests use, implements TLS 1.3. The checker detects TLS 1.3 with its own
+// ClientHellos, so it doesn't depend on this.
+func tls13Supported(t *testing.T) bool {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		server.Handshake()
+		server.Close()
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, MaxVersion: versionTLS13})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return client.ConnectionState().Version == versionTLS13
+}
+
+func TestGradeTLSSupport(t *testing.T) {
+	tests := []struct {
+		desc     string
+		versions []TLSVersionSupport
+		status   Status
+	}{
+		{"modern", []TLSVersionSupport{
+			{"TLSv1.3", []string{"TLS_AES_128_GCM_SHA256"}},
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"}},
+		}, Success},
+		{"deprecated version", []TLSVersionSupport{
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			{"TLSv1.0", []string{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"}},
+		}, Warning},
+		{"CBC only", []TLSVersionSupport{
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256"}},
+		}, Warning},
+		{"RC4", []TLSVersionSupport{
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}},
+		}, Failure},
+		{"3DES", []TLSVersionSupport{
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_3DES_EDE_CBC_SHA"}},
+		}, Failure},
+		{"export", []TLSVersionSupport{
+			{"TLSv1.0", []string{"TLS_RSA_EXPORT_WITH_RC4_40_MD5"}},
+		}, Failure},
+		{"NULL", []TLSVersionSupport{
+			{"TLSv1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_NULL_SHA256"}},
+		}, Failure},
+		{"SSLv3", []TLSVersionSupport{
+			{"SSLv3", []string{"TLS_RSA_WITH_AES_128_CBC_SHA"}},
+		}, Failure},
+		{"nothing", []TLSVersionSupport{}, Error},
+	}
+	for _, test := range tests {
+		result := gradeTLSSupport(&TLSSupport{test.versions}, MakeResult(TLSEnumeration))
+		if result.Status != test.status {
+			t.Errorf("%s: got status %d, want %d: %v", test.desc, result.Status, test.status, result.Messages)
+		}
+	}
+}
+
+func TestEnumerateTLS(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		},
+	})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	c := Checker{Timeout: testTimeout}
+	result, support := c.checkTLSSupport(context.Background(), "127.0.0.1:"+port, "localhost:"+port)
+	if len(support.Versions) != 3 {
+		t.Fatalf("expected TLS 1.0 to 1.2 to be accepted, got %v", support.Versions)
+	}
+	if support.Versions[0].Version != "TLSv1.2" || len(support.Versions[0].CipherSuites) != 2 {
+		t.Errorf("expected 2 cipher suites with TLS 1.2, got %v", support.Versions[0])
+	}
+	if result.Status != Warning {
+		t.Errorf("expected warnings for TLS 1.0 and CBC-only suites, got %v", result)
+	}
+}
+
+func TestCheckHostnameTLSEnumeration(t *testing.T) {
+	cert, err := tls.X509KeyPair([]byte(certString), []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := smtpListenAndServe(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	c := Checker{Timeout: testTimeout, Resolver: mockResolver{}, Checks: []string{TLSEnumeration}}
+	result := c.fullCheckHostname(context.Background(), "", "localhost:"+port)
+	if result.TLSSupport == nil || len(result.TLSSupport.Versions) == 0 {
+		t.Fatalf("expected TLS support to be enumerated, got %v", result.TLSSupport)
+	}
+	want := "TLSv1.2"
+	if tls13Supported(t) {
+		want = "TLSv1.3"
+	}
+	if result.TLSSupport.Versions[0].Version != want {
+		t.Errorf("expected %s to be the highest version accepted, got %v", want, result.TLSSupport.Versions)
+	}
+	if !result.subcheckSucceeded(TLSEnumeration) {
+		t.Errorf("expected TLS enumeration to succeed, got %v", result.Checks[TLSEnumeration])
+	}
+}
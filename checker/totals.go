@@ -16,6 +16,7 @@ type AggregatedScan struct {
 	Source            string
 	Attempted         int
 	WithMXs           int
+	NullMX            int
 	MTASTSTesting     int
 	MTASTSTestingList []string
 	MTASTSEnforce     int
@@ -53,6 +54,11 @@ func (a *AggregatedScan) HandleDomain(r DomainResult) {
 		log.Println(a.MTASTSEnforceList)
 	}
 
+	if r.Status == DomainNullMX {
+		// The domain explicitly doesn't accept mail.
+		a.NullMX++
+		return
+	}
 	if len(r.HostnameResults) == 0 {
 		// No MX records - assume this isn't an email domain.
 		return
@@ -8,20 +8,23 @@ import (
 )
 
 func TestCheckCSV(t *testing.T) {
-	in := "empty\ndomain\ndomain.tld\nnoconnection\nnoconnection2\nnostarttls\n"
+	in := "empty\ndomain\ndomain.tld\nnoconnection\nnoconnection2\nnostarttls\nnullmx\n"
 	reader := csv.NewReader(strings.NewReader(in))
 
 	c := Checker{
 		Cache:               MakeSimpleCache(10 * time.Minute),
-		lookupMXOverride:    mockLookupMX,
+		Resolver:            mockResolver{},
 		CheckHostname:       mockCheckHostname,
 		checkMTASTSOverride: mockCheckMTASTS,
 	}
 	totals := AggregatedScan{}
 	c.CheckCSV(reader, &totals, 0)
 
-	if totals.Attempted != 6 {
-		t.Errorf("Expected 6 attempted connections, got %d", totals.Attempted)
+	if totals.Attempted != 7 {
+		t.Errorf("Expected 7 attempted connections, got %d", totals.Attempted)
+	}
+	if totals.NullMX != 1 {
+		t.Errorf("Expected 1 domain with a null MX, got %d", totals.NullMX)
 	}
 	if totals.WithMXs != 5 {
 		t.Errorf("Expected 5 domains with MXs, got %d", totals.WithMXs)
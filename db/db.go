@@ -8,6 +8,7 @@ import (
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/models"
 	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/tlsrpt"
 )
 
 // Database interface: These are the things that the Database should be able to do.
@@ -33,6 +34,10 @@ type Database interface {
 	GetHostnameScan(string) (checker.HostnameResult, error)
 	// Enters a hostname scan.
 	PutHostnameScan(string, checker.HostnameResult) error
+	// Retrieves the MTA-STS record id and policy last seen for a domain.
+	GetMTASTSSnapshot(string) (checker.MTASTSSnapshot, error)
+	// Upserts the MTA-STS record id and policy seen for a domain.
+	PutMTASTSSnapshot(string, checker.MTASTSSnapshot) error
 	// Writes an aggregated scan to the database
 	PutAggregatedScan(checker.AggregatedScan) error
 	// Caches stats for the 14 days preceding time.Time
@@ -45,6 +50,10 @@ type Database interface {
 	GetDomain(string, models.DomainState) (models.Domain, error)
 	// Retrieves all domains in a particular state.
 	GetDomains(models.DomainState) ([]models.Domain, error)
+	// Stores a TLS-RPT aggregate report, once for each of its policy domains.
+	PutTLSRPTReport(tlsrpt.Report) error
+	// Retrieves the TLS-RPT reports for a policy domain that end after time.Time.
+	GetTLSRPTReports(string, time.Time) ([]tlsrpt.Report, error)
 	SetStatus(string, models.DomainState) error
 	RemoveDomain(string, models.DomainState) (models.Domain, error)
 	ClearTables() error
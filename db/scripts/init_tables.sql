@@ -110,3 +110,26 @@ BEGIN;
     ALTER TABLE aggregated_scans DROP CONSTRAINT aggregated_scans_time_source_key;
     ALTER TABLE aggregated_scans ADD UNIQUE (time, source);
 COMMIT;
+
+CREATE TABLE IF NOT EXISTS tlsrpt_reports
+(
+    id                SERIAL PRIMARY KEY,
+    organization_name TEXT NOT NULL,
+    report_id         TEXT NOT NULL,
+    policy_domain     TEXT NOT NULL,
+    start_datetime    TIMESTAMP NOT NULL,
+    end_datetime      TIMESTAMP NOT NULL,
+    report            TEXT NOT NULL,
+    received          TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_name, report_id, policy_domain)
+);
+
+CREATE TABLE IF NOT EXISTS mta_sts_snapshots
+(
+    domain      TEXT NOT NULL PRIMARY KEY,
+    policy_id   TEXT NOT NULL,
+    policy      TEXT NOT NULL,
+    timestamp   TIMESTAMP NOT NULL
+);
+
+ALTER TABLE IF EXISTS aggregated_scans ADD COLUMN IF NOT EXISTS null_mx INTEGER DEFAULT 0;
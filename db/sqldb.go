@@ -13,6 +13,7 @@ import (
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/models"
 	"github.com/EFForg/starttls-backend/stats"
+	"github.com/EFForg/starttls-backend/tlsrpt"
 
 	// Imports postgresql driver for database/sql
 	_ "github.com/lib/pq"
@@ -300,6 +301,8 @@ func (db SQLDatabase) ClearTables() error {
 		fmt.Sprintf("DELETE FROM %s", "hostname_scans"),
 		fmt.Sprintf("DELETE FROM %s", "blacklisted_emails"),
 		fmt.Sprintf("DELETE FROM %s", "aggregated_scans"),
+		fmt.Sprintf("DELETE FROM %s", "tlsrpt_reports"),
+		fmt.Sprintf("DELETE FROM %s", "mta_sts_snapshots"),
 		fmt.Sprintf("ALTER SEQUENCE %s_id_seq RESTART WITH 1", db.cfg.DbScanTable),
 	})
 }
@@ -378,13 +381,17 @@ func (db *SQLDatabase) GetHostnameScan(hostname string) (checker.HostnameResult,
 	if err != nil {
 		return result, err
 	}
-	err = json.Unmarshal(rawScanData, &result.Checks)
+	err = json.Unmarshal(rawScanData, &result)
+	if err != nil || len(result.Checks) == 0 {
+		// Older scans only stored the hostname's checks.
+		err = json.Unmarshal(rawScanData, &result.Checks)
+	}
 	return result, err
 }
 
 // PutHostnameScan puts this scan into the database.
 func (db *SQLDatabase) PutHostnameScan(hostname string, result checker.HostnameResult) error {
-	data, err := json.Marshal(result.Checks)
+	data, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
@@ -393,12 +400,82 @@ func (db *SQLDatabase) PutHostnameScan(hostname string, result checker.HostnameR
 	return err
 }
 
+// GetMTASTSSnapshot retrieves the MTA-STS record id and policy last seen
+// for domain. Returns checker.ErrNoMTASTSSnapshot if there isn't one.
+func (db *SQLDatabase) GetMTASTSSnapshot(domain string) (checker.MTASTSSnapshot, error) {
+	snapshot := checker.MTASTSSnapshot{}
+	err := db.conn.QueryRow(`SELECT policy_id, policy, timestamp FROM mta_sts_snapshots
+                    WHERE domain=$1`, domain).Scan(&snapshot.ID, &snapshot.Policy, &snapshot.Time)
+	if err == sql.ErrNoRows {
+		return snapshot, checker.ErrNoMTASTSSnapshot
+	}
+	return snapshot, err
+}
+
+// PutMTASTSSnapshot upserts the MTA-STS record id and policy seen for domain.
+func (db *SQLDatabase) PutMTASTSSnapshot(domain string, snapshot checker.MTASTSSnapshot) error {
+	_, err := db.conn.Exec(`INSERT INTO mta_sts_snapshots(domain, policy_id, policy, timestamp)
+                                VALUES($1, $2, $3, $4)
+                                ON CONFLICT (domain) DO UPDATE SET
+                                policy_id=$2, policy=$3, timestamp=$4`,
+		domain, snapshot.ID, snapshot.Policy, snapshot.Time)
+	return err
+}
+
 // PutAggregatedScan writes and AggregatedScan to the db.
 func (db *SQLDatabase) PutAggregatedScan(a checker.AggregatedScan) error {
 	_, err := db.conn.Exec(`INSERT INTO
-		aggregated_scans(time, source, attempted, with_mxs, mta_sts_testing, mta_sts_enforce)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		aggregated_scans(time, source, attempted, with_mxs, null_mx, mta_sts_testing, mta_sts_enforce)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (time,source) DO NOTHING`,
-		a.Time, a.Source, a.Attempted, a.WithMXs, a.MTASTSTesting, a.MTASTSEnforce)
+		a.Time, a.Source, a.Attempted, a.WithMXs, a.NullMX, a.MTASTSTesting, a.MTASTSEnforce)
 	return err
 }
+
+// TLS-RPT REPORT DB FUNCTIONS
+
+// PutTLSRPTReport stores a TLS-RPT aggregate report, once for each of its
+// policy domains. Reports that were already received are ignored.
+func (db *SQLDatabase) PutTLSRPTReport(report tlsrpt.Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	for _, domain := range report.PolicyDomains() {
+		_, err := db.conn.Exec(`INSERT INTO
+			tlsrpt_reports(organization_name, report_id, policy_domain, start_datetime, end_datetime, report)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (organization_name, report_id, policy_domain) DO NOTHING`,
+			report.OrganizationName, report.ReportID, domain,
+			report.DateRange.StartDatetime.UTC(), report.DateRange.EndDatetime.UTC(), string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTLSRPTReports retrieves the TLS-RPT reports for a policy domain whose
+// date range ends after `since`.
+func (db *SQLDatabase) GetTLSRPTReports(policyDomain string, since time.Time) ([]tlsrpt.Report, error) {
+	rows, err := db.conn.Query(`SELECT report FROM tlsrpt_reports
+		WHERE policy_domain=$1 AND end_datetime > $2
+		ORDER BY end_datetime`, strings.ToLower(policyDomain), since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := []tlsrpt.Report{}
+	for rows.Next() {
+		var rawReport []byte
+		if err := rows.Scan(&rawReport); err != nil {
+			return nil, err
+		}
+		var report tlsrpt.Report
+		if err := json.Unmarshal(rawReport, &report); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
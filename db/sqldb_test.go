@@ -10,6 +10,7 @@ import (
 	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/db"
 	"github.com/EFForg/starttls-backend/models"
+	"github.com/EFForg/starttls-backend/tlsrpt"
 	"github.com/joho/godotenv"
 )
 
@@ -330,6 +331,9 @@ func TestGetHostnameScan(t *testing.T) {
 			Timestamp: now,
 			Hostname:  "hello",
 			Result:    &checker.Result{Status: 1, Checks: checksMap},
+			Addresses: []checker.AddressResult{
+				{Result: &checker.Result{Status: 1, Checks: checksMap}, Address: "192.0.2.1", Network: "ipv4"},
+			},
 		},
 	)
 	result, err := database.GetHostnameScan("hello")
@@ -342,6 +346,9 @@ func TestGetHostnameScan(t *testing.T) {
 	if result.Status != 1 || checksMap["test"].Name != result.Checks["test"].Name {
 		t.Errorf("Expected hostname scan to return correct data")
 	}
+	if len(result.Addresses) != 1 || result.Addresses[0].Address != "192.0.2.1" {
+		t.Errorf("Expected hostname scan to return address results, got %v", result.Addresses)
+	}
 }
 
 func dateMustParse(date string, t *testing.T) time.Time {
@@ -353,6 +360,27 @@ func dateMustParse(date string, t *testing.T) time.Time {
 	return parsed
 }
 
+func TestPutGetMTASTSSnapshot(t *testing.T) {
+	database.ClearTables()
+	if _, err := database.GetMTASTSSnapshot("example.com"); err != checker.ErrNoMTASTSSnapshot {
+		t.Errorf("Expected ErrNoMTASTSSnapshot getting snapshot for unscanned domain, got %v", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		err := database.PutMTASTSSnapshot("example.com", checker.MTASTSSnapshot{
+			ID: id, Policy: "version: STSv1\n", Time: time.Now()})
+		if err != nil {
+			t.Fatalf("PutMTASTSSnapshot failed: %v", err)
+		}
+	}
+	snapshot, err := database.GetMTASTSSnapshot("example.com")
+	if err != nil {
+		t.Fatalf("GetMTASTSSnapshot failed: %v", err)
+	}
+	if snapshot.ID != "2" || snapshot.Policy != "version: STSv1\n" {
+		t.Errorf("Expected latest snapshot, got %v", snapshot)
+	}
+}
+
 func TestGetStats(t *testing.T) {
 	database.ClearTables()
 	may1 := dateMustParse("2019-May-01", t)
@@ -494,3 +522,38 @@ func TestGetMTASTSDomains(t *testing.T) {
 		}
 	}
 }
+
+func TestPutGetTLSRPTReports(t *testing.T) {
+	database.ClearTables()
+	now := time.Now().UTC().Truncate(time.Second)
+	report := tlsrpt.Report{
+		OrganizationName: "Company-X",
+		ReportID:         "report-1",
+		DateRange:        tlsrpt.DateRange{StartDatetime: now.Add(-24 * time.Hour), EndDatetime: now},
+		Policies: []tlsrpt.PolicyResults{
+			{Policy: tlsrpt.Policy{PolicyType: "sts", PolicyDomain: "company-y.example"}},
+			{Policy: tlsrpt.Policy{PolicyType: "sts", PolicyDomain: "company-z.example"}},
+		},
+	}
+	if err := database.PutTLSRPTReport(report); err != nil {
+		t.Fatalf("PutTLSRPTReport failed: %v", err)
+	}
+	// Reports are only stored once.
+	if err := database.PutTLSRPTReport(report); err != nil {
+		t.Fatalf("PutTLSRPTReport failed on duplicate report: %v", err)
+	}
+	reports, err := database.GetTLSRPTReports("Company-Y.example", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetTLSRPTReports failed: %v", err)
+	}
+	if len(reports) != 1 || reports[0].ReportID != "report-1" {
+		t.Errorf("Expected one report for company-y.example, got %v", reports)
+	}
+	reports, err = database.GetTLSRPTReports("company-y.example", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetTLSRPTReports failed: %v", err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("Expected no reports ending after an hour from now, got %v", reports)
+	}
+}
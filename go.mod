@@ -10,9 +10,11 @@ require (
 	github.com/joho/godotenv v1.3.0
 	github.com/lib/pq v1.1.1
 	github.com/mhale/smtpd v0.0.0-20181125220505-3c4c908952b8
+	github.com/miekg/dns v1.1.25
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
 	github.com/ulule/limiter v2.2.2+incompatible
-	golang.org/x/net v0.0.0-20190611141213-3f473d35a33a
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392
+	golang.org/x/net v0.0.0-20190923162816-aa69164e4478
 )
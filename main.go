@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/EFForg/starttls-backend/api"
+	"github.com/EFForg/starttls-backend/checker"
 	"github.com/EFForg/starttls-backend/db"
 	"github.com/EFForg/starttls-backend/email"
 	"github.com/EFForg/starttls-backend/policy"
@@ -74,6 +76,21 @@ func loadDontScan() map[string]bool {
 	return domainset
 }
 
+// Loads the root certificates that scans verify certificates against from
+// the PEM bundle at `ROOT_CAS`. If `ROOT_CAS` is not set, returns nil, so
+// that the system roots are used.
+func loadRootCAs() *x509.CertPool {
+	path := os.Getenv("ROOT_CAS")
+	if len(path) == 0 {
+		return nil
+	}
+	roots, err := checker.LoadRootCAs(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return roots
+}
+
 func main() {
 	raven.SetDSN(os.Getenv("SENTRY_URL"))
 
@@ -91,20 +108,29 @@ func main() {
 		log.Println("======NOT SENDING EMAIL======")
 	}
 	list := policy.MakeUpdatedList()
+	roots := loadRootCAs()
 	a := api.API{
-		Database: db,
-		List:     list,
-		DontScan: loadDontScan(),
-		Emailer:  emailConfig,
+		Database:   db,
+		List:       list,
+		DontScan:   loadDontScan(),
+		Emailer:    emailConfig,
+		RootCAs:    roots,
+		TrustStore: os.Getenv("ROOT_CAS"),
+		// Reports can only be posted by a relay that knows this secret.
+		TLSRPTSecret: os.Getenv("TLSRPT_SECRET"),
 	}
 	a.ParseTemplates("views")
 	if os.Getenv("VALIDATE_LIST") == "1" {
 		log.Println("[Starting list validator]")
-		go validator.ValidateRegularly("Live policy list", list, 24*time.Hour)
+		v := validator.Validator{Name: "Live policy list", Store: list, Interval: 24 * time.Hour,
+			RootCAs: roots, TrustStore: os.Getenv("ROOT_CAS")}
+		go v.Run()
 	}
 	if os.Getenv("VALIDATE_QUEUED") == "1" {
 		log.Println("[Starting queued validator]")
-		go validator.ValidateRegularly("Testing domains", db, 24*time.Hour)
+		v := validator.Validator{Name: "Testing domains", Store: db, Interval: 24 * time.Hour,
+			RootCAs: roots, TrustStore: os.Getenv("ROOT_CAS")}
+		go v.Run()
 	}
 	go stats.UpdateRegularly(db, time.Hour)
 	ServePublicEndpoints(&a, &cfg)
@@ -119,19 +119,19 @@ func (d *Domain) PolicyListCheck(store domainStore, list policyList) *checker.Re
 	}
 	domain, err := GetDomain(store, d.Name)
 	if err != nil {
-		return result.Failure("Domain %s is not on the policy list.", d.Name)
+		return result.Code("policylist.not_listed").Failure("Domain %s is not on the policy list.", d.Name)
 	}
 	if domain.State == StateEnforce {
 		log.Println("Warning: Domain was StateEnforce in DB but was not found on the policy list.")
 		return result.Success()
 	}
 	if domain.State == StateTesting {
-		return result.Warning("Domain %s is queued to be added to the policy list.", d.Name)
+		return result.Code("policylist.queued").Warning("Domain %s is queued to be added to the policy list.", d.Name)
 	}
 	if domain.State == StateUnconfirmed {
-		return result.Failure("The policy addition request for %s is waiting on email validation", d.Name)
+		return result.Code("policylist.awaiting_validation").Failure("The policy addition request for %s is waiting on email validation", d.Name)
 	}
-	return result.Failure("Domain %s is not on the policy list.", d.Name)
+	return result.Code("policylist.not_listed").Failure("Domain %s is not on the policy list.", d.Name)
 }
 
 // AsyncPolicyListCheck performs PolicyListCheck asynchronously.
@@ -38,3 +38,21 @@ func (s Scan) SupportsMTASTS() bool {
 	}
 	return s.Data.MTASTSResult.Status == checker.Success || s.Data.MTASTSResult.Status == checker.Warning
 }
+
+// ScanDiff is what changed between two scans of a domain.
+type ScanDiff struct {
+	Domain  string                   `json:"domain"`
+	From    time.Time                `json:"from"` // Time of the older scan
+	To      time.Time                `json:"to"`   // Time of the newer scan
+	Changes checker.DomainResultDiff `json:"changes"`
+}
+
+// DiffScans returns what changed from one scan of a domain to another.
+func DiffScans(from, to Scan) ScanDiff {
+	return ScanDiff{
+		Domain:  to.Domain,
+		From:    from.Timestamp,
+		To:      to.Timestamp,
+		Changes: checker.DiffDomainResults(from.Data, to.Data),
+	}
+}
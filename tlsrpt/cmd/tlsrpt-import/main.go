@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/EFForg/starttls-backend/db"
+	"github.com/EFForg/starttls-backend/tlsrpt"
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Imports the TLS-RPT aggregate reports attached to an email into the
+// database specified by ENV. The email is read from -file, or from stdin so
+// that reports sent to a mailto: rua address can be piped to this command.
+func main() {
+	filePath := flag.String("file", "", "File path to an email containing TLS-RPT reports (default stdin)")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if *filePath != "" {
+		f, err := os.Open(*filePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	reports, err := tlsrpt.ParseMail(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := db.LoadEnvironmentVariables()
+	if err != nil {
+		log.Fatal(err)
+	}
+	database, err := db.InitSQLDatabase(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, report := range reports {
+		if err := database.PutTLSRPTReport(report); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Imported report %s from %s for %v\n", report.ReportID, report.OrganizationName, report.PolicyDomains())
+	}
+}
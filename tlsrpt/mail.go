@@ -0,0 +1,70 @@
+package tlsrpt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ParseMail reads the reports attached to an email, which senders use to
+// deliver reports to mailto: URIs.
+// https://tools.ietf.org/html/rfc8460#section-5.3
+func ParseMail(r io.Reader) ([]Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read message: %v", err)
+	}
+	reports, err := parsePart(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no TLS-RPT report found in message")
+	}
+	return reports, nil
+}
+
+// parsePart returns the reports found in a MIME part, recursing into
+// multipart parts.
+func parsePart(header textproto.MIMEHeader, body io.Reader) ([]Report, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// Parts without a valid Content-Type are plain text.
+		return nil, nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reports := []Report{}
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return reports, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read MIME part: %v", err)
+			}
+			partReports, err := parsePart(part.Header, part)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, partReports...)
+		}
+	}
+	if mediaType != MediaTypeJSON && mediaType != MediaTypeGzip {
+		return nil, nil
+	}
+	// multipart.Reader decodes quoted-printable parts, but not base64 ones.
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	report, err := ParseMediaType(mediaType, body)
+	if err != nil {
+		return nil, err
+	}
+	return []Report{report}, nil
+}
@@ -0,0 +1,88 @@
+package tlsrpt
+
+import (
+	"strings"
+	"time"
+)
+
+// Store wraps storage for aggregate reports.
+type Store interface {
+	// Stores a report, once for each of its policy domains.
+	PutTLSRPTReport(Report) error
+	// Retrieves the reports for a policy domain whose date range ends after
+	// the given time.
+	GetTLSRPTReports(string, time.Time) ([]Report, error)
+}
+
+// Summary totals the sessions reported for one policy domain.
+type Summary struct {
+	PolicyDomain string `json:"policy_domain"`
+	// Number of reports received.
+	Reports            int   `json:"reports"`
+	SuccessfulSessions int64 `json:"successful_sessions"`
+	FailedSessions     int64 `json:"failed_sessions"`
+	// Number of failed sessions of each result type, such as
+	// "starttls-not-supported" or "certificate-expired".
+	FailureTypes map[string]int64 `json:"failure_types"`
+	// Number of sessions reported under each policy type: "sts", "tlsa" or
+	// "no-policy-found".
+	PolicyTypes map[string]int64 `json:"policy_types"`
+	// Organizations that sent reports.
+	Organizations []string  `json:"organizations"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+}
+
+// Summarize totals the sessions that reports counted for policyDomain.
+func Summarize(policyDomain string, reports []Report) Summary {
+	policyDomain = strings.ToLower(strings.TrimSuffix(policyDomain, "."))
+	summary := Summary{
+		PolicyDomain:  policyDomain,
+		FailureTypes:  make(map[string]int64),
+		PolicyTypes:   make(map[string]int64),
+		Organizations: []string{},
+	}
+	organizations := make(map[string]bool)
+	for _, report := range reports {
+		counted := false
+		for _, policy := range report.Policies {
+			domain := strings.ToLower(strings.TrimSuffix(policy.Policy.PolicyDomain, "."))
+			if domain != policyDomain {
+				continue
+			}
+			counted = true
+			summary.SuccessfulSessions += policy.Summary.TotalSuccessfulSessionCount
+			summary.FailedSessions += policy.Summary.TotalFailureSessionCount
+			summary.PolicyTypes[policy.Policy.PolicyType] +=
+				policy.Summary.TotalSuccessfulSessionCount + policy.Summary.TotalFailureSessionCount
+			for _, failure := range policy.FailureDetails {
+				summary.FailureTypes[failure.ResultType] += failure.FailedSessionCount
+			}
+		}
+		if !counted {
+			continue
+		}
+		summary.Reports++
+		if !organizations[report.OrganizationName] {
+			organizations[report.OrganizationName] = true
+			summary.Organizations = append(summary.Organizations, report.OrganizationName)
+		}
+		if summary.Start.IsZero() || report.DateRange.StartDatetime.Before(summary.Start) {
+			summary.Start = report.DateRange.StartDatetime
+		}
+		if report.DateRange.EndDatetime.After(summary.End) {
+			summary.End = report.DateRange.EndDatetime
+		}
+	}
+	return summary
+}
+
+// GetSummary summarizes the reports stored for policyDomain whose date range
+// ends after since.
+func GetSummary(store Store, policyDomain string, since time.Time) (Summary, error) {
+	reports, err := store.GetTLSRPTReports(policyDomain, since)
+	if err != nil {
+		return Summary{}, err
+	}
+	return Summarize(policyDomain, reports), nil
+}
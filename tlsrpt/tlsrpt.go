@@ -0,0 +1,147 @@
+package tlsrpt
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Media types of aggregate reports.
+// https://tools.ietf.org/html/rfc8460#section-6
+const (
+	MediaTypeJSON = "application/tlsrpt+json"
+	MediaTypeGzip = "application/tlsrpt+gzip"
+)
+
+// Maximum size of a decompressed report. RFC 8460 doesn't set a limit, but
+// reports from the largest senders are well under a megabyte.
+const maxReportSize = 10 * 1024 * 1024
+
+// Report is an SMTP TLS aggregate report.
+// https://tools.ietf.org/html/rfc8460#section-4.4
+type Report struct {
+	OrganizationName string          `json:"organization-name"`
+	DateRange        DateRange       `json:"date-range"`
+	ContactInfo      string          `json:"contact-info"`
+	ReportID         string          `json:"report-id"`
+	Policies         []PolicyResults `json:"policies"`
+}
+
+// DateRange is the period covered by a Report.
+type DateRange struct {
+	StartDatetime time.Time `json:"start-datetime"`
+	EndDatetime   time.Time `json:"end-datetime"`
+}
+
+// PolicyResults holds the results of the sessions that a sender attempted
+// under one policy.
+type PolicyResults struct {
+	Policy         Policy           `json:"policy"`
+	Summary        SessionSummary   `json:"summary"`
+	FailureDetails []FailureDetails `json:"failure-details,omitempty"`
+}
+
+// Policy describes the policy that the sender applied. PolicyType is one of
+// "sts", "tlsa" or "no-policy-found".
+type Policy struct {
+	PolicyType   string     `json:"policy-type"`
+	PolicyString []string   `json:"policy-string,omitempty"`
+	PolicyDomain string     `json:"policy-domain"`
+	MXHost       stringList `json:"mx-host,omitempty"`
+}
+
+// SessionSummary counts the sessions that a sender attempted under a policy.
+type SessionSummary struct {
+	TotalSuccessfulSessionCount int64 `json:"total-successful-session-count"`
+	TotalFailureSessionCount    int64 `json:"total-failure-session-count"`
+}
+
+// FailureDetails describes one type of failure that a sender encountered.
+// ResultType is a failure type such as "starttls-not-supported",
+// "certificate-expired" or "sts-policy-fetch-error".
+type FailureDetails struct {
+	ResultType            string `json:"result-type"`
+	SendingMTAIP          string `json:"sending-mta-ip,omitempty"`
+	ReceivingMXHostname   string `json:"receiving-mx-hostname,omitempty"`
+	ReceivingMXHelo       string `json:"receiving-mx-helo,omitempty"`
+	ReceivingIP           string `json:"receiving-ip,omitempty"`
+	FailedSessionCount    int64  `json:"failed-session-count"`
+	AdditionalInformation string `json:"additional-information,omitempty"`
+	FailureReasonCode     string `json:"failure-reason-code,omitempty"`
+}
+
+// stringList is a list of strings which may also be encoded as a single
+// string. RFC 8460's example report encodes "mx-host" as a string, while most
+// senders use a list.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = stringList(list)
+	return nil
+}
+
+// PolicyDomains returns each of the policy domains covered by the report.
+func (r Report) PolicyDomains() []string {
+	domains := []string{}
+	seen := make(map[string]bool)
+	for _, policy := range r.Policies {
+		domain := strings.ToLower(strings.TrimSuffix(policy.Policy.PolicyDomain, "."))
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// Parse reads a JSON-encoded report.
+func Parse(r io.Reader) (Report, error) {
+	var report Report
+	decoder := json.NewDecoder(io.LimitReader(r, maxReportSize))
+	if err := decoder.Decode(&report); err != nil {
+		return report, fmt.Errorf("couldn't parse report: %v", err)
+	}
+	if report.ReportID == "" || len(report.Policies) == 0 {
+		return report, fmt.Errorf("report must have a report-id and at least one policy")
+	}
+	for _, policy := range report.Policies {
+		if policy.Policy.PolicyDomain == "" {
+			return report, fmt.Errorf("report %s has a policy without a policy-domain", report.ReportID)
+		}
+	}
+	return report, nil
+}
+
+// ParseGzip reads a gzipped, JSON-encoded report.
+func ParseGzip(r io.Reader) (Report, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Report{}, fmt.Errorf("couldn't decompress report: %v", err)
+	}
+	defer gz.Close()
+	return Parse(gz)
+}
+
+// ParseMediaType reads a report encoded as mediaType, which should be either
+// MediaTypeJSON or MediaTypeGzip.
+func ParseMediaType(mediaType string, r io.Reader) (Report, error) {
+	switch strings.ToLower(mediaType) {
+	case MediaTypeJSON:
+		return Parse(r)
+	case MediaTypeGzip:
+		return ParseGzip(r)
+	}
+	return Report{}, fmt.Errorf("unsupported media type %s, expected %s or %s", mediaType, MediaTypeJSON, MediaTypeGzip)
+}
@@ -0,0 +1,202 @@
+package tlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// Adapted from https://tools.ietf.org/html/rfc8460#appendix-B
+const sampleReport = `{
+  "organization-name": "Company-X",
+  "date-range": {
+    "start-datetime": "2016-04-01T00:00:00Z",
+    "end-datetime": "2016-04-01T23:59:59Z"
+  },
+  "contact-info": "sts-reporting@company-x.example",
+  "report-id": "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+  "policies": [{
+    "policy": {
+      "policy-type": "sts",
+      "policy-string": ["version: STSv1","mode: testing",
+            "mx: *.mail.company-y.example","max_age: 86400"],
+      "policy-domain": "company-y.example",
+      "mx-host": "*.mail.company-y.example"
+    },
+    "summary": {
+      "total-successful-session-count": 5326,
+      "total-failure-session-count": 303
+    },
+    "failure-details": [{
+      "result-type": "certificate-expired",
+      "sending-mta-ip": "2001:db8:abcd:0012::1",
+      "receiving-mx-hostname": "mx1.mail.company-y.example",
+      "failed-session-count": 100
+    }, {
+      "result-type": "starttls-not-supported",
+      "sending-mta-ip": "2001:db8:abcd:0013::1",
+      "receiving-mx-hostname": "mx2.mail.company-y.example",
+      "receiving-ip": "203.0.113.56",
+      "failed-session-count": 200,
+      "additional-information": "https://reports.company-x.example/report_info?id=5065427c-23d3#StarttlsNotSupported"
+    }, {
+      "result-type": "validation-failure",
+      "sending-mta-ip": "198.51.100.62",
+      "receiving-ip": "203.0.113.58",
+      "receiving-mx-hostname": "mx-backup.mail.company-y.example",
+      "failed-session-count": 3,
+      "failure-reason-code": "X509_V_ERR_PROXY_PATH_LENGTH_EXCEEDED"
+    }]
+  }]
+}`
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkSampleReport(t *testing.T, report Report) {
+	if report.ReportID != "5065427c-23d3-47ca-b6e0-946ea0e8c4be" {
+		t.Errorf("Unexpected report-id %s", report.ReportID)
+	}
+	if len(report.Policies) != 1 || len(report.Policies[0].FailureDetails) != 3 {
+		t.Fatalf("Expected one policy with three failure details, got %+v", report.Policies)
+	}
+	policy := report.Policies[0]
+	if len(policy.Policy.MXHost) != 1 || policy.Policy.MXHost[0] != "*.mail.company-y.example" {
+		t.Errorf("Expected mx-host string to be parsed as a list, got %v", policy.Policy.MXHost)
+	}
+	if policy.Summary.TotalFailureSessionCount != 303 {
+		t.Errorf("Expected 303 failed sessions, got %d", policy.Summary.TotalFailureSessionCount)
+	}
+}
+
+func TestParse(t *testing.T) {
+	report, err := Parse(strings.NewReader(sampleReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSampleReport(t, report)
+}
+
+func TestParseGzip(t *testing.T) {
+	report, err := ParseGzip(bytes.NewReader(gzipped(t, sampleReport)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSampleReport(t, report)
+	if _, err := ParseGzip(strings.NewReader(sampleReport)); err == nil {
+		t.Error("Expected error parsing uncompressed report as gzip")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	var tests = []string{
+		``,
+		`{"report-id": "a"`,
+		`{"report-id": "a", "policies": []}`,
+		`{"policies": [{"policy": {"policy-domain": "example.com"}}]}`,
+		`{"report-id": "a", "policies": [{"policy": {"policy-type": "sts"}}]}`,
+	}
+	for _, test := range tests {
+		if _, err := Parse(strings.NewReader(test)); err == nil {
+			t.Errorf("Expected error parsing %q", test)
+		}
+	}
+}
+
+func TestParseMXHostList(t *testing.T) {
+	report, err := Parse(strings.NewReader(`{"report-id": "a", "policies": [{"policy": {
+		"policy-domain": "example.com", "mx-host": ["mx1.example.com", "mx2.example.com"]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Policies[0].Policy.MXHost) != 2 {
+		t.Errorf("Expected two mx-hosts, got %v", report.Policies[0].Policy.MXHost)
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	if _, err := ParseMediaType("application/tlsrpt+json", strings.NewReader(sampleReport)); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseMediaType("application/tlsrpt+gzip", bytes.NewReader(gzipped(t, sampleReport))); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseMediaType("application/json", strings.NewReader(sampleReport)); err == nil {
+		t.Error("Expected error parsing unsupported media type")
+	}
+}
+
+func TestParseMail(t *testing.T) {
+	attachment := base64.StdEncoding.EncodeToString(gzipped(t, sampleReport))
+	msg := "From: tlsrpt-noreply@company-x.example\r\n" +
+		"To: sts-reports@company-y.example\r\n" +
+		"Subject: Report Domain: company-y.example Submitter: company-x.example Report-ID: <5065427c-23d3>\r\n" +
+		"TLS-Report-Domain: company-y.example\r\n" +
+		"TLS-Report-Submitter: company-x.example\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/report; report-type=\"tlsrpt\"; boundary=\"----=_NextPart_000_024E_01CC9B0A.AFE54C00\"\r\n" +
+		"\r\n" +
+		"------=_NextPart_000_024E_01CC9B0A.AFE54C00\r\n" +
+		"Content-Type: text/plain; charset=\"us-ascii\"\r\n" +
+		"\r\n" +
+		"This is an aggregate TLS report from company-x.example\r\n" +
+		"\r\n" +
+		"------=_NextPart_000_024E_01CC9B0A.AFE54C00\r\n" +
+		"Content-Type: application/tlsrpt+gzip\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"company-x.example!company-y.example!1470013207!1470186007!151006.json.gz\"\r\n" +
+		"\r\n" +
+		attachment + "\r\n" +
+		"------=_NextPart_000_024E_01CC9B0A.AFE54C00--\r\n"
+	reports, err := ParseMail(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected one report, got %d", len(reports))
+	}
+	checkSampleReport(t, reports[0])
+
+	noReport := "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nHello\r\n"
+	if _, err := ParseMail(strings.NewReader(noReport)); err == nil {
+		t.Error("Expected error parsing message without a report")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	report, err := Parse(strings.NewReader(sampleReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := report
+	other.OrganizationName = "Company-Z"
+	other.ReportID = "other"
+	summary := Summarize("Company-Y.example.", []Report{report, other})
+	if summary.PolicyDomain != "company-y.example" || summary.Reports != 2 {
+		t.Errorf("Expected two reports for company-y.example, got %+v", summary)
+	}
+	if summary.SuccessfulSessions != 2*5326 || summary.FailedSessions != 2*303 {
+		t.Errorf("Unexpected session counts %+v", summary)
+	}
+	if summary.FailureTypes["starttls-not-supported"] != 400 {
+		t.Errorf("Expected 400 starttls-not-supported failures, got %d", summary.FailureTypes["starttls-not-supported"])
+	}
+	if len(summary.Organizations) != 2 {
+		t.Errorf("Expected two organizations, got %v", summary.Organizations)
+	}
+	empty := Summarize("other.example", []Report{report})
+	if empty.Reports != 0 || empty.SuccessfulSessions != 0 {
+		t.Errorf("Expected no sessions for other.example, got %+v", empty)
+	}
+}
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"crypto/x509"
 	"fmt"
 	"log"
 	"time"
@@ -47,16 +48,28 @@ type Validator struct {
 	OnFailure resultCallback
 	// OnSuccess: optional. Called when a particular policy validation succeeds.
 	OnSuccess resultCallback
+	// RootCAs: optional. Roots that certificates must chain to, as in
+	// checker.Checker. If nil, the system's roots are used.
+	RootCAs *x509.CertPool
+	// TrustStore: optional. Names RootCAs in results, as in checker.Checker.
+	TrustStore string
 	// checkPerformer: performs the check.
 	checkPerformer checkPerformer
 }
 
+// newChecker returns the Checker that validates policies, unless
+// checkPerformer is set.
+func (v *Validator) newChecker() *checker.Checker {
+	return &checker.Checker{
+		Cache:      checker.MakeSimpleCache(time.Hour),
+		RootCAs:    v.RootCAs,
+		TrustStore: v.TrustStore,
+	}
+}
+
 func (v *Validator) checkPolicy(domain string, hostnames []string) checker.DomainResult {
 	if v.checkPerformer == nil {
-		c := checker.Checker{
-			Cache: checker.MakeSimpleCache(time.Hour),
-		}
-		v.checkPerformer = c.CheckDomain
+		v.checkPerformer = v.newChecker().CheckDomain
 	}
 	return v.checkPerformer(domain, hostnames)
 }
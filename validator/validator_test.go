@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"crypto/x509"
 	"testing"
 	"time"
 
@@ -93,3 +94,12 @@ func TestRegularValidationReportsErrors(t *testing.T) {
 		t.Errorf("Didn't expect normal to be reported as failure")
 	}
 }
+
+func TestValidatorUsesRootCAs(t *testing.T) {
+	roots := x509.NewCertPool()
+	v := Validator{RootCAs: roots, TrustStore: "roots.pem"}
+	c := v.newChecker()
+	if c.RootCAs != roots || c.TrustStore != "roots.pem" {
+		t.Errorf("Expected the validator's roots to be used for checks, got %v, %q", c.RootCAs, c.TrustStore)
+	}
+}